@@ -0,0 +1,607 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// maxRequestBody bounds the JSON documents the API accepts
+const maxRequestBody = 1 << 20
+
+// API serves ConcertService and TicketService over HTTP, with admin
+// endpoints that inspect the event bus:
+//
+//	GET    /api/concerts                       list concerts
+//	POST   /api/concerts                       add a concert
+//	GET    /api/concerts/{id}                  one concert
+//	GET    /api/tickets[?concert=]             list tickets
+//	POST   /api/tickets                        request a ticket (202, pending)
+//	GET    /api/tickets/{id}                   one ticket
+//	GET    /api/customers/{id}/preferences     notification preferences
+//	PUT    /api/customers/{id}/preferences     set notification preferences
+//	GET    /api/customers/{id}/inbox           in-app messages, newest first
+//	POST   /api/customers/{id}/inbox/{msg}/read  mark an in-app message read
+//	GET    /admin/subscriptions[?type=]        live subscriptions by pattern
+//	GET    /admin/inflight                     running handlers and queues
+//	GET    /admin/errors                       handler error counts
+//	GET    /admin/events[?type=&limit=]        recent events, newest first
+//	GET    /admin/events/{id}/chain            the flow an event belongs to
+//	GET    /admin/dead-letters                 dead-lettered events
+//	POST   /admin/dead-letters/{id}/replay     redeliver a dead letter
+//	DELETE /admin/dead-letters/{id}            discard a dead letter
+//	GET    /admin/scheduled                    events scheduled for later
+//	POST   /admin/journal/compact?older_than=  drop old events Restore does not need
+//	GET    /admin/webhooks                     registered webhooks
+//	POST   /admin/webhooks                     register a webhook
+//	DELETE /admin/webhooks/{id}                unregister a webhook
+//	GET    /admin/webhooks/{id}/deliveries     a webhook's delivery log
+//
+// The admin endpoints have no authentication of their own; serve them on a
+// private address.
+type API struct {
+	eventBus *EventBus
+	concerts *ConcertService
+	tickets  *TicketService
+	notifier *Notifier
+	metrics  *Metrics
+	webhooks *WebhookDispatcher
+	mux      *http.ServeMux
+}
+
+// NewAPI creates the HTTP API. notifier, metrics and webhooks may be nil,
+// in which case customers' preferences and inboxes are not served, only
+// dead letters are counted as errors and webhooks are not served.
+func NewAPI(eb *EventBus, concerts *ConcertService, tickets *TicketService, notifier *Notifier, metrics *Metrics, webhooks *WebhookDispatcher) *API {
+	api := &API{
+		eventBus: eb,
+		concerts: concerts,
+		tickets:  tickets,
+		notifier: notifier,
+		metrics:  metrics,
+		webhooks: webhooks,
+		mux:      http.NewServeMux(),
+	}
+	api.mux.HandleFunc("GET /api/concerts", api.listConcerts)
+	api.mux.HandleFunc("POST /api/concerts", api.addConcert)
+	api.mux.HandleFunc("GET /api/concerts/{id}", api.getConcert)
+	api.mux.HandleFunc("GET /api/tickets", api.listTickets)
+	api.mux.HandleFunc("POST /api/tickets", api.purchaseTicket)
+	api.mux.HandleFunc("GET /api/tickets/{id}", api.getTicket)
+	if notifier != nil {
+		api.mux.HandleFunc("GET /api/customers/{id}/preferences", api.getPreferences)
+		api.mux.HandleFunc("PUT /api/customers/{id}/preferences", api.setPreferences)
+		if _, ok := notifier.Inbox(); ok {
+			api.mux.HandleFunc("GET /api/customers/{id}/inbox", api.listInbox)
+			api.mux.HandleFunc("POST /api/customers/{id}/inbox/{message}/read", api.markRead)
+		}
+	}
+
+	api.mux.HandleFunc("GET /admin/subscriptions", api.listSubscriptions)
+	api.mux.HandleFunc("GET /admin/inflight", api.listInFlight)
+	api.mux.HandleFunc("GET /admin/errors", api.listErrors)
+	api.mux.HandleFunc("GET /admin/events", api.listEvents)
+	api.mux.HandleFunc("GET /admin/events/{id}/chain", api.eventChain)
+	api.mux.HandleFunc("GET /admin/dead-letters", api.listDeadLetters)
+	api.mux.HandleFunc("POST /admin/dead-letters/{id}/replay", api.replayDeadLetter)
+	api.mux.HandleFunc("DELETE /admin/dead-letters/{id}", api.discardDeadLetter)
+	api.mux.HandleFunc("GET /admin/scheduled", api.listScheduled)
+	api.mux.HandleFunc("POST /admin/journal/compact", api.compactJournal)
+	if webhooks != nil {
+		api.mux.HandleFunc("GET /admin/webhooks", api.listWebhooks)
+		api.mux.HandleFunc("POST /admin/webhooks", api.registerWebhook)
+		api.mux.HandleFunc("DELETE /admin/webhooks/{id}", api.unregisterWebhook)
+		api.mux.HandleFunc("GET /admin/webhooks/{id}/deliveries", api.listWebhookDeliveries)
+	}
+	return api
+}
+
+// ServeHTTP implements http.Handler
+func (api *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	api.mux.ServeHTTP(w, r)
+}
+
+type concertJSON struct {
+	ID               string    `json:"id"`
+	Name             string    `json:"name"`
+	Date             time.Time `json:"date"`
+	Venue            string    `json:"venue"`
+	AvailableTickets int       `json:"available_tickets"`
+	TicketPrice      float64   `json:"ticket_price"`
+}
+
+func toConcertJSON(c Concert) concertJSON {
+	return concertJSON{
+		ID:               c.ID,
+		Name:             c.Name,
+		Date:             c.Date,
+		Venue:            c.Venue,
+		AvailableTickets: c.AvailableTickets,
+		TicketPrice:      c.TicketPrice,
+	}
+}
+
+type ticketJSON struct {
+	ID            string       `json:"id"`
+	ConcertID     string       `json:"concert_id"`
+	CustomerName  string       `json:"customer_name"`
+	CustomerEmail string       `json:"customer_email"`
+	PurchaseDate  time.Time    `json:"purchase_date"`
+	Status        TicketStatus `json:"status"`
+	VoidReason    string       `json:"void_reason,omitempty"`
+}
+
+func toTicketJSON(t Ticket) ticketJSON {
+	return ticketJSON{
+		ID:            t.ID,
+		ConcertID:     t.ConcertID,
+		CustomerName:  t.CustomerName,
+		CustomerEmail: t.CustomerEmail,
+		PurchaseDate:  t.PurchaseDate,
+		Status:        t.Status,
+		VoidReason:    t.VoidReason,
+	}
+}
+
+type eventJSON struct {
+	ID        string            `json:"id"`
+	Type      string            `json:"type"`
+	Timestamp time.Time         `json:"timestamp"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	Payload   interface{}       `json:"payload"`
+}
+
+func toEventJSONs(events []Event) []eventJSON {
+	out := make([]eventJSON, 0, len(events))
+	for _, e := range events {
+		out = append(out, eventJSON{ID: e.ID, Type: e.Type, Timestamp: e.Timestamp, Headers: e.Headers, Payload: e.Payload})
+	}
+	return out
+}
+
+func (api *API) listConcerts(w http.ResponseWriter, r *http.Request) {
+	concerts := api.concerts.Concerts()
+	sort.Slice(concerts, func(i, j int) bool {
+		if !concerts[i].Date.Equal(concerts[j].Date) {
+			return concerts[i].Date.Before(concerts[j].Date)
+		}
+		return concerts[i].ID < concerts[j].ID
+	})
+	out := make([]concertJSON, 0, len(concerts))
+	for _, c := range concerts {
+		out = append(out, toConcertJSON(c))
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (api *API) addConcert(w http.ResponseWriter, r *http.Request) {
+	var body concertJSON
+	if !readJSON(w, r, &body) {
+		return
+	}
+	switch {
+	case body.Name == "":
+		writeError(w, http.StatusBadRequest, errors.New("name is required"))
+		return
+	case body.Date.IsZero():
+		writeError(w, http.StatusBadRequest, errors.New("date is required"))
+		return
+	case body.AvailableTickets < 0 || body.TicketPrice < 0:
+		writeError(w, http.StatusBadRequest, errors.New("available_tickets and ticket_price must not be negative"))
+		return
+	}
+	concert := &Concert{
+		Name:             body.Name,
+		Date:             body.Date,
+		Venue:            body.Venue,
+		AvailableTickets: body.AvailableTickets,
+		TicketPrice:      body.TicketPrice,
+	}
+	if err := api.concerts.AddConcert(r.Context(), concert); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Location", "/api/concerts/"+concert.ID)
+	writeJSON(w, http.StatusCreated, toConcertJSON(*concert))
+}
+
+func (api *API) getConcert(w http.ResponseWriter, r *http.Request) {
+	concert, ok := api.concerts.Concert(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("concert %s not found", r.PathValue("id")))
+		return
+	}
+	writeJSON(w, http.StatusOK, toConcertJSON(concert))
+}
+
+func (api *API) listTickets(w http.ResponseWriter, r *http.Request) {
+	tickets := api.tickets.Tickets()
+	concertID := r.URL.Query().Get("concert")
+	sort.Slice(tickets, func(i, j int) bool {
+		if !tickets[i].PurchaseDate.Equal(tickets[j].PurchaseDate) {
+			return tickets[i].PurchaseDate.Before(tickets[j].PurchaseDate)
+		}
+		return tickets[i].ID < tickets[j].ID
+	})
+	out := make([]ticketJSON, 0, len(tickets))
+	for _, t := range tickets {
+		if concertID == "" || t.ConcertID == concertID {
+			out = append(out, toTicketJSON(t))
+		}
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// purchaseTicket answers 202 Accepted with the pending ticket; clients poll
+// its Location until ConcertService confirms or voids it
+func (api *API) purchaseTicket(w http.ResponseWriter, r *http.Request) {
+	var body ticketJSON
+	if !readJSON(w, r, &body) {
+		return
+	}
+	if body.ConcertID == "" || body.CustomerName == "" || body.CustomerEmail == "" {
+		writeError(w, http.StatusBadRequest, errors.New("concert_id, customer_name and customer_email are required"))
+		return
+	}
+	ticket := &Ticket{
+		ConcertID:     body.ConcertID,
+		CustomerName:  body.CustomerName,
+		CustomerEmail: body.CustomerEmail,
+	}
+	if err := api.tickets.PurchaseTicket(r.Context(), ticket); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Location", "/api/tickets/"+ticket.ID)
+	writeJSON(w, http.StatusAccepted, toTicketJSON(*ticket))
+}
+
+func (api *API) getTicket(w http.ResponseWriter, r *http.Request) {
+	ticket, ok := api.tickets.Ticket(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("ticket %s not found", r.PathValue("id")))
+		return
+	}
+	writeJSON(w, http.StatusOK, toTicketJSON(ticket))
+}
+
+func (api *API) getPreferences(w http.ResponseWriter, r *http.Request) {
+	prefs, err := api.notifier.PreferencesFor(r.PathValue("id"), "", r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, prefs)
+}
+
+// setPreferences replaces the customer's preferences; the customer ID is
+// taken from the path
+func (api *API) setPreferences(w http.ResponseWriter, r *http.Request) {
+	var prefs Preferences
+	if !readJSON(w, r, &prefs) {
+		return
+	}
+	prefs.CustomerID = r.PathValue("id")
+	for _, name := range prefs.Channels {
+		if _, ok := api.notifier.Channel(name); !ok {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("unknown channel %q", name))
+			return
+		}
+	}
+	if err := api.notifier.Preferences().Set(prefs); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, prefs)
+}
+
+func (api *API) listInbox(w http.ResponseWriter, r *http.Request) {
+	inbox, _ := api.notifier.Inbox()
+	customerID := r.PathValue("id")
+	writeJSON(w, http.StatusOK, struct {
+		Unread   int            `json:"unread"`
+		Messages []InboxMessage `json:"messages"`
+	}{inbox.Unread(customerID), inbox.Messages(customerID)})
+}
+
+func (api *API) markRead(w http.ResponseWriter, r *http.Request) {
+	inbox, _ := api.notifier.Inbox()
+	err := inbox.MarkRead(r.PathValue("id"), r.PathValue("message"))
+	switch {
+	case errors.Is(err, ErrInboxMessageNotFound):
+		writeError(w, http.StatusNotFound, err)
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// listSubscriptions groups the live subscriptions by pattern, which is the
+// event type for all but wildcard subscriptions. With ?type= it lists those
+// an event of that type would reach.
+func (api *API) listSubscriptions(w http.ResponseWriter, r *http.Request) {
+	subs := api.eventBus.Subscriptions()
+	if eventType := r.URL.Query().Get("type"); eventType != "" {
+		subs = api.eventBus.SubscriptionsFor(eventType)
+	}
+	byPattern := make(map[string][]SubscriptionInfo)
+	for _, sub := range subs {
+		byPattern[sub.Pattern] = append(byPattern[sub.Pattern], sub)
+	}
+	writeJSON(w, http.StatusOK, byPattern)
+}
+
+func (api *API) listInFlight(w http.ResponseWriter, r *http.Request) {
+	body := struct {
+		Pending int                `json:"pending"`
+		Running []InFlightDelivery `json:"running"`
+		Pool    *PoolStats         `json:"pool,omitempty"`
+	}{
+		Pending: api.eventBus.Pending(),
+		Running: api.eventBus.InFlight(),
+	}
+	if stats, ok := api.eventBus.PoolStats(); ok {
+		body.Pool = &stats
+	}
+	writeJSON(w, http.StatusOK, body)
+}
+
+type typeErrorsJSON struct {
+	Handled     uint64        `json:"handled"`
+	Errors      uint64        `json:"errors"`
+	DeadLetters int           `json:"dead_letters"`
+	AvgDuration time.Duration `json:"avg_duration_ns"`
+	MaxDuration time.Duration `json:"max_duration_ns"`
+}
+
+// listErrors reports, per event type, handler invocations and failures
+// from the metrics middleware alongside the dead letters still waiting
+func (api *API) listErrors(w http.ResponseWriter, r *http.Request) {
+	letters, err := api.eventBus.DeadLetters()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	byType := make(map[string]typeErrorsJSON)
+	if api.metrics != nil {
+		for eventType, m := range api.metrics.Snapshot() {
+			byType[eventType] = typeErrorsJSON{
+				Handled:     m.Count,
+				Errors:      m.Errors,
+				AvgDuration: m.AverageDuration(),
+				MaxDuration: m.MaxDuration,
+			}
+		}
+	}
+	for _, letter := range letters {
+		entry := byType[letter.Event.Type]
+		entry.DeadLetters++
+		byType[letter.Event.Type] = entry
+	}
+	writeJSON(w, http.StatusOK, byType)
+}
+
+// listEvents returns the bus's recent events, newest first, optionally
+// filtered by topic pattern and capped by limit
+func (api *API) listEvents(w http.ResponseWriter, r *http.Request) {
+	limit, err := queryInt(r, "limit", 50)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	pattern := r.URL.Query().Get("type")
+	if pattern != "" {
+		if err := validatePattern(pattern); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	recent := api.eventBus.RecentEvents()
+	var events []Event
+	for i := len(recent) - 1; i >= 0 && len(events) < limit; i-- {
+		if pattern == "" || topicMatches(pattern, recent[i].Type) {
+			events = append(events, recent[i])
+		}
+	}
+	writeJSON(w, http.StatusOK, toEventJSONs(events))
+}
+
+func (api *API) eventChain(w http.ResponseWriter, r *http.Request) {
+	chain, err := api.eventBus.CausalChain(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if chain == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("event %s not found", r.PathValue("id")))
+		return
+	}
+	writeJSON(w, http.StatusOK, toEventJSONs(chain))
+}
+
+type deadLetterJSON struct {
+	ID           string            `json:"id"`
+	Event        eventJSON         `json:"event"`
+	Subscription string            `json:"subscription"`
+	Attempts     []DeliveryAttempt `json:"attempts"`
+	FailedAt     time.Time         `json:"failed_at"`
+}
+
+func (api *API) listDeadLetters(w http.ResponseWriter, r *http.Request) {
+	letters, err := api.eventBus.DeadLetters()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	out := make([]deadLetterJSON, 0, len(letters))
+	for _, letter := range letters {
+		out = append(out, deadLetterJSON{
+			ID:           letter.ID,
+			Event:        toEventJSONs([]Event{letter.Event})[0],
+			Subscription: letter.Subscription,
+			Attempts:     letter.Attempts,
+			FailedAt:     letter.FailedAt,
+		})
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (api *API) replayDeadLetter(w http.ResponseWriter, r *http.Request) {
+	err := api.eventBus.ReplayDeadLetter(r.Context(), r.PathValue("id"))
+	switch {
+	case errors.Is(err, ErrDeadLetterNotFound):
+		writeError(w, http.StatusNotFound, err)
+	case err != nil:
+		writeError(w, http.StatusBadGateway, err)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (api *API) discardDeadLetter(w http.ResponseWriter, r *http.Request) {
+	err := api.eventBus.DiscardDeadLetter(r.PathValue("id"))
+	switch {
+	case errors.Is(err, ErrDeadLetterNotFound):
+		writeError(w, http.StatusNotFound, err)
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+type scheduledJSON struct {
+	At    time.Time `json:"at"`
+	Event eventJSON `json:"event"`
+}
+
+func (api *API) listScheduled(w http.ResponseWriter, r *http.Request) {
+	scheduled, err := api.eventBus.Scheduled()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	out := make([]scheduledJSON, 0, len(scheduled))
+	for _, s := range scheduled {
+		out = append(out, scheduledJSON{At: s.At, Event: toEventJSONs([]Event{s.Event})[0]})
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// compactJournal removes journaled events older than the older_than
+// duration, keeping those the concert service restores its state from
+func (api *API) compactJournal(w http.ResponseWriter, r *http.Request) {
+	olderThan, err := time.ParseDuration(r.URL.Query().Get("older_than"))
+	if err != nil || olderThan <= 0 {
+		writeError(w, http.StatusBadRequest, errors.New("older_than must be a positive duration such as 720h"))
+		return
+	}
+	cutoff := api.eventBus.Now().Add(-olderThan)
+	removed, err := api.eventBus.CompactJournal(func(entry JournalEntry) bool {
+		return api.concerts.Retains(entry) || !entry.Timestamp.Before(cutoff)
+	})
+	if errors.Is(err, ErrNoJournal) {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"removed": removed})
+}
+
+type webhookJSON struct {
+	ID        string    `json:"id"`
+	Pattern   string    `json:"pattern"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (api *API) listWebhooks(w http.ResponseWriter, r *http.Request) {
+	webhooks := api.webhooks.Webhooks()
+	out := make([]webhookJSON, 0, len(webhooks))
+	for _, hook := range webhooks {
+		out = append(out, webhookJSON{ID: hook.ID, Pattern: hook.Pattern, URL: hook.URL, CreatedAt: hook.CreatedAt})
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// registerWebhook takes the secret in the request body; it is never
+// returned by the API
+func (api *API) registerWebhook(w http.ResponseWriter, r *http.Request) {
+	var body webhookJSON
+	if !readJSON(w, r, &body) {
+		return
+	}
+	hook, err := api.webhooks.Register(body.Pattern, body.URL, body.Secret)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	w.Header().Set("Location", "/admin/webhooks/"+hook.ID+"/deliveries")
+	writeJSON(w, http.StatusCreated, webhookJSON{ID: hook.ID, Pattern: hook.Pattern, URL: hook.URL, CreatedAt: hook.CreatedAt})
+}
+
+func (api *API) unregisterWebhook(w http.ResponseWriter, r *http.Request) {
+	err := api.webhooks.Unregister(r.PathValue("id"))
+	switch {
+	case errors.Is(err, ErrWebhookNotFound):
+		writeError(w, http.StatusNotFound, err)
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (api *API) listWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	deliveries := api.webhooks.Deliveries(r.PathValue("id"))
+	if deliveries == nil {
+		deliveries = []WebhookDelivery{}
+	}
+	writeJSON(w, http.StatusOK, deliveries)
+}
+
+// readJSON decodes the request body into v, answering 400 and returning
+// false if it is not a single JSON document of the expected shape
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBody))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to write response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func queryInt(r *http.Request, name string, fallback int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative integer", name)
+	}
+	return n, nil
+}
@@ -0,0 +1,483 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrBusClosed is returned when publishing to an EventBus that has been closed
+var ErrBusClosed = errors.New("event bus is closed")
+
+// Event represents a domain event in the system. Headers carry metadata
+// such as the correlation and causation IDs.
+type Event struct {
+	ID        string
+	Type      string
+	Payload   interface{}
+	Timestamp time.Time
+	Headers   map[string]string
+}
+
+// EventHandler is a function that processes an event
+type EventHandler func(context.Context, Event) error
+
+// Subscription is a handler registered for an event type or topic pattern.
+// It is returned by Subscribe so the subscriber can detach later.
+type Subscription struct {
+	id      string
+	name    string
+	pattern string
+	handler EventHandler
+	retry   RetryPolicy
+	bus     *EventBus
+
+	// responder marks the subscription registered with Respond
+	responder bool
+
+	middlewares []Middleware
+	history     *eventHistory
+}
+
+// ID uniquely identifies the subscription
+func (s *Subscription) ID() string {
+	return s.id
+}
+
+// Name is the label used in logs and dead letters
+func (s *Subscription) Name() string {
+	return s.name
+}
+
+// Pattern is the event type or topic pattern the subscription listens to
+func (s *Subscription) Pattern() string {
+	return s.pattern
+}
+
+// Unsubscribe stops new events reaching the handler; deliveries already in
+// progress run to completion. Calling it more than once has no effect.
+func (s *Subscription) Unsubscribe() {
+	s.bus.unsubscribe(s)
+}
+
+// SubscribeOption configures a subscription
+type SubscribeOption func(*Subscription)
+
+// WithName labels a subscription in logs and dead letters
+func WithName(name string) SubscribeOption {
+	return func(s *Subscription) {
+		s.name = name
+	}
+}
+
+// WithRetry retries a failing handler according to policy before the event
+// is dead-lettered
+func WithRetry(policy RetryPolicy) SubscribeOption {
+	return func(s *Subscription) {
+		s.retry = policy
+	}
+}
+
+// BusOption configures an EventBus
+type BusOption func(*EventBus)
+
+// WithDeadLetterStore keeps events whose handlers failed in store instead
+// of the default in-memory store
+func WithDeadLetterStore(store DeadLetterStore) BusOption {
+	return func(eb *EventBus) {
+		eb.deadLetters = store
+	}
+}
+
+// EventBus manages subscriptions and publishing of events
+type EventBus struct {
+	// handlers holds subscriptions to exact event types, wildcards those
+	// to topic patterns and byID every subscription
+	handlers    map[string][]*Subscription
+	wildcards   []*Subscription
+	byID        map[string]*Subscription
+	mu          sync.RWMutex
+	closed      bool
+	deadLetters DeadLetterStore
+	journal     *Journal
+	pool        *workerPool
+	middlewares []Middleware
+	history     *eventHistory
+	schedules   ScheduleStore
+	scheduler   *scheduler
+	transport   Transport
+	clock       Clock
+	observers   []func(Event)
+
+	// payloadTypes maps event types to the payload type they carry and
+	// upcasters holds the steps between their schema versions
+	payloadTypes map[string]reflect.Type
+	upcasters    map[upcasterKey]Upcaster
+
+	// inflight counts handler invocations that have not returned yet; idle
+	// is closed whenever it drops to zero. running holds the invocations
+	// currently inside a handler, keyed by a sequence number.
+	inflightMu  sync.Mutex
+	inflight    int
+	idle        chan struct{}
+	running     map[uint64]InFlightDelivery
+	runningNext uint64
+}
+
+// NewEventBus creates a new EventBus. The Recover middleware is always
+// installed first, so a handler panic is reported as an error.
+func NewEventBus(opts ...BusOption) *EventBus {
+	idle := make(chan struct{})
+	close(idle)
+	eb := &EventBus{
+		handlers:     make(map[string][]*Subscription),
+		byID:         make(map[string]*Subscription),
+		deadLetters:  NewMemoryDeadLetterStore(),
+		schedules:    NewMemoryScheduleStore(),
+		payloadTypes: make(map[string]reflect.Type),
+		upcasters:    make(map[upcasterKey]Upcaster),
+		clock:        SystemClock{},
+		idle:         idle,
+		running:      make(map[uint64]InFlightDelivery),
+		middlewares:  []Middleware{Recover()},
+	}
+	for _, opt := range opts {
+		opt(eb)
+	}
+	eb.scheduler = newScheduler(eb)
+	if eb.transport != nil {
+		eb.transport.Start(eb.receive)
+	}
+	return eb
+}
+
+// Subscribe adds a handler for an event type or a topic pattern using "*"
+// and "#" wildcards. Without WithRetry a failing handler is not retried and
+// the event is dead-lettered at once.
+func (eb *EventBus) Subscribe(pattern string, handler EventHandler, opts ...SubscribeOption) (*Subscription, error) {
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+	return eb.subscribeLocked(pattern, handler, opts...)
+}
+
+// subscribeLocked registers a subscription; eb.mu must be held for writing
+func (eb *EventBus) subscribeLocked(pattern string, handler EventHandler, opts ...SubscribeOption) (*Subscription, error) {
+	if err := validatePattern(pattern); err != nil {
+		return nil, err
+	}
+	sub := &Subscription{
+		id:      uuid.New().String(),
+		name:    fmt.Sprintf("%s[%d]", pattern, len(eb.byID)+1),
+		pattern: pattern,
+		handler: handler,
+		retry:   NoRetry,
+		bus:     eb,
+	}
+	for _, opt := range opts {
+		opt(sub)
+	}
+	if isWildcardPattern(pattern) {
+		eb.wildcards = append(eb.wildcards, sub)
+	} else {
+		eb.handlers[pattern] = append(eb.handlers[pattern], sub)
+	}
+	eb.byID[sub.id] = sub
+	return sub, nil
+}
+
+func (eb *EventBus) unsubscribe(sub *Subscription) {
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+	if _, ok := eb.byID[sub.id]; !ok {
+		return
+	}
+	delete(eb.byID, sub.id)
+	if isWildcardPattern(sub.pattern) {
+		eb.wildcards = removeSubscription(eb.wildcards, sub)
+		return
+	}
+	eb.handlers[sub.pattern] = removeSubscription(eb.handlers[sub.pattern], sub)
+	if len(eb.handlers[sub.pattern]) == 0 {
+		delete(eb.handlers, sub.pattern)
+	}
+}
+
+// removeSubscription returns subs without sub, copying so that slices
+// already handed to in-flight publishes are left untouched
+func removeSubscription(subs []*Subscription, sub *Subscription) []*Subscription {
+	kept := make([]*Subscription, 0, len(subs))
+	for _, s := range subs {
+		if s != sub {
+			kept = append(kept, s)
+		}
+	}
+	return kept
+}
+
+// matching returns the subscriptions for eventType; eb.mu must be held
+func (eb *EventBus) matching(eventType string) []*Subscription {
+	subs := append([]*Subscription(nil), eb.handlers[eventType]...)
+	for _, sub := range eb.wildcards {
+		if topicMatches(sub.pattern, eventType) {
+			subs = append(subs, sub)
+		}
+	}
+	return subs
+}
+
+func (eb *EventBus) subscriptionByID(id string) (*Subscription, error) {
+	eb.mu.RLock()
+	defer eb.mu.RUnlock()
+	sub, ok := eb.byID[id]
+	if !ok {
+		return nil, fmt.Errorf("subscription %s no longer exists", id)
+	}
+	return sub, nil
+}
+
+// Delivery tracks the handler invocations started for one published event
+type Delivery struct {
+	Event Event
+	done  chan struct{}
+	mu    sync.Mutex
+	errs  []error
+}
+
+// Done is closed once every handler for the event has returned
+func (d *Delivery) Done() <-chan struct{} {
+	return d.done
+}
+
+// Wait blocks until every handler has returned and reports all of their
+// errors joined together, or nil if they all succeeded
+func (d *Delivery) Wait() error {
+	<-d.done
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return errors.Join(d.errs...)
+}
+
+// Publish sends an event to all subscribed handlers without waiting for
+// them; handler errors are logged
+func (eb *EventBus) Publish(ctx context.Context, event Event) error {
+	_, err := eb.publish(ctx, event, true, true)
+	return err
+}
+
+// PublishSync sends an event to all subscribed handlers and waits for them
+// to return, reporting every handler error
+func (eb *EventBus) PublishSync(ctx context.Context, event Event) error {
+	delivery, err := eb.PublishAsync(ctx, event)
+	if err != nil {
+		return err
+	}
+	return delivery.Wait()
+}
+
+// PublishAsync starts delivering an event to all subscribed handlers and
+// returns a Delivery to wait on
+func (eb *EventBus) PublishAsync(ctx context.Context, event Event) (*Delivery, error) {
+	return eb.publish(ctx, event, false, true)
+}
+
+// publish accepts and dispatches an event. Unless it arrived from the
+// transport, it is also forwarded to the other buses; a transport failure
+// is logged, since local delivery goes ahead regardless. An event from the
+// transport that a subscriber has no room for is reported instead of dead
+// lettered, so that it can be delivered again.
+func (eb *EventBus) publish(ctx context.Context, event Event, logErrors, forward bool) (*Delivery, error) {
+	event, handlers, err := eb.accept(ctx, event)
+	if err != nil {
+		return nil, err
+	}
+	if forward && eb.transport != nil {
+		if err := eb.transport.Send(ctx, event); err != nil {
+			log.Printf("Failed to forward event %s: %v", event.ID, err)
+		}
+	}
+	delivery := &Delivery{Event: event, done: make(chan struct{})}
+	if len(handlers) == 0 {
+		close(delivery.done)
+		return delivery, nil
+	}
+
+	var wg sync.WaitGroup
+	var refused []error
+	wg.Add(len(handlers))
+	fail := func(err error) {
+		if logErrors {
+			log.Printf("Error handling event %s: %v", event.ID, err)
+		}
+		delivery.mu.Lock()
+		delivery.errs = append(delivery.errs, err)
+		delivery.mu.Unlock()
+	}
+	for _, sub := range handlers {
+		job := func(ctx context.Context) {
+			defer eb.track(-1)
+			defer wg.Done()
+			// Deliveries outlive the publishing call, so they keep the
+			// publisher's context values but not its cancellation; a handler
+			// publishing a follow-up event must not cancel it by returning.
+			if err := eb.deliver(context.WithoutCancel(ctx), sub, event); err != nil {
+				fail(err)
+			}
+		}
+		if eb.pool == nil {
+			go job(ctx)
+			continue
+		}
+		if err := eb.pool.submit(ctx, sub, event, job); err != nil {
+			err = fmt.Errorf("%s did not receive %s: %w", sub.name, event.Type, err)
+			if forward {
+				eb.deadLetter(sub, event, []DeliveryAttempt{{Attempt: 0, Error: err.Error(), At: eb.Now()}})
+			} else {
+				// The transport delivers the event again rather than
+				// dead lettering it
+				refused = append(refused, err)
+			}
+			fail(err)
+			eb.track(-1)
+			wg.Done()
+		}
+	}
+	go func() {
+		wg.Wait()
+		close(delivery.done)
+	}()
+	return delivery, errors.Join(refused...)
+}
+
+// accept checks, correlates and journals an event and returns it with the
+// subscriptions it must be delivered to, counted as in flight. Taking the
+// snapshot under the lock keeps journal order and subscription changes
+// consistent; the deliveries themselves are dispatched after the lock is
+// released.
+func (eb *EventBus) accept(ctx context.Context, event Event) (Event, []*Subscription, error) {
+	eb.mu.RLock()
+	defer eb.mu.RUnlock()
+	// Handlers still running while Close drains the bus may publish their
+	// follow-up events; only publishes from outside a delivery are refused
+	if _, handling := EventFromContext(ctx); eb.closed && !handling {
+		return event, nil, ErrBusClosed
+	}
+	if err := eb.checkPayload(event); err != nil {
+		return event, nil, err
+	}
+	event = withSchemaVersion(correlate(ctx, event))
+	// A reply only matters to the request waiting for it, so it is not
+	// journaled for replay
+	if eb.journal != nil && !strings.HasPrefix(event.Type, replyTopicPrefix) {
+		if _, err := eb.journal.Append(event); err != nil {
+			return event, nil, fmt.Errorf("journaling event %s: %w", event.ID, err)
+		}
+	}
+	if eb.history != nil {
+		eb.history.add(event)
+	}
+	for _, observe := range eb.observers {
+		observe(event)
+	}
+	handlers := eb.matching(event.Type)
+	if len(handlers) > 0 {
+		eb.track(len(handlers))
+	}
+	return event, handlers, nil
+}
+
+// deliver runs one subscription's handler under its retry policy and
+// dead-letters the event if every attempt fails
+func (eb *EventBus) deliver(ctx context.Context, sub *Subscription, event Event) error {
+	attempts, err := eb.attempt(ctx, sub, event)
+	if err != nil {
+		eb.deadLetter(sub, event, attempts)
+		return fmt.Errorf("%s failed %s after %d attempt(s): %w", sub.name, event.Type, len(attempts), err)
+	}
+	return nil
+}
+
+// attempt calls the handler until it succeeds, fails permanently, the
+// retry policy is exhausted or ctx ends, returning the failed attempts and the last error
+func (eb *EventBus) attempt(ctx context.Context, sub *Subscription, event Event) ([]DeliveryAttempt, error) {
+	handler := eb.chain(sub)
+	ctx = ContextWithEvent(context.WithValue(ctx, subscriptionKey{}, sub), event)
+	var attempts []DeliveryAttempt
+	for n := 1; ; n++ {
+		done := eb.started(sub, event, n)
+		err := handler(ctx, event)
+		done()
+		if err == nil {
+			return attempts, nil
+		}
+		attempts = append(attempts, DeliveryAttempt{Attempt: n, Error: err.Error(), At: eb.Now()})
+		if n >= sub.retry.attempts() || IsPermanent(err) || !sleepContext(ctx, eb.clock, sub.retry.Backoff(n)) {
+			return attempts, err
+		}
+	}
+}
+
+// track adjusts the in-flight handler count by delta
+func (eb *EventBus) track(delta int) {
+	eb.inflightMu.Lock()
+	defer eb.inflightMu.Unlock()
+	if eb.inflight == 0 && delta > 0 {
+		eb.idle = make(chan struct{})
+	}
+	eb.inflight += delta
+	if eb.inflight == 0 {
+		close(eb.idle)
+	}
+}
+
+// Drain blocks until no handler is running, including handlers started by
+// events published while draining, or until ctx is done
+func (eb *EventBus) Drain(ctx context.Context) error {
+	for {
+		eb.inflightMu.Lock()
+		idle := eb.idle
+		eb.inflightMu.Unlock()
+
+		select {
+		case <-idle:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		// A handler may have published a follow-up event just before
+		// returning; only stop once the bus is still idle.
+		eb.inflightMu.Lock()
+		done := eb.inflight == 0
+		eb.inflightMu.Unlock()
+		if done {
+			return nil
+		}
+	}
+}
+
+// Close stops the bus accepting new events and waits for in-flight
+// deliveries, and the events their handlers publish, to complete before
+// stopping the worker pool and transport, if any. Events scheduled for later are not published; a persistent
+// ScheduleStore keeps them for the next run.
+func (eb *EventBus) Close(ctx context.Context) error {
+	eb.mu.Lock()
+	eb.closed = true
+	eb.mu.Unlock()
+	eb.scheduler.stop()
+	if err := eb.Drain(ctx); err != nil {
+		return err
+	}
+	if eb.pool != nil {
+		eb.pool.stop()
+	}
+	if eb.transport != nil {
+		return eb.transport.Close()
+	}
+	return nil
+}
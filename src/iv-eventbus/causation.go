@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// Header names carried by events
+const (
+	HeaderCorrelationID = "correlation-id"
+	HeaderCausationID   = "causation-id"
+)
+
+// CorrelationID identifies the business flow the event belongs to
+func (e Event) CorrelationID() string {
+	return e.Headers[HeaderCorrelationID]
+}
+
+// CausationID is the ID of the event whose handler published this one
+func (e Event) CausationID() string {
+	return e.Headers[HeaderCausationID]
+}
+
+// withHeader returns a copy of e with header key set, leaving the caller's
+// header map untouched
+func (e Event) withHeader(key, value string) Event {
+	headers := make(map[string]string, len(e.Headers)+1)
+	for k, v := range e.Headers {
+		headers[k] = v
+	}
+	headers[key] = value
+	e.Headers = headers
+	return e
+}
+
+type eventKey struct{}
+
+// ContextWithEvent marks ctx as handling event, so events published with it
+// are recorded as caused by event. Handlers' contexts are marked already.
+func ContextWithEvent(ctx context.Context, event Event) context.Context {
+	return context.WithValue(ctx, eventKey{}, event)
+}
+
+// EventFromContext returns the event being handled in ctx
+func EventFromContext(ctx context.Context) (Event, bool) {
+	event, ok := ctx.Value(eventKey{}).(Event)
+	return event, ok
+}
+
+// correlate fills in the correlation and causation headers: an event
+// published while handling another inherits its correlation ID and is
+// caused by it, and any other event starts a new flow named after itself
+func correlate(ctx context.Context, event Event) Event {
+	if parent, ok := EventFromContext(ctx); ok {
+		if event.CausationID() == "" {
+			event = event.withHeader(HeaderCausationID, parent.ID)
+		}
+		if event.CorrelationID() == "" {
+			correlationID := parent.CorrelationID()
+			if correlationID == "" {
+				correlationID = parent.ID
+			}
+			event = event.withHeader(HeaderCorrelationID, correlationID)
+		}
+	}
+	if event.CorrelationID() == "" {
+		event = event.withHeader(HeaderCorrelationID, event.ID)
+	}
+	return event
+}
+
+// WithHistory keeps the last size published events in memory for
+// RecentEvents and CausalChain
+func WithHistory(size int) BusOption {
+	return func(eb *EventBus) {
+		eb.history = &eventHistory{events: make([]Event, 0, size), size: size}
+	}
+}
+
+type eventHistory struct {
+	mu     sync.Mutex
+	events []Event
+	next   int
+	size   int
+}
+
+func (h *eventHistory) add(event Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.size <= 0 {
+		return
+	}
+	if len(h.events) < h.size {
+		h.events = append(h.events, event)
+		return
+	}
+	h.events[h.next] = event
+	h.next = (h.next + 1) % h.size
+}
+
+// list returns the retained events, oldest first
+func (h *eventHistory) list() []Event {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append(append([]Event(nil), h.events[h.next:]...), h.events[:h.next]...)
+}
+
+// RecentEvents returns the events retained by WithHistory, oldest first
+func (eb *EventBus) RecentEvents() []Event {
+	if eb.history == nil {
+		return nil
+	}
+	return eb.history.list()
+}
+
+// CausalChain rebuilds the flow that eventID belongs to from the journal,
+// reading only that flow's records through the journal's correlation
+// index, or from the in-memory history if the bus has no journal
+func (eb *EventBus) CausalChain(ctx context.Context, eventID string) ([]Event, error) {
+	if eb.journal == nil {
+		return CausalChain(eb.RecentEvents(), eventID), nil
+	}
+	var events []Event
+	found, err := eb.journal.ReadFlow(eventID, func(entry JournalEntry) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		event, err := eb.decodeEntry(entry)
+		if err != nil {
+			return err
+		}
+		events = append(events, event)
+		return nil
+	})
+	if !found || err != nil {
+		return nil, err
+	}
+	return CausalChain(events, eventID), nil
+}
+
+// CausalChain returns every event sharing eventID's correlation ID, ordered
+// so that each event follows the event that caused it and siblings appear
+// in timestamp order. It returns nil if eventID is not in events.
+func CausalChain(events []Event, eventID string) []Event {
+	var correlationID string
+	found := false
+	for _, event := range events {
+		if event.ID == eventID {
+			correlationID, found = event.CorrelationID(), true
+			break
+		}
+	}
+	if !found {
+		return nil
+	}
+	if correlationID == "" {
+		correlationID = eventID
+	}
+
+	var flow []Event
+	inFlow := make(map[string]bool)
+	for _, event := range events {
+		if event.CorrelationID() == correlationID || event.ID == correlationID {
+			flow = append(flow, event)
+			inFlow[event.ID] = true
+		}
+	}
+	sort.SliceStable(flow, func(i, j int) bool { return flow[i].Timestamp.Before(flow[j].Timestamp) })
+
+	children := make(map[string][]Event)
+	var roots []Event
+	for _, event := range flow {
+		if parent := event.CausationID(); parent != "" && inFlow[parent] {
+			children[parent] = append(children[parent], event)
+		} else {
+			roots = append(roots, event)
+		}
+	}
+
+	chain := make([]Event, 0, len(flow))
+	var visit func(Event)
+	visit = func(event Event) {
+		chain = append(chain, event)
+		for _, child := range children[event.ID] {
+			visit(child)
+		}
+	}
+	for _, root := range roots {
+		visit(root)
+	}
+	return chain
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// chainTypes joins the event types of chain in order
+func chainTypes(chain []Event) string {
+	types := make([]string, len(chain))
+	for i, event := range chain {
+		types[i] = event.Type
+	}
+	return strings.Join(types, " → ")
+}
+
+func TestCausalChainReadsFlowFromJournal(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), awaitTimeout)
+	defer cancel()
+	path := filepath.Join(t.TempDir(), "events.journal")
+	journal, err := OpenJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+
+	clock := NewFakeClock(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	eb, rec := NewRecordingBus(t, WithClock(clock), WithJournal(journal))
+	if err := registerPayloads(eb); err != nil {
+		t.Fatal(err)
+	}
+	cs, err := NewConcertService(eb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts, err := NewTicketService(eb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	concert := &Concert{Name: "Rock Concert", Date: clock.Now().AddDate(0, 1, 0), AvailableTickets: 10}
+	other := &Concert{Name: "Jazz Night", Date: clock.Now().AddDate(0, 2, 0), AvailableTickets: 10}
+	for _, c := range []*Concert{concert, other} {
+		if err := cs.AddConcert(ctx, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	added := rec.ExpectPublished(t, EventTypeOf[ConcertAdded](), MatchPayload(func(e ConcertAdded) bool { return e.Concert.ID == concert.ID }))
+
+	// A purchase made while handling the concert event joins its flow
+	if err := ts.PurchaseTicket(ContextWithEvent(ctx, added), &Ticket{ConcertID: concert.ID, CustomerName: "Jane"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.PurchaseTicket(ctx, &Ticket{ConcertID: other.ID, CustomerName: "John"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := eb.Drain(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	const want = "concert.added → ticket.requested → concert.seat.reserved → ticket.purchased"
+	check := func(when string) {
+		t.Helper()
+		chain, err := eb.CausalChain(ctx, added.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := chainTypes(chain); got != want {
+			t.Fatalf("%s: want %s, got %s", when, want, got)
+		}
+	}
+	check("after publishing")
+
+	// The index is rebuilt when the journal is compacted and reopened
+	// Dropping the other flow's request moves every record after it
+	keep := func(entry JournalEntry) bool {
+		return entry.Type != EventTypeOf[TicketRequested]() || entry.Headers[HeaderCorrelationID] == added.ID
+	}
+	if _, err := journal.Compact(keep); err != nil {
+		t.Fatal(err)
+	}
+	check("after compacting")
+
+	reopened, err := OpenJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	n := 0
+	found, err := reopened.ReadFlow(added.ID, func(JournalEntry) error {
+		n++
+		return nil
+	})
+	if err != nil || !found || n != 4 {
+		t.Fatalf("want 4 entries in the flow after reopening, got %d, %v, %v", n, found, err)
+	}
+	if found, _ := reopened.ReadFlow("no-such-event", func(JournalEntry) error { return nil }); found {
+		t.Fatal("want an unknown event not found")
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// ErrInboxMessageNotFound is returned for an unknown in-app message
+var ErrInboxMessageNotFound = errors.New("inbox message not found")
+
+// SMSProvider sends text messages through an SMS gateway
+type SMSProvider interface {
+	SendSMS(ctx context.Context, to, text string) error
+}
+
+// SMSChannel sends notifications as text messages through a provider
+type SMSChannel struct {
+	provider SMSProvider
+}
+
+// NewSMSChannel creates a channel sending through provider
+func NewSMSChannel(provider SMSProvider) *SMSChannel {
+	return &SMSChannel{provider: provider}
+}
+
+// Name implements Channel
+func (c *SMSChannel) Name() string { return ChannelSMS }
+
+// Address implements Channel
+func (c *SMSChannel) Address(prefs Preferences) string { return prefs.Phone }
+
+// Send implements Channel. Text messages carry only the subject, which
+// keeps them to a single SMS.
+func (c *SMSChannel) Send(ctx context.Context, message Message) error {
+	return c.provider.SendSMS(ctx, message.To, message.Subject)
+}
+
+// LogSMSProvider is an SMSProvider that only logs, for running without a
+// gateway
+type LogSMSProvider struct{}
+
+// SendSMS implements SMSProvider
+func (LogSMSProvider) SendSMS(ctx context.Context, to, text string) error {
+	log.Printf("SMS to %s: %s", to, text)
+	return nil
+}
+
+// LogEmailChannel is an email Channel that only logs, for running without
+// a mail server
+type LogEmailChannel struct{}
+
+// Name implements Channel
+func (LogEmailChannel) Name() string { return ChannelEmail }
+
+// Address implements Channel
+func (LogEmailChannel) Address(prefs Preferences) string { return prefs.Email }
+
+// Send implements Channel
+func (LogEmailChannel) Send(ctx context.Context, message Message) error {
+	log.Printf("Email to %s: %s", message.To, message.Subject)
+	return nil
+}
+
+// InboxMessage is a notification kept in a customer's in-app inbox
+type InboxMessage struct {
+	Message
+	Read bool `json:"read"`
+}
+
+// Inbox is the in-app channel: it keeps each customer's most recent
+// messages in memory for the app to fetch
+type Inbox struct {
+	size int
+
+	mu         sync.RWMutex
+	byCustomer map[string][]InboxMessage
+}
+
+// NewInbox creates an inbox keeping up to size messages per customer
+func NewInbox(size int) *Inbox {
+	return &Inbox{size: size, byCustomer: make(map[string][]InboxMessage)}
+}
+
+// Name implements Channel
+func (in *Inbox) Name() string { return ChannelInApp }
+
+// Address implements Channel
+func (in *Inbox) Address(prefs Preferences) string { return prefs.CustomerID }
+
+// Send implements Channel, dropping the customer's oldest message once the
+// inbox is full
+func (in *Inbox) Send(ctx context.Context, message Message) error {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	messages := append(in.byCustomer[message.To], InboxMessage{Message: message})
+	if in.size > 0 && len(messages) > in.size {
+		messages = messages[len(messages)-in.size:]
+	}
+	in.byCustomer[message.To] = messages
+	return nil
+}
+
+// Messages returns a customer's messages, newest first
+func (in *Inbox) Messages(customerID string) []InboxMessage {
+	in.mu.RLock()
+	defer in.mu.RUnlock()
+	stored := in.byCustomer[customerID]
+	messages := make([]InboxMessage, 0, len(stored))
+	for i := len(stored) - 1; i >= 0; i-- {
+		messages = append(messages, stored[i])
+	}
+	return messages
+}
+
+// Unread counts a customer's unread messages
+func (in *Inbox) Unread(customerID string) int {
+	in.mu.RLock()
+	defer in.mu.RUnlock()
+	n := 0
+	for _, m := range in.byCustomer[customerID] {
+		if !m.Read {
+			n++
+		}
+	}
+	return n
+}
+
+// MarkRead marks one of a customer's messages as read
+func (in *Inbox) MarkRead(customerID, messageID string) error {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	for i, m := range in.byCustomer[customerID] {
+		if m.ID == messageID {
+			in.byCustomer[customerID][i].Read = true
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrInboxMessageNotFound, messageID)
+}
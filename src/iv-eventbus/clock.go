@@ -0,0 +1,47 @@
+package main
+
+import "time"
+
+// Clock tells the bus the time. Event timestamps and scheduled events
+// follow it, so tests can substitute a clock that only moves when told to.
+type Clock interface {
+	Now() time.Time
+	// NewTimer returns a Timer that fires once d has passed
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is a pending wake-up from a Clock
+type Timer interface {
+	// C receives the time when the timer fires
+	C() <-chan time.Time
+	// Stop cancels the timer, reporting whether it had not fired yet
+	Stop() bool
+}
+
+// SystemClock is the real time
+type SystemClock struct{}
+
+// Now implements Clock
+func (SystemClock) Now() time.Time { return time.Now() }
+
+// NewTimer implements Clock
+func (SystemClock) NewTimer(d time.Duration) Timer { return systemTimer{time.NewTimer(d)} }
+
+type systemTimer struct{ timer *time.Timer }
+
+func (t systemTimer) C() <-chan time.Time { return t.timer.C }
+
+func (t systemTimer) Stop() bool { return t.timer.Stop() }
+
+// WithClock makes the bus take the time from clock instead of the system
+func WithClock(clock Clock) BusOption {
+	return func(eb *EventBus) {
+		eb.clock = clock
+	}
+}
+
+// Now returns the bus clock's current time, for services that stamp or
+// schedule events
+func (eb *EventBus) Now() time.Time {
+	return eb.clock.Now()
+}
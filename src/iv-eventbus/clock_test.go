@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// FakeClock is a Clock that only moves when told to
+type FakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock *FakeClock
+	at    time.Time
+	c     chan time.Time
+}
+
+// NewFakeClock creates a FakeClock stopped at now
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+// Now implements Clock
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// NewTimer implements Clock; the timer fires once Advance or Set moves the
+// clock d past the current time
+func (c *FakeClock) NewTimer(d time.Duration) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{clock: c, at: c.now.Add(d), c: make(chan time.Time, 1)}
+	if d <= 0 {
+		t.c <- c.now
+		return t
+	}
+	c.waiters = append(c.waiters, t)
+	return t
+}
+
+func (t *fakeTimer) C() <-chan time.Time { return t.c }
+
+func (t *fakeTimer) Stop() bool {
+	c := t.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, w := range c.waiters {
+		if w == t {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Advance moves the clock forward by d, firing every timer that falls due
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.setLocked(c.now.Add(d))
+	c.mu.Unlock()
+}
+
+// Set moves the clock to t, firing every timer that falls due. Moving it
+// backwards fires nothing.
+func (c *FakeClock) Set(t time.Time) {
+	c.mu.Lock()
+	c.setLocked(t)
+	c.mu.Unlock()
+}
+
+func (c *FakeClock) setLocked(t time.Time) {
+	c.now = t
+	pending := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.at.After(t) {
+			pending = append(pending, w)
+			continue
+		}
+		w.c <- t
+	}
+	c.waiters = pending
+}
+
+// Waiters returns how many timers are pending
+func (c *FakeClock) Waiters() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.waiters)
+}
+
+// AwaitWaiters waits until exactly n timers are pending, so a test can let a
+// goroutine start waiting before advancing the clock
+func (c *FakeClock) AwaitWaiters(t testing.TB, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for c.Waiters() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("want %d pending timer(s), got %d", n, c.Waiters())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestFakeClockTimers(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	early := clock.NewTimer(time.Minute)
+	late := clock.NewTimer(time.Hour)
+	stopped := clock.NewTimer(time.Minute)
+	if !stopped.Stop() {
+		t.Fatal("Stop on a pending timer reported it had fired")
+	}
+	if got := clock.Waiters(); got != 2 {
+		t.Fatalf("want 2 pending timers, got %d", got)
+	}
+
+	clock.Advance(time.Minute)
+	select {
+	case <-early.C():
+	default:
+		t.Fatal("timer due after a minute did not fire")
+	}
+	select {
+	case <-late.C():
+		t.Fatal("timer due after an hour fired after a minute")
+	case <-stopped.C():
+		t.Fatal("stopped timer fired")
+	default:
+	}
+	if early.Stop() {
+		t.Fatal("Stop on a fired timer reported it was pending")
+	}
+	if got := clock.Waiters(); got != 1 {
+		t.Fatalf("want 1 pending timer, got %d", got)
+	}
+}
+
+func TestRetriesFollowBusClock(t *testing.T) {
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+	eb, _ := NewRecordingBus(t, WithClock(clock))
+	if _, err := Subscribe(eb, func(ctx context.Context, e ReservationExpired) error {
+		return errors.New("unavailable")
+	}, WithName("Failing"), WithRetry(RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Minute, Multiplier: 1})); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), awaitTimeout)
+	defer cancel()
+	if err := Publish(ctx, eb, ReservationExpired{TicketID: "t1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The retry waits on the bus clock, not the wall clock
+	clock.AwaitWaiters(t, 1)
+	clock.Advance(time.Minute)
+	if err := eb.Drain(ctx); err != nil {
+		t.Fatal(err)
+	}
+	letters, err := eb.DeadLetters()
+	if err != nil || len(letters) != 1 {
+		t.Fatalf("want 1 dead letter, got %v, %v", letters, err)
+	}
+	letter, retried := letters[0], start.Add(time.Minute)
+	if len(letter.Attempts) != 2 || !letter.Attempts[0].At.Equal(start) || !letter.Attempts[1].At.Equal(retried) {
+		t.Fatalf("want attempts at %s and %s, got %+v", start, retried, letter.Attempts)
+	}
+	if !letter.FailedAt.Equal(retried) {
+		t.Fatalf("want the dead letter stamped %s, got %s", retried, letter.FailedAt)
+	}
+}
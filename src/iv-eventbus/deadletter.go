@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrDeadLetterNotFound is returned for an unknown dead letter ID
+var ErrDeadLetterNotFound = errors.New("dead letter not found")
+
+// DeliveryAttempt records one failed invocation of a handler
+type DeliveryAttempt struct {
+	Attempt int       `json:"attempt"`
+	Error   string    `json:"error"`
+	At      time.Time `json:"at"`
+}
+
+// DeadLetter is an event that a subscription still failed to handle after
+// exhausting its retry policy
+type DeadLetter struct {
+	ID             string
+	Event          Event
+	SubscriptionID string
+	Subscription   string
+	Attempts       []DeliveryAttempt
+	FailedAt       time.Time
+}
+
+// DeadLetterStore keeps dead-lettered events until they are replayed or
+// discarded
+type DeadLetterStore interface {
+	Add(DeadLetter) error
+	Get(id string) (DeadLetter, error)
+	List() ([]DeadLetter, error)
+	Remove(id string) error
+}
+
+// MemoryDeadLetterStore is an in-process DeadLetterStore
+type MemoryDeadLetterStore struct {
+	letters map[string]DeadLetter
+	mu      sync.RWMutex
+}
+
+// NewMemoryDeadLetterStore creates an empty MemoryDeadLetterStore
+func NewMemoryDeadLetterStore() *MemoryDeadLetterStore {
+	return &MemoryDeadLetterStore{letters: make(map[string]DeadLetter)}
+}
+
+// Add stores a dead letter, replacing any with the same ID
+func (s *MemoryDeadLetterStore) Add(letter DeadLetter) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.letters[letter.ID] = letter
+	return nil
+}
+
+// Get returns the dead letter with the given ID
+func (s *MemoryDeadLetterStore) Get(id string) (DeadLetter, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	letter, ok := s.letters[id]
+	if !ok {
+		return DeadLetter{}, ErrDeadLetterNotFound
+	}
+	return letter, nil
+}
+
+// List returns all dead letters, oldest failure first
+func (s *MemoryDeadLetterStore) List() ([]DeadLetter, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	letters := make([]DeadLetter, 0, len(s.letters))
+	for _, letter := range s.letters {
+		letters = append(letters, letter)
+	}
+	sort.Slice(letters, func(i, j int) bool { return letters[i].FailedAt.Before(letters[j].FailedAt) })
+	return letters, nil
+}
+
+// Remove deletes the dead letter with the given ID
+func (s *MemoryDeadLetterStore) Remove(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.letters[id]; !ok {
+		return ErrDeadLetterNotFound
+	}
+	delete(s.letters, id)
+	return nil
+}
+
+func (eb *EventBus) deadLetter(sub *Subscription, event Event, attempts []DeliveryAttempt) {
+	letter := DeadLetter{
+		ID:             uuid.New().String(),
+		Event:          event,
+		SubscriptionID: sub.id,
+		Subscription:   sub.name,
+		Attempts:       attempts,
+		FailedAt:       eb.Now(),
+	}
+	if err := eb.deadLetters.Add(letter); err != nil {
+		log.Printf("Failed to dead-letter event %s for %s: %v", event.ID, sub.name, err)
+	}
+}
+
+// DeadLetters lists events that exhausted their retry policy
+func (eb *EventBus) DeadLetters() ([]DeadLetter, error) {
+	return eb.deadLetters.List()
+}
+
+// ReplayDeadLetter delivers a dead-lettered event again to the subscription
+// that failed it, with that subscription's retry policy. The dead letter is
+// removed on success; on failure it is kept with the new attempts appended.
+func (eb *EventBus) ReplayDeadLetter(ctx context.Context, id string) error {
+	letter, err := eb.deadLetters.Get(id)
+	if err != nil {
+		return err
+	}
+	sub, err := eb.subscriptionByID(letter.SubscriptionID)
+	if err != nil {
+		return err
+	}
+
+	eb.track(1)
+	defer eb.track(-1)
+	attempts, err := eb.attempt(ctx, sub, letter.Event)
+	if err == nil {
+		return eb.deadLetters.Remove(id)
+	}
+	for _, a := range attempts {
+		a.Attempt += len(letter.Attempts)
+		letter.Attempts = append(letter.Attempts, a)
+	}
+	letter.FailedAt = eb.Now()
+	if storeErr := eb.deadLetters.Add(letter); storeErr != nil {
+		return errors.Join(err, storeErr)
+	}
+	return fmt.Errorf("replaying dead letter %s: %w", id, err)
+}
+
+// DiscardDeadLetter drops a dead-lettered event without delivering it
+func (eb *EventBus) DiscardDeadLetter(id string) error {
+	return eb.deadLetters.Remove(id)
+}
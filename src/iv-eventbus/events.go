@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Event payloads hold values rather than pointers: each publish carries a
+// snapshot, so handlers never share memory with the publisher or with each
+// other.
+
+// ConcertAdded is published when a new concert is added
+type ConcertAdded struct {
+	Concert Concert
+}
+
+// EventType implements EventTyper
+func (ConcertAdded) EventType() string { return "concert.added" }
+
+// SchemaVersion implements SchemaVersioner. Version 2 holds the concert by
+// value rather than by pointer.
+func (ConcertAdded) SchemaVersion() int { return 2 }
+
+// PartitionKey implements PartitionKeyer, ordering events per concert
+func (e ConcertAdded) PartitionKey() string { return e.Concert.ID }
+
+// TicketRequested is published when a customer asks for a ticket; the
+// ticket stays pending until a seat is reserved or the request is rejected
+type TicketRequested struct {
+	Ticket Ticket
+}
+
+// EventType implements EventTyper
+func (TicketRequested) EventType() string { return "ticket.requested" }
+
+// SchemaVersion implements SchemaVersioner. Version 2 holds the ticket by
+// value rather than by pointer.
+func (TicketRequested) SchemaVersion() int { return 2 }
+
+// PartitionKey implements PartitionKeyer, ordering events per concert
+func (e TicketRequested) PartitionKey() string { return e.Ticket.ConcertID }
+
+// SeatReserved is published when a seat has been set aside for a ticket
+type SeatReserved struct {
+	TicketID  string
+	ConcertID string
+}
+
+// EventType implements EventTyper
+func (SeatReserved) EventType() string { return "concert.seat.reserved" }
+
+// PartitionKey implements PartitionKeyer, ordering events per concert
+func (e SeatReserved) PartitionKey() string { return e.ConcertID }
+
+// SeatReleased is published when a reserved seat is given back because its
+// ticket was voided before the reservation reached it
+type SeatReleased struct {
+	TicketID  string
+	ConcertID string
+}
+
+// EventType implements EventTyper
+func (SeatReleased) EventType() string { return "concert.seat.released" }
+
+// PartitionKey implements PartitionKeyer, ordering events per concert
+func (e SeatReleased) PartitionKey() string { return e.ConcertID }
+
+// TicketRejected is published when no seat could be reserved for a ticket
+type TicketRejected struct {
+	TicketID  string
+	ConcertID string
+	Reason    string
+}
+
+// EventType implements EventTyper
+func (TicketRejected) EventType() string { return "ticket.rejected" }
+
+// PartitionKey implements PartitionKeyer, ordering events per concert
+func (e TicketRejected) PartitionKey() string { return e.ConcertID }
+
+// TicketPurchased is published when a ticket has been confirmed
+type TicketPurchased struct {
+	Ticket Ticket
+}
+
+// EventType implements EventTyper
+func (TicketPurchased) EventType() string { return "ticket.purchased" }
+
+// SchemaVersion implements SchemaVersioner. Version 2 added the ticket's
+// Status and VoidReason; version 3 holds the ticket by value rather than by
+// pointer.
+func (TicketPurchased) SchemaVersion() int { return 3 }
+
+// upcastTicketPurchasedV1 marks tickets bought before they had a status as
+// confirmed, which every purchased ticket then was
+func upcastTicketPurchasedV1(raw json.RawMessage) (json.RawMessage, error) {
+	var v1 struct {
+		Ticket map[string]interface{}
+	}
+	if err := json.Unmarshal(raw, &v1); err != nil {
+		return nil, err
+	}
+	if v1.Ticket != nil {
+		v1.Ticket["Status"] = TicketConfirmed
+		v1.Ticket["VoidReason"] = ""
+	}
+	return json.Marshal(v1)
+}
+
+// upcastPointerToValue covers payloads written while they held their
+// concert or ticket behind a pointer. The JSON only differs for a nil
+// pointer, written as null, and null decodes into a value as its zero
+// value, so the payload passes through unchanged.
+func upcastPointerToValue(raw json.RawMessage) (json.RawMessage, error) {
+	return raw, nil
+}
+
+// PartitionKey implements PartitionKeyer, ordering events per concert
+func (e TicketPurchased) PartitionKey() string { return e.Ticket.ConcertID }
+
+// NotificationSent is published after a customer has been notified
+type NotificationSent struct {
+	Recipient string
+	Message   string
+}
+
+// EventType implements EventTyper
+func (NotificationSent) EventType() string { return "notification.sent" }
+
+// ReservationExpired is scheduled when a ticket is requested and published
+// if the ticket is still pending when the reservation window ends
+type ReservationExpired struct {
+	TicketID  string
+	ConcertID string
+}
+
+// EventType implements EventTyper
+func (ReservationExpired) EventType() string { return "ticket.reservation.expired" }
+
+// PartitionKey implements PartitionKeyer, ordering events per concert
+func (e ReservationExpired) PartitionKey() string { return e.ConcertID }
+
+// ConcertReminder is scheduled for the day before a concert
+type ConcertReminder struct {
+	ConcertID string
+	Name      string
+	Date      time.Time
+}
+
+// EventType implements EventTyper
+func (ConcertReminder) EventType() string { return "concert.reminder" }
+
+// PartitionKey implements PartitionKeyer, ordering events per concert
+func (e ConcertReminder) PartitionKey() string { return e.ConcertID }
+
+// SeatsQuery asks ConcertService how many seats are left for a concert; it
+// is answered with SeatsAvailable
+type SeatsQuery struct {
+	ConcertID string
+}
+
+// EventType implements EventTyper
+func (SeatsQuery) EventType() string { return "concert.seats.query" }
+
+// PartitionKey implements PartitionKeyer, ordering events per concert
+func (e SeatsQuery) PartitionKey() string { return e.ConcertID }
+
+// SeatsAvailable answers a SeatsQuery
+type SeatsAvailable struct {
+	ConcertID string
+	Available int
+}
+
+// EventType implements EventTyper
+func (SeatsAvailable) EventType() string { return "concert.seats.available" }
+
+// PartitionKey implements PartitionKeyer, ordering events per concert
+func (e SeatsAvailable) PartitionKey() string { return e.ConcertID }
+
+// registerPayloads declares the payload type of every event the services
+// exchange, with the upcasters from their older schema versions, so that
+// stored and received events decode the same way in every process
+func registerPayloads(eb *EventBus) error {
+	for _, register := range []func(*EventBus) error{
+		RegisterPayload[ConcertAdded],
+		RegisterPayload[TicketRequested],
+		RegisterPayload[SeatReserved],
+		RegisterPayload[SeatReleased],
+		RegisterPayload[TicketRejected],
+		RegisterPayload[TicketPurchased],
+		RegisterPayload[NotificationSent],
+		RegisterPayload[ReservationExpired],
+		RegisterPayload[ConcertReminder],
+		RegisterPayload[SeatsQuery],
+		RegisterPayload[SeatsAvailable],
+	} {
+		if err := register(eb); err != nil {
+			return err
+		}
+	}
+	for _, register := range []func(*EventBus) error{
+		func(eb *EventBus) error { return RegisterUpcaster[ConcertAdded](eb, 1, upcastPointerToValue) },
+		func(eb *EventBus) error { return RegisterUpcaster[TicketRequested](eb, 1, upcastPointerToValue) },
+		func(eb *EventBus) error { return RegisterUpcaster[TicketPurchased](eb, 1, upcastTicketPurchasedV1) },
+		func(eb *EventBus) error { return RegisterUpcaster[TicketPurchased](eb, 2, upcastPointerToValue) },
+	} {
+		if err := register(eb); err != nil {
+			return err
+		}
+	}
+	return nil
+}
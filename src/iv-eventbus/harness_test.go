@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// awaitTimeout bounds how long the Await helpers wait for an event
+const awaitTimeout = 5 * time.Second
+
+// Recorder keeps every event a bus accepts, in order, so tests can assert
+// on what was published instead of reading log output:
+//
+//	clock := NewFakeClock(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+//	eb, rec := NewRecordingBus(t, WithClock(clock))
+//	cs, _ := NewConcertService(eb)
+//	cs.AddConcert(ctx, concert)
+//	added := ExpectPayload(t, rec, func(e ConcertAdded) bool { return e.Concert.ID == concert.ID })
+//
+// Events are recorded when they are accepted, before any handler runs;
+// AwaitEvent covers events published later by handlers.
+type Recorder struct {
+	mu     sync.Mutex
+	events []Event
+	// changed is closed and replaced whenever an event is recorded
+	changed chan struct{}
+}
+
+// Matcher selects recorded events; a nil Matcher matches every event
+type Matcher func(Event) bool
+
+// NewRecordingBus creates an in-memory bus with a Recorder attached. The
+// bus is closed when the test ends.
+func NewRecordingBus(t testing.TB, opts ...BusOption) (*EventBus, *Recorder) {
+	t.Helper()
+	r := &Recorder{changed: make(chan struct{})}
+	eb := NewEventBus(append(opts, WithObserver(r.record))...)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), awaitTimeout)
+		defer cancel()
+		if err := eb.Close(ctx); err != nil {
+			t.Errorf("closing bus: %v", err)
+		}
+	})
+	return eb, r
+}
+
+func (r *Recorder) record(event Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+	close(r.changed)
+	r.changed = make(chan struct{})
+}
+
+// Events returns every recorded event in the order the bus accepted them
+func (r *Recorder) Events() []Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Event(nil), r.events...)
+}
+
+// OfType returns the recorded events of eventType, in order
+func (r *Recorder) OfType(eventType string) []Event {
+	var events []Event
+	for _, event := range r.Events() {
+		if event.Type == eventType {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// Reset forgets every recorded event
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = nil
+}
+
+// find returns the first recorded event of eventType that matcher accepts
+func (r *Recorder) find(eventType string, matcher Matcher) (Event, bool) {
+	for _, event := range r.OfType(eventType) {
+		if matcher == nil || matcher(event) {
+			return event, true
+		}
+	}
+	return Event{}, false
+}
+
+// ExpectPublished returns the first recorded event of eventType that
+// matcher accepts, failing the test with what was published instead if
+// there is none
+func (r *Recorder) ExpectPublished(t testing.TB, eventType string, matcher Matcher) Event {
+	t.Helper()
+	event, ok := r.find(eventType, matcher)
+	if !ok {
+		t.Fatalf("no matching %s event was published; got %s", eventType, r.summary())
+	}
+	return event
+}
+
+// ExpectNotPublished fails the test if a recorded event of eventType
+// matches
+func (r *Recorder) ExpectNotPublished(t testing.TB, eventType string, matcher Matcher) {
+	t.Helper()
+	if event, ok := r.find(eventType, matcher); ok {
+		t.Fatalf("unexpected %s event %s was published", eventType, event.ID)
+	}
+}
+
+// ExpectCount fails the test unless exactly n events of eventType were
+// recorded
+func (r *Recorder) ExpectCount(t testing.TB, eventType string, n int) {
+	t.Helper()
+	if got := len(r.OfType(eventType)); got != n {
+		t.Fatalf("want %d %s event(s), got %d", n, eventType, got)
+	}
+}
+
+// ExpectOrder fails the test unless events of the given types were
+// recorded in that order, possibly with other events in between
+func (r *Recorder) ExpectOrder(t testing.TB, eventTypes ...string) {
+	t.Helper()
+	next := 0
+	for _, event := range r.Events() {
+		if next < len(eventTypes) && event.Type == eventTypes[next] {
+			next++
+		}
+	}
+	if next < len(eventTypes) {
+		t.Fatalf("want %s in order, but no %s followed %s; got %s",
+			strings.Join(eventTypes, ", "), eventTypes[next], strings.Join(eventTypes[:next], ", "), r.summary())
+	}
+}
+
+// AwaitEvent waits until an event of eventType has been recorded, or has
+// been already, and returns the first one
+func (r *Recorder) AwaitEvent(t testing.TB, eventType string) Event {
+	t.Helper()
+	return r.AwaitMatch(t, eventType, nil)
+}
+
+// AwaitMatch waits until a recorded event of eventType matches, failing
+// the test after awaitTimeout
+func (r *Recorder) AwaitMatch(t testing.TB, eventType string, matcher Matcher) Event {
+	t.Helper()
+	timeout := time.After(awaitTimeout)
+	for {
+		r.mu.Lock()
+		changed := r.changed
+		r.mu.Unlock()
+
+		if event, ok := r.find(eventType, matcher); ok {
+			return event
+		}
+		select {
+		case <-changed:
+		case <-timeout:
+			t.Fatalf("no matching %s event after %s; got %s", eventType, awaitTimeout, r.summary())
+		}
+	}
+}
+
+// summary lists the recorded event types for failure messages
+func (r *Recorder) summary() string {
+	events := r.Events()
+	if len(events) == 0 {
+		return "no events"
+	}
+	types := make([]string, len(events))
+	for i, event := range events {
+		types[i] = event.Type
+	}
+	return "[" + strings.Join(types, ", ") + "]"
+}
+
+// MatchPayload adapts a predicate on payloads of type T to a Matcher
+func MatchPayload[T any](match func(T) bool) Matcher {
+	return func(event Event) bool {
+		payload, ok := event.Payload.(T)
+		return ok && (match == nil || match(payload))
+	}
+}
+
+// MatchCorrelation matches events belonging to the flow correlationID
+func MatchCorrelation(correlationID string) Matcher {
+	return func(event Event) bool {
+		return event.CorrelationID() == correlationID
+	}
+}
+
+// ExpectPayload returns the payload of the first recorded T event that
+// match accepts
+func ExpectPayload[T any](t testing.TB, r *Recorder, match func(T) bool) T {
+	t.Helper()
+	event, ok := r.find(EventTypeOf[T](), MatchPayload(match))
+	if !ok {
+		t.Fatalf("no matching %s was published; got %s", reflect.TypeOf((*T)(nil)).Elem(), r.summary())
+	}
+	return event.Payload.(T)
+}
+
+// AwaitPayload waits for a T event that match accepts and returns its
+// payload
+func AwaitPayload[T any](t testing.TB, r *Recorder, match func(T) bool) T {
+	t.Helper()
+	return r.AwaitMatch(t, EventTypeOf[T](), MatchPayload(match)).Payload.(T)
+}
+
+func TestRecorderExpectations(t *testing.T) {
+	eb, rec := NewRecordingBus(t)
+	ctx := context.Background()
+	first := Concert{ID: "c1", Name: "First"}
+	second := Concert{ID: "c2", Name: "Second"}
+	for _, concert := range []Concert{first, second} {
+		if err := Publish(ctx, eb, ConcertAdded{Concert: concert}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := Publish(ctx, eb, SeatReserved{TicketID: "t1", ConcertID: "c1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	rec.ExpectCount(t, EventTypeOf[ConcertAdded](), 2)
+	rec.ExpectOrder(t, "concert.added", "concert.seat.reserved")
+	rec.ExpectNotPublished(t, EventTypeOf[TicketRejected](), nil)
+	got := ExpectPayload(t, rec, func(e ConcertAdded) bool { return e.Concert.ID == second.ID })
+	if got.Concert.Name != second.Name {
+		t.Fatalf("want concert %q, got %q", second.Name, got.Concert.Name)
+	}
+	rec.Reset()
+	if events := rec.Events(); len(events) != 0 {
+		t.Fatalf("want no events after Reset, got %d", len(events))
+	}
+}
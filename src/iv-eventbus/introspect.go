@@ -0,0 +1,112 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+// SubscriptionInfo describes a live subscription
+type SubscriptionInfo struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Pattern   string `json:"pattern"`
+	Responder bool   `json:"responder,omitempty"`
+	Attempts  int    `json:"attempts"`
+}
+
+// InFlightDelivery is a handler invocation that has not returned yet
+type InFlightDelivery struct {
+	EventID        string    `json:"event_id"`
+	EventType      string    `json:"event_type"`
+	SubscriptionID string    `json:"subscription_id"`
+	Subscription   string    `json:"subscription"`
+	Attempt        int       `json:"attempt"`
+	Started        time.Time `json:"started"`
+}
+
+// WithObserver calls observe with every event the bus accepts, in order,
+// before any handler runs. It is called under the bus's read lock, possibly
+// from several publishers at once, so it must be safe for concurrent use,
+// quick, and must not call back into the bus.
+func WithObserver(observe func(Event)) BusOption {
+	return func(eb *EventBus) {
+		eb.observers = append(eb.observers, observe)
+	}
+}
+
+// Subscriptions lists the live subscriptions ordered by pattern and name
+func (eb *EventBus) Subscriptions() []SubscriptionInfo {
+	eb.mu.RLock()
+	infos := make([]SubscriptionInfo, 0, len(eb.byID))
+	for _, sub := range eb.byID {
+		infos = append(infos, SubscriptionInfo{
+			ID:        sub.id,
+			Name:      sub.name,
+			Pattern:   sub.pattern,
+			Responder: sub.responder,
+			Attempts:  sub.retry.attempts(),
+		})
+	}
+	eb.mu.RUnlock()
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].Pattern != infos[j].Pattern {
+			return infos[i].Pattern < infos[j].Pattern
+		}
+		return infos[i].Name < infos[j].Name
+	})
+	return infos
+}
+
+// SubscriptionsFor lists the live subscriptions an event of eventType would
+// be delivered to
+func (eb *EventBus) SubscriptionsFor(eventType string) []SubscriptionInfo {
+	var infos []SubscriptionInfo
+	for _, info := range eb.Subscriptions() {
+		if info.Pattern == eventType || topicMatches(info.Pattern, eventType) {
+			infos = append(infos, info)
+		}
+	}
+	return infos
+}
+
+// Pending is the number of deliveries accepted but not finished, whether
+// queued on the worker pool, running or waiting to retry
+func (eb *EventBus) Pending() int {
+	eb.inflightMu.Lock()
+	defer eb.inflightMu.Unlock()
+	return eb.inflight
+}
+
+// InFlight lists the handler invocations running now, oldest first
+func (eb *EventBus) InFlight() []InFlightDelivery {
+	eb.inflightMu.Lock()
+	deliveries := make([]InFlightDelivery, 0, len(eb.running))
+	for _, d := range eb.running {
+		deliveries = append(deliveries, d)
+	}
+	eb.inflightMu.Unlock()
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].Started.Before(deliveries[j].Started) })
+	return deliveries
+}
+
+// started records that sub's handler began attempt n at event; the
+// returned function records that it returned
+func (eb *EventBus) started(sub *Subscription, event Event, n int) func() {
+	eb.inflightMu.Lock()
+	defer eb.inflightMu.Unlock()
+	eb.runningNext++
+	key := eb.runningNext
+	eb.running[key] = InFlightDelivery{
+		EventID:        event.ID,
+		EventType:      event.Type,
+		SubscriptionID: sub.id,
+		Subscription:   sub.name,
+		Attempt:        n,
+		Started:        eb.Now(),
+	}
+	return func() {
+		eb.inflightMu.Lock()
+		defer eb.inflightMu.Unlock()
+		delete(eb.running, key)
+	}
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ErrJournalCorrupt is returned when a journal record before the end of the
+// file fails its checksum or cannot be decoded
+var ErrJournalCorrupt = errors.New("journal is corrupt")
+
+var journalCRC = crc32.MakeTable(crc32.Castagnoli)
+
+// renameFile replaces the journal with its compacted copy; tests stub it
+// to make the replacement fail
+var renameFile = os.Rename
+
+// JournalEntry is one event as stored in the journal
+type JournalEntry struct {
+	Seq       uint64            `json:"seq"`
+	ID        string            `json:"id"`
+	Type      string            `json:"type"`
+	Payload   json.RawMessage   `json:"payload"`
+	Timestamp time.Time         `json:"timestamp"`
+	Headers   map[string]string `json:"headers,omitempty"`
+}
+
+// Journal is an append-only file of events. Each record is a line holding a
+// CRC-32C checksum and the JSON-encoded entry, so a torn or altered record
+// is detected when the journal is read.
+type Journal struct {
+	path    string
+	file    *os.File
+	mu      sync.Mutex
+	lastSeq uint64
+	// size is the offset the next record is written at
+	size  int64
+	index *journalIndex
+}
+
+// journalIndex finds the records of one flow without scanning the journal
+type journalIndex struct {
+	// offsets maps a sequence number to where its record starts
+	offsets map[uint64]int64
+	// flows lists each correlation ID's sequence numbers in order
+	flows map[string][]uint64
+	// flowOf maps an event ID to its correlation ID
+	flowOf map[string]string
+}
+
+func newJournalIndex() *journalIndex {
+	return &journalIndex{
+		offsets: make(map[uint64]int64),
+		flows:   make(map[string][]uint64),
+		flowOf:  make(map[string]string),
+	}
+}
+
+func (ix *journalIndex) add(entry JournalEntry, offset int64) {
+	correlationID := entry.Headers[HeaderCorrelationID]
+	if correlationID == "" {
+		correlationID = entry.ID
+	}
+	ix.offsets[entry.Seq] = offset
+	ix.flows[correlationID] = append(ix.flows[correlationID], entry.Seq)
+	ix.flowOf[entry.ID] = correlationID
+}
+
+// OpenJournal opens or creates the journal at path. A damaged final record,
+// as left by a crash mid-write, is truncated; damage anywhere else is
+// reported as ErrJournalCorrupt.
+func OpenJournal(path string) (*Journal, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	j := &Journal{path: path, file: file, index: newJournalIndex()}
+
+	var good int64
+	err = scanJournal(file, func(entry JournalEntry, end int64) error {
+		j.index.add(entry, good)
+		j.lastSeq = entry.Seq
+		good = end
+		return nil
+	})
+	var tail *journalTailError
+	if errors.As(err, &tail) {
+		log.Printf("Journal %s: truncating damaged final record at offset %d", path, good)
+		if err = file.Truncate(good); err == nil {
+			err = file.Sync()
+		}
+	}
+	if err == nil {
+		j.size, err = file.Seek(0, io.SeekEnd)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return j, nil
+}
+
+// journalTailError marks a damaged record with nothing after it
+type journalTailError struct {
+	err error
+}
+
+func (e *journalTailError) Error() string {
+	return e.err.Error()
+}
+
+// scanJournal calls fn for every record in order with the offset just past
+// it. A bad last record yields a journalTailError; a bad record followed by
+// more data yields ErrJournalCorrupt.
+func scanJournal(r io.ReadSeeker, fn func(entry JournalEntry, end int64) error) error {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	reader := bufio.NewReader(r)
+	var offset int64
+	for line := 1; ; line++ {
+		record, readErr := reader.ReadBytes('\n')
+		if len(record) == 0 && readErr == io.EOF {
+			return nil
+		}
+		if readErr != nil && readErr != io.EOF {
+			return readErr
+		}
+		entry, err := decodeJournalRecord(record)
+		if err != nil {
+			if _, peekErr := reader.Peek(1); peekErr == io.EOF {
+				return &journalTailError{err: err}
+			}
+			return fmt.Errorf("%w: record %d at offset %d: %v", ErrJournalCorrupt, line, offset, err)
+		}
+		offset += int64(len(record))
+		if err := fn(entry, offset); err != nil {
+			return err
+		}
+	}
+}
+
+func encodeJournalRecord(entry JournalEntry) ([]byte, error) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(fmt.Sprintf("%08x %s\n", crc32.Checksum(data, journalCRC), data)), nil
+}
+
+func decodeJournalRecord(record []byte) (JournalEntry, error) {
+	var entry JournalEntry
+	if len(record) == 0 || record[len(record)-1] != '\n' {
+		return entry, errors.New("incomplete record")
+	}
+	checksum, data, ok := bytes.Cut(bytes.TrimSuffix(record, []byte("\n")), []byte(" "))
+	if !ok {
+		return entry, errors.New("missing checksum")
+	}
+	var want uint32
+	if _, err := fmt.Sscanf(string(checksum), "%08x", &want); err != nil {
+		return entry, fmt.Errorf("bad checksum field: %v", err)
+	}
+	if got := crc32.Checksum(data, journalCRC); got != want {
+		return entry, fmt.Errorf("checksum mismatch: want %08x, got %08x", want, got)
+	}
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return entry, err
+	}
+	return entry, nil
+}
+
+// Append writes event with the next sequence number and syncs it to disk
+func (j *Journal) Append(event Event) (uint64, error) {
+	payload, err := json.Marshal(event.Payload)
+	if err != nil {
+		return 0, fmt.Errorf("encoding payload of %s: %w", event.Type, err)
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	entry := JournalEntry{
+		Seq:       j.lastSeq + 1,
+		ID:        event.ID,
+		Type:      event.Type,
+		Payload:   payload,
+		Timestamp: event.Timestamp,
+		Headers:   event.Headers,
+	}
+	record, err := encodeJournalRecord(entry)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := j.file.Write(record); err != nil {
+		return 0, err
+	}
+	if err := j.file.Sync(); err != nil {
+		return 0, err
+	}
+	j.index.add(entry, j.size)
+	j.size += int64(len(record))
+	j.lastSeq = entry.Seq
+	return entry.Seq, nil
+}
+
+// LastSeq returns the sequence number of the newest entry, or 0 if empty
+func (j *Journal) LastSeq() uint64 {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.lastSeq
+}
+
+// Read calls fn for every entry with a sequence number from from to to
+// inclusive, in order; to of 0 reads to the end
+func (j *Journal) Read(from, to uint64, fn func(JournalEntry) error) error {
+	file, err := os.Open(j.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	errStop := errors.New("stop")
+	err = scanJournal(file, func(entry JournalEntry, _ int64) error {
+		if to > 0 && entry.Seq > to {
+			return errStop
+		}
+		if entry.Seq < from {
+			return nil
+		}
+		return fn(entry)
+	})
+	var tail *journalTailError
+	if err == errStop || errors.As(err, &tail) {
+		// A partial record at the end is an append still in progress
+		return nil
+	}
+	return err
+}
+
+// ReadFlow calls fn, in order, for every entry sharing eventID's
+// correlation ID, reading only those records. It reports false if eventID
+// is not in the journal.
+func (j *Journal) ReadFlow(eventID string, fn func(JournalEntry) error) (bool, error) {
+	j.mu.Lock()
+	correlationID, ok := j.index.flowOf[eventID]
+	if !ok {
+		j.mu.Unlock()
+		return false, nil
+	}
+	seqs := j.index.flows[correlationID]
+	offsets := make([]int64, len(seqs))
+	for i, seq := range seqs {
+		offsets[i] = j.index.offsets[seq]
+	}
+	// Opened under the lock, the file matches the offsets even if a
+	// compaction replaces it meanwhile
+	file, err := os.Open(j.path)
+	j.mu.Unlock()
+	if err != nil {
+		return true, err
+	}
+	defer file.Close()
+
+	for _, offset := range offsets {
+		record, err := bufio.NewReader(io.NewSectionReader(file, offset, math.MaxInt64-offset)).ReadBytes('\n')
+		if err != nil {
+			return true, fmt.Errorf("%w: record at offset %d: %v", ErrJournalCorrupt, offset, err)
+		}
+		entry, err := decodeJournalRecord(record)
+		if err != nil {
+			return true, fmt.Errorf("%w: record at offset %d: %v", ErrJournalCorrupt, offset, err)
+		}
+		if err := fn(entry); err != nil {
+			return true, err
+		}
+	}
+	return true, nil
+}
+
+// Compact rewrites the journal keeping only the entries for which keep
+// returns true, and returns how many were removed. Sequence numbers are
+// preserved, so offsets held by consumers stay valid. The new file replaces
+// the old one atomically; if it cannot, the journal keeps appending to the
+// old file.
+func (j *Journal) Compact(keep func(JournalEntry) bool) (int, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	tmp, err := os.CreateTemp(filepath.Dir(j.path), filepath.Base(j.path)+".compact-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	removed := 0
+	index := newJournalIndex()
+	var size int64
+	writer := bufio.NewWriter(tmp)
+	err = scanJournal(j.file, func(entry JournalEntry, _ int64) error {
+		if !keep(entry) {
+			removed++
+			return nil
+		}
+		record, err := encodeJournalRecord(entry)
+		if err != nil {
+			return err
+		}
+		index.add(entry, size)
+		size += int64(len(record))
+		_, err = writer.Write(record)
+		return err
+	})
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	// The compacted file stays open to be appended to, so once it has been
+	// renamed into place nothing can fail before the journal switches to it
+	if err == nil {
+		_, err = tmp.Seek(0, io.SeekEnd)
+	}
+	if err == nil {
+		err = tmp.Chmod(0o644)
+	}
+	if err == nil {
+		err = renameFile(tmp.Name(), j.path)
+	}
+	if err != nil {
+		tmp.Close()
+		j.file.Seek(0, io.SeekEnd)
+		return 0, err
+	}
+
+	j.file.Close()
+	j.file = tmp
+	j.size = size
+	j.index = index
+	return removed, nil
+}
+
+// Close closes the journal file
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.file.Close()
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// journaledTypes lists the event types in the journal, in order
+func journaledTypes(t *testing.T, j *Journal) []string {
+	t.Helper()
+	var types []string
+	if err := j.Read(1, 0, func(entry JournalEntry) error {
+		types = append(types, entry.Type)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return types
+}
+
+func TestCompactJournalKeepsRestorableState(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), awaitTimeout)
+	defer cancel()
+	path := filepath.Join(t.TempDir(), "events.journal")
+	journal, err := OpenJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+
+	clock := NewFakeClock(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	eb, _ := NewRecordingBus(t, WithClock(clock), WithJournal(journal))
+	if err := registerPayloads(eb); err != nil {
+		t.Fatal(err)
+	}
+	cs, err := NewConcertService(eb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	concert := &Concert{Name: "Rock Concert", Date: clock.Now().AddDate(0, 1, 0), AvailableTickets: 10}
+	if err := cs.AddConcert(ctx, concert); err != nil {
+		t.Fatal(err)
+	}
+	if err := Publish(ctx, eb, TicketRequested{Ticket: Ticket{ID: "t1", ConcertID: concert.ID}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := eb.Drain(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// The reply to a request is delivered but never journaled
+	if seats, err := Request[SeatsQuery, SeatsAvailable](ctx, eb, SeatsQuery{ConcertID: concert.ID}); err != nil || seats.Available != 9 {
+		t.Fatalf("want 9 seats, got %+v, %v", seats, err)
+	}
+
+	clock.Advance(48 * time.Hour)
+	if err := Publish(ctx, eb, TicketRequested{Ticket: Ticket{ID: "t2", ConcertID: "missing"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := eb.Drain(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, eventType := range journaledTypes(t, journal) {
+		if strings.HasPrefix(eventType, replyTopicPrefix) {
+			t.Fatalf("reply %s was journaled", eventType)
+		}
+	}
+
+	api := NewAPI(eb, cs, nil, nil, nil, nil)
+	rec := httptest.NewRecorder()
+	api.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/admin/journal/compact?older_than=24h", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("want 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var result struct{ Removed int }
+	if err := json.NewDecoder(rec.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+
+	// The old request and query go; what Restore needs and the recent
+	// events stay
+	want := []string{"concert.added", "concert.seat.reserved", "ticket.requested", "ticket.rejected"}
+	got := journaledTypes(t, journal)
+	if strings.Join(got, ",") != strings.Join(want, ",") || result.Removed != 2 {
+		t.Fatalf("want %v left after removing 2, got %v after removing %d", want, got, result.Removed)
+	}
+
+	restoreBus, _ := NewRecordingBus(t, WithJournal(journal))
+	restored, err := NewConcertService(restoreBus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := restored.Restore(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := restored.Concert(concert.ID); !ok || got.AvailableTickets != 9 {
+		t.Fatalf("want the concert restored with 9 seats, got %+v, %v", got, ok)
+	}
+}
+
+func TestCompactJournalNeedsDuration(t *testing.T) {
+	eb, _ := NewRecordingBus(t)
+	cs, err := NewConcertService(eb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := NewAPI(eb, cs, nil, nil, nil, nil)
+	for target, want := range map[string]int{
+		"/admin/journal/compact":                http.StatusBadRequest,
+		"/admin/journal/compact?older_than=-1h": http.StatusBadRequest,
+		"/admin/journal/compact?older_than=24h": http.StatusNotFound,
+	} {
+		rec := httptest.NewRecorder()
+		api.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, target, nil))
+		if rec.Code != want {
+			t.Errorf("%s: want %d, got %d", target, want, rec.Code)
+		}
+	}
+}
+
+// appendAndReopen appends events to the journal, closes it and returns
+// the IDs a fresh journal on the same path reads back
+func appendAndReopen(t *testing.T, journal *Journal, path string, ids ...string) []string {
+	t.Helper()
+	for _, id := range ids {
+		if _, err := journal.Append(Event{ID: id, Type: "test.event", Payload: struct{}{}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := journal.Close(); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := OpenJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	var got []string
+	if err := reopened.Read(1, 0, func(entry JournalEntry) error {
+		got = append(got, entry.ID)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+func TestCompactedJournalKeepsAppending(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.journal")
+	journal, err := OpenJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"e1", "e2", "e3"} {
+		if _, err := journal.Append(Event{ID: id, Type: "test.event", Payload: struct{}{}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := journal.Compact(func(entry JournalEntry) bool { return entry.ID != "e2" }); err != nil {
+		t.Fatal(err)
+	}
+	got := appendAndReopen(t, journal, path, "e4")
+	if strings.Join(got, ",") != "e1,e3,e4" {
+		t.Fatalf("want e1,e3,e4 on disk after compacting and appending, got %v", got)
+	}
+}
+
+func TestFailedCompactionKeepsJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.journal")
+	journal, err := OpenJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := journal.Append(Event{ID: "e1", Type: "test.event", Payload: struct{}{}}); err != nil {
+		t.Fatal(err)
+	}
+
+	renameFile = func(string, string) error { return errors.New("disk full") }
+	defer func() { renameFile = os.Rename }()
+	if _, err := journal.Compact(func(JournalEntry) bool { return false }); err == nil {
+		t.Fatal("want the compaction to fail")
+	}
+
+	got := appendAndReopen(t, journal, path, "e2")
+	if strings.Join(got, ",") != "e1,e2" {
+		t.Fatalf("want the uncompacted journal appended to, got %v", got)
+	}
+}
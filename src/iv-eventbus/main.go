@@ -2,58 +2,18 @@ package main
 
 import (
     "context"
+    "errors"
+    "flag"
     "fmt"
     "log"
+    "log/slog"
+    "net/http"
     "sync"
     "time"
 
     "github.com/google/uuid"
 )
 
-// Event represents a domain event in the system
-type Event struct {
-    ID        string
-    Type      string
-    Payload   interface{}
-    Timestamp time.Time
-}
-
-// EventHandler is a function that processes an event
-type EventHandler func(context.Context, Event) error
-
-// EventBus manages subscriptions and publishing of events
-type EventBus struct {
-    handlers map[string][]EventHandler
-    mu       sync.RWMutex
-}
-
-// NewEventBus creates a new EventBus
-func NewEventBus() *EventBus {
-    return &EventBus{
-        handlers: make(map[string][]EventHandler),
-    }
-}
-
-// Subscribe adds a handler for a specific event type
-func (eb *EventBus) Subscribe(eventType string, handler EventHandler) {
-    eb.mu.Lock()
-    defer eb.mu.Unlock()
-    eb.handlers[eventType] = append(eb.handlers[eventType], handler)
-}
-
-// Publish sends an event to all subscribed handlers
-func (eb *EventBus) Publish(ctx context.Context, event Event) {
-    eb.mu.RLock()
-    defer eb.mu.RUnlock()
-    for _, handler := range eb.handlers[event.Type] {
-        go func(h EventHandler) {
-            if err := h(ctx, event); err != nil {
-                log.Printf("Error handling event %s: %v", event.ID, err)
-            }
-        }(handler)
-    }
-}
-
 // Concert represents a concert in the system
 type Concert struct {
     ID               string
@@ -64,6 +24,15 @@ type Concert struct {
     TicketPrice      float64
 }
 
+// TicketStatus tracks a ticket through the purchase flow
+type TicketStatus string
+
+const (
+    TicketPending   TicketStatus = "pending"
+    TicketConfirmed TicketStatus = "confirmed"
+    TicketVoided    TicketStatus = "voided"
+)
+
 // Ticket represents a concert ticket
 type Ticket struct {
     ID          string
@@ -71,8 +40,16 @@ type Ticket struct {
     CustomerName string
     CustomerEmail string
     PurchaseDate time.Time
+    Status       TicketStatus
+    VoidReason   string
 }
 
+// reservationWindow is how long a ticket may stay pending before it expires
+const reservationWindow = 10 * time.Minute
+
+// reminderLead is how long before a concert its reminder is sent
+const reminderLead = 24 * time.Hour
+
 // ConcertService manages concert-related operations
 type ConcertService struct {
     eventBus *EventBus
@@ -81,38 +58,151 @@ type ConcertService struct {
 }
 
 // NewConcertService creates a new ConcertService
-func NewConcertService(eb *EventBus) *ConcertService {
+func NewConcertService(eb *EventBus) (*ConcertService, error) {
     cs := &ConcertService{
         eventBus: eb,
         concerts: make(map[string]*Concert),
     }
-    eb.Subscribe("TicketPurchased", cs.handleTicketPurchased)
-    return cs
+    if _, err := Subscribe(eb, cs.handleTicketRequested, WithName("ConcertService"), WithRetry(DefaultRetryPolicy)); err != nil {
+        return nil, err
+    }
+    if _, err := Subscribe(eb, cs.handleSeatReleased, WithName("ConcertService"), WithRetry(DefaultRetryPolicy)); err != nil {
+        return nil, err
+    }
+    if _, err := Respond(eb, cs.handleSeatsQuery, WithName("ConcertService")); err != nil {
+        return nil, err
+    }
+    return cs, nil
 }
 
-// AddConcert adds a new concert and publishes an event
+// AddConcert adds a new concert, publishes an event and schedules a
+// reminder for the day before it. The service keeps its own copy, so the
+// caller's Concert only receives the new ID.
 func (cs *ConcertService) AddConcert(ctx context.Context, concert *Concert) error {
-    cs.mu.Lock()
-    defer cs.mu.Unlock()
     concert.ID = uuid.New().String()
-    cs.concerts[concert.ID] = concert
+    stored := *concert
+    cs.mu.Lock()
+    cs.concerts[stored.ID] = &stored
+    cs.mu.Unlock()
     
-    cs.eventBus.Publish(ctx, Event{
-        ID:        uuid.New().String(),
-        Type:      "ConcertAdded",
-        Payload:   concert,
-        Timestamp: time.Now(),
+    if err := Publish(ctx, cs.eventBus, ConcertAdded{Concert: *concert}); err != nil {
+        return err
+    }
+    reminder := ConcertReminder{ConcertID: concert.ID, Name: concert.Name, Date: concert.Date}
+    _, err := PublishAt(ctx, cs.eventBus, reminder, concert.Date.Add(-reminderLead))
+    return err
+}
+
+// Restore rebuilds the concerts map from the event bus journal, replaying
+// every concert added and seat reserved or released since the journal
+// began, and returns the number of concerts restored
+func (cs *ConcertService) Restore(ctx context.Context) (int, error) {
+    if err := RegisterPayload[ConcertAdded](cs.eventBus); err != nil {
+        return 0, err
+    }
+    if err := RegisterPayload[SeatReserved](cs.eventBus); err != nil {
+        return 0, err
+    }
+    if err := RegisterPayload[SeatReleased](cs.eventBus); err != nil {
+        return 0, err
+    }
+    err := cs.eventBus.Replay(ctx, 1, "#", func(ctx context.Context, event Event) error {
+        cs.mu.Lock()
+        defer cs.mu.Unlock()
+        switch payload := event.Payload.(type) {
+        case ConcertAdded:
+            concert := payload.Concert
+            cs.concerts[concert.ID] = &concert
+        case SeatReserved:
+            if concert, ok := cs.concerts[payload.ConcertID]; ok {
+                concert.AvailableTickets--
+            }
+        case SeatReleased:
+            if concert, ok := cs.concerts[payload.ConcertID]; ok {
+                concert.AvailableTickets++
+            }
+        }
+        return nil
     })
-    return nil
+    cs.mu.RLock()
+    defer cs.mu.RUnlock()
+    return len(cs.concerts), err
+}
+
+// Retains reports whether Restore needs entry, which must then survive
+// journal compaction however old it is
+func (cs *ConcertService) Retains(entry JournalEntry) bool {
+    switch entry.Type {
+    case EventTypeOf[ConcertAdded](), EventTypeOf[SeatReserved](), EventTypeOf[SeatReleased]():
+        return true
+    }
+    return false
+}
+
+// Concert returns a copy of the concert with the given ID
+func (cs *ConcertService) Concert(id string) (Concert, bool) {
+    cs.mu.RLock()
+    defer cs.mu.RUnlock()
+    concert, ok := cs.concerts[id]
+    if !ok {
+        return Concert{}, false
+    }
+    return *concert, true
+}
+
+// Concerts returns a copy of every concert
+func (cs *ConcertService) Concerts() []Concert {
+    cs.mu.RLock()
+    defer cs.mu.RUnlock()
+    concerts := make([]Concert, 0, len(cs.concerts))
+    for _, concert := range cs.concerts {
+        concerts = append(concerts, *concert)
+    }
+    return concerts
+}
+
+func (cs *ConcertService) handleSeatsQuery(ctx context.Context, query SeatsQuery) (SeatsAvailable, error) {
+    cs.mu.RLock()
+    defer cs.mu.RUnlock()
+    concert, ok := cs.concerts[query.ConcertID]
+    if !ok {
+        return SeatsAvailable{}, fmt.Errorf("concert %s not found", query.ConcertID)
+    }
+    return SeatsAvailable{ConcertID: concert.ID, Available: concert.AvailableTickets}, nil
 }
 
-func (cs *ConcertService) handleTicketPurchased(ctx context.Context, event Event) error {
-    ticket := event.Payload.(*Ticket)
+// handleTicketRequested reserves a seat for the ticket if the concert exists
+// and is not sold out, and answers with SeatReserved or TicketRejected. The
+// check and the decrement happen under one lock, so seats cannot be oversold.
+func (cs *ConcertService) handleTicketRequested(ctx context.Context, event TicketRequested) error {
+    ticket := event.Ticket
     cs.mu.Lock()
-    defer cs.mu.Unlock()
-    if concert, ok := cs.concerts[ticket.ConcertID]; ok {
+    concert, ok := cs.concerts[ticket.ConcertID]
+    reason := ""
+    switch {
+    case !ok:
+        reason = "concert not found"
+    case concert.AvailableTickets <= 0:
+        reason = "concert is sold out"
+    default:
         concert.AvailableTickets--
     }
+    cs.mu.Unlock()
+
+    if reason != "" {
+        return Publish(ctx, cs.eventBus, TicketRejected{TicketID: ticket.ID, ConcertID: ticket.ConcertID, Reason: reason})
+    }
+    return Publish(ctx, cs.eventBus, SeatReserved{TicketID: ticket.ID, ConcertID: ticket.ConcertID})
+}
+
+// handleSeatReleased gives back a seat reserved for a ticket that was
+// voided before the reservation reached it
+func (cs *ConcertService) handleSeatReleased(ctx context.Context, event SeatReleased) error {
+    cs.mu.Lock()
+    defer cs.mu.Unlock()
+    if concert, ok := cs.concerts[event.ConcertID]; ok {
+        concert.AvailableTickets++
+    }
     return nil
 }
 
@@ -120,73 +210,443 @@ func (cs *ConcertService) handleTicketPurchased(ctx context.Context, event Event
 type TicketService struct {
     eventBus *EventBus
     tickets  map[string]*Ticket
+    expiries map[string]*ScheduleToken
     mu       sync.RWMutex
 }
 
 // NewTicketService creates a new TicketService
-func NewTicketService(eb *EventBus) *TicketService {
-    return &TicketService{
+func NewTicketService(eb *EventBus) (*TicketService, error) {
+    ts := &TicketService{
         eventBus: eb,
         tickets:  make(map[string]*Ticket),
+        expiries: make(map[string]*ScheduleToken),
+    }
+    if _, err := Subscribe(eb, ts.handleSeatReserved, WithName("TicketService"), WithRetry(DefaultRetryPolicy)); err != nil {
+        return nil, err
+    }
+    if _, err := Subscribe(eb, ts.handleTicketRejected, WithName("TicketService"), WithRetry(DefaultRetryPolicy)); err != nil {
+        return nil, err
     }
+    if _, err := Subscribe(eb, ts.handleReservationExpired, WithName("TicketService"), WithRetry(DefaultRetryPolicy)); err != nil {
+        return nil, err
+    }
+    return ts, nil
 }
 
-// PurchaseTicket creates a new ticket and publishes an event
+// PurchaseTicket records a pending ticket and requests a seat for it. The
+// ticket is confirmed or voided once ConcertService answers, or voided when
+// the reservation window ends without an answer.
 func (ts *TicketService) PurchaseTicket(ctx context.Context, ticket *Ticket) error {
-    ts.mu.Lock()
-    defer ts.mu.Unlock()
     ticket.ID = uuid.New().String()
-    ticket.PurchaseDate = time.Now()
-    ts.tickets[ticket.ID] = ticket
+    ticket.PurchaseDate = ts.eventBus.Now()
+    ticket.Status = TicketPending
+    stored := *ticket
+
+    // The expiry is scheduled before the seat is requested, so an answer
+    // always finds the token to cancel
+    expired := ReservationExpired{TicketID: ticket.ID, ConcertID: ticket.ConcertID}
+    token, err := PublishAfter(ctx, ts.eventBus, expired, reservationWindow)
+    if err != nil {
+        return err
+    }
+    ts.mu.Lock()
+    ts.tickets[stored.ID] = &stored
+    ts.expiries[stored.ID] = token
+    ts.mu.Unlock()
     
-    ts.eventBus.Publish(ctx, Event{
-        ID:        uuid.New().String(),
-        Type:      "TicketPurchased",
-        Payload:   ticket,
-        Timestamp: time.Now(),
-    })
+    return Publish(ctx, ts.eventBus, TicketRequested{Ticket: *ticket})
+}
+
+// Ticket returns a copy of the ticket with the given ID
+func (ts *TicketService) Ticket(ticketID string) (Ticket, bool) {
+    ts.mu.RLock()
+    defer ts.mu.RUnlock()
+    ticket, ok := ts.tickets[ticketID]
+    if !ok {
+        return Ticket{}, false
+    }
+    return *ticket, true
+}
+
+// Tickets returns a copy of every ticket
+func (ts *TicketService) Tickets() []Ticket {
+    ts.mu.RLock()
+    defer ts.mu.RUnlock()
+    tickets := make([]Ticket, 0, len(ts.tickets))
+    for _, ticket := range ts.tickets {
+        tickets = append(tickets, *ticket)
+    }
+    return tickets
+}
+
+// Status returns the status of a ticket and, for a voided ticket, why
+func (ts *TicketService) Status(ticketID string) (TicketStatus, string, bool) {
+    ts.mu.RLock()
+    defer ts.mu.RUnlock()
+    ticket, ok := ts.tickets[ticketID]
+    if !ok {
+        return "", "", false
+    }
+    return ticket.Status, ticket.VoidReason, true
+}
+
+// handleSeatReserved confirms a pending ticket. A reservation that arrives
+// after the ticket expired is released again, or the seat would be lost.
+func (ts *TicketService) handleSeatReserved(ctx context.Context, event SeatReserved) error {
+    ts.mu.Lock()
+    ticket, ok := ts.tickets[event.TicketID]
+    if !ok || ticket.Status != TicketPending {
+        voided := ok && ticket.Status == TicketVoided
+        ts.mu.Unlock()
+        if voided {
+            return Publish(ctx, ts.eventBus, SeatReleased{TicketID: event.TicketID, ConcertID: event.ConcertID})
+        }
+        return nil
+    }
+    ticket.Status = TicketConfirmed
+    confirmed := *ticket
+    token := ts.takeExpiry(ticket.ID)
+    ts.mu.Unlock()
+
+    cancelExpiry(token, confirmed.ID)
+    return Publish(ctx, ts.eventBus, TicketPurchased{Ticket: confirmed})
+}
+
+func (ts *TicketService) handleTicketRejected(ctx context.Context, event TicketRejected) error {
+    ts.mu.Lock()
+    ticket, ok := ts.tickets[event.TicketID]
+    if !ok || ticket.Status != TicketPending {
+        ts.mu.Unlock()
+        return nil
+    }
+    ticket.Status = TicketVoided
+    ticket.VoidReason = event.Reason
+    token := ts.takeExpiry(ticket.ID)
+    ts.mu.Unlock()
+
+    cancelExpiry(token, event.TicketID)
     return nil
 }
 
-// NotificationService sends notifications based on events
+func (ts *TicketService) handleReservationExpired(ctx context.Context, event ReservationExpired) error {
+    ts.mu.Lock()
+    defer ts.mu.Unlock()
+    delete(ts.expiries, event.TicketID)
+    if ticket, ok := ts.tickets[event.TicketID]; ok && ticket.Status == TicketPending {
+        ticket.Status = TicketVoided
+        ticket.VoidReason = "reservation expired"
+    }
+    return nil
+}
+
+// takeExpiry removes and returns a settled ticket's expiry token, if it
+// still has one; ts.mu must be held
+func (ts *TicketService) takeExpiry(ticketID string) *ScheduleToken {
+    token := ts.expiries[ticketID]
+    delete(ts.expiries, ticketID)
+    return token
+}
+
+// cancelExpiry cancels an expiry taken with takeExpiry; it may touch the
+// schedule store, so it runs without the service lock
+func cancelExpiry(token *ScheduleToken, ticketID string) {
+    if token == nil {
+        return
+    }
+    if err := token.Cancel(); err != nil && !errors.Is(err, ErrScheduleNotFound) {
+        log.Printf("Failed to cancel expiry of ticket %s: %v", ticketID, err)
+    }
+}
+
+// NotificationService notifies customers about concerts and their tickets
+// through a Notifier, on the channels and in the language each customer
+// prefers
 type NotificationService struct {
-    eventBus *EventBus
+    eventBus      *EventBus
+    notifier      *Notifier
+    subscriptions []*Subscription
+
+    // concerts and holders are learned from events, so that messages can
+    // name the concert and reminders reach everyone holding a ticket;
+    // holders maps a concert ID to its ticket holders' emails and names
+    concerts map[string]Concert
+    holders  map[string]map[string]string
+
+    // emailWebhook, when set, is the email platform's webhook that ticket
+    // confirmations are pushed to instead of being emailed from here
+    emailWebhook *Webhook
+    mu           sync.RWMutex
 }
 
-// NewNotificationService creates a new NotificationService
-func NewNotificationService(eb *EventBus) *NotificationService {
-    ns := &NotificationService{eventBus: eb}
-    eb.Subscribe("ConcertAdded", ns.handleConcertAdded)
-    eb.Subscribe("TicketPurchased", ns.handleTicketPurchased)
-    return ns
+// NotificationData is what notification templates are executed with
+type NotificationData struct {
+    Customer Preferences
+    Concert  Concert
+    Ticket   Ticket
 }
 
-func (ns *NotificationService) handleConcertAdded(ctx context.Context, event Event) error {
-    concert := event.Payload.(*Concert)
-    log.Printf("New concert added: %s on %s at %s", concert.Name, concert.Date, concert.Venue)
-    // Here you would implement actual notification logic (e.g., sending emails)
+// NewNotificationService creates a new NotificationService sending through
+// notifier, whose messages then follow the bus clock
+func NewNotificationService(eb *EventBus, notifier *Notifier) (*NotificationService, error) {
+    notifier.UseClock(eb.clock)
+    ns := &NotificationService{
+        eventBus: eb,
+        notifier: notifier,
+        concerts: make(map[string]Concert),
+        holders:  make(map[string]map[string]string),
+    }
+    concertSub, err := Subscribe(eb, ns.handleConcertAdded, WithName("NotificationService"))
+    if err != nil {
+        return nil, err
+    }
+    ticketSub, err := Subscribe(eb, ns.handleTicketPurchased, WithName("NotificationService"))
+    if err != nil {
+        concertSub.Unsubscribe()
+        return nil, err
+    }
+    reminderSub, err := Subscribe(eb, ns.handleConcertReminder, WithName("NotificationService"))
+    if err != nil {
+        concertSub.Unsubscribe()
+        ticketSub.Unsubscribe()
+        return nil, err
+    }
+    ns.subscriptions = []*Subscription{concertSub, ticketSub, reminderSub}
+    return ns, nil
+}
+
+// Close detaches the service from the event bus
+func (ns *NotificationService) Close() {
+    for _, sub := range ns.subscriptions {
+        sub.Unsubscribe()
+    }
+}
+
+// Notifier returns the notifier the service sends through
+func (ns *NotificationService) Notifier() *Notifier {
+    return ns.notifier
+}
+
+// handleConcertAdded announces the concert to every customer who has
+// stored notification preferences and not muted announcements
+func (ns *NotificationService) handleConcertAdded(ctx context.Context, event ConcertAdded) error {
+    concert := event.Concert
+    ns.mu.Lock()
+    ns.concerts[concert.ID] = concert
+    ns.mu.Unlock()
+
+    customers, err := ns.notifier.Preferences().List()
+    if err != nil {
+        return err
+    }
+    var errs []error
+    for _, prefs := range customers {
+        errs = append(errs, ns.notify(ctx, prefs, NotificationData{Customer: prefs, Concert: concert}))
+    }
+    return errors.Join(errs...)
+}
+
+// UseEmailWebhook pushes ticket confirmations to the email platform at url
+// through webhooks, signed with secret, and stops emailing them from here;
+// customers' other channels still receive them
+func (ns *NotificationService) UseEmailWebhook(webhooks *WebhookDispatcher, url, secret string) error {
+    webhook, err := webhooks.Register(EventTypeOf[TicketPurchased](), url, secret)
+    if err != nil {
+        return err
+    }
+    ns.mu.Lock()
+    defer ns.mu.Unlock()
+    ns.emailWebhook = &webhook
     return nil
 }
 
-func (ns *NotificationService) handleTicketPurchased(ctx context.Context, event Event) error {
-    ticket := event.Payload.(*Ticket)
-    log.Printf("Ticket purchased: Concert ID %s for %s (%s)", ticket.ConcertID, ticket.CustomerName, ticket.CustomerEmail)
-    // Here you would implement actual notification logic (e.g., sending confirmation emails)
+func (ns *NotificationService) handleTicketPurchased(ctx context.Context, event TicketPurchased) error {
+    ticket := event.Ticket
+    ns.mu.Lock()
+    if ns.holders[ticket.ConcertID] == nil {
+        ns.holders[ticket.ConcertID] = make(map[string]string)
+    }
+    ns.holders[ticket.ConcertID][ticket.CustomerEmail] = ticket.CustomerName
+    concert, ok := ns.concerts[ticket.ConcertID]
+    viaWebhook := ns.emailWebhook != nil
+    ns.mu.Unlock()
+    if !ok {
+        concert = Concert{ID: ticket.ConcertID}
+    }
+
+    prefs, err := ns.notifier.PreferencesFor(ticket.CustomerEmail, ticket.CustomerName, ticket.CustomerEmail)
+    if err != nil {
+        return err
+    }
+    var skip []string
+    if viaWebhook {
+        // The email platform receives the event itself
+        skip = append(skip, ChannelEmail)
+    }
+    return ns.notify(ctx, prefs, NotificationData{Customer: prefs, Concert: concert, Ticket: ticket}, skip...)
+}
+
+// handleConcertReminder reminds everyone holding a ticket for the concert
+func (ns *NotificationService) handleConcertReminder(ctx context.Context, event ConcertReminder) error {
+    ns.mu.RLock()
+    concert, ok := ns.concerts[event.ConcertID]
+    holders := make(map[string]string, len(ns.holders[event.ConcertID]))
+    for email, name := range ns.holders[event.ConcertID] {
+        holders[email] = name
+    }
+    ns.mu.RUnlock()
+    if !ok {
+        concert = Concert{ID: event.ConcertID, Name: event.Name, Date: event.Date}
+    }
+
+    var errs []error
+    for email, name := range holders {
+        prefs, err := ns.notifier.PreferencesFor(email, name, email)
+        if err != nil {
+            errs = append(errs, err)
+            continue
+        }
+        errs = append(errs, ns.notify(ctx, prefs, NotificationData{Customer: prefs, Concert: concert}))
+    }
+    return errors.Join(errs...)
+}
+
+// notify sends the notification for the event being handled in ctx and
+// publishes NotificationSent for each message that went out
+func (ns *NotificationService) notify(ctx context.Context, prefs Preferences, data NotificationData, skip ...string) error {
+    event, _ := EventFromContext(ctx)
+    sent, err := ns.notifier.Notify(ctx, prefs, event, data, skip...)
+    for _, message := range sent {
+        log.Printf("Notified %s by %s: %s", message.CustomerID, message.Channel, message.Subject)
+        if pubErr := Publish(ctx, ns.eventBus, NotificationSent{Recipient: message.To, Message: message.Body}); pubErr != nil {
+            err = errors.Join(err, pubErr)
+        }
+    }
+    return err
+}
+
+// AuditService records every ticket-related event
+type AuditService struct {
+    subscription *Subscription
+}
+
+// NewAuditService creates a new AuditService listening to all "ticket." topics
+func NewAuditService(eb *EventBus) (*AuditService, error) {
+    as := &AuditService{}
+    sub, err := eb.Subscribe("ticket.#", as.handleTicketEvent, WithName("AuditService"))
+    if err != nil {
+        return nil, err
+    }
+    as.subscription = sub
+    return as, nil
+}
+
+func (as *AuditService) handleTicketEvent(ctx context.Context, event Event) error {
+    log.Printf("Audit: %s %s at %s", event.Type, event.ID, event.Timestamp.Format(time.RFC3339))
     return nil
 }
 
+// Close detaches the service from the event bus
+func (as *AuditService) Close() {
+    as.subscription.Unsubscribe()
+}
+
 // Simulated database operation
 func simulateDBOperation() {
     time.Sleep(time.Millisecond * 100)
 }
 
 func main() {
+    role := flag.String("role", "all", "run every service in this process (all), one part of a distributed setup (hub, concerts, tickets or notifications), or check payload schemas (schemas)")
+    hubAddr := flag.String("hub", "127.0.0.1:7070", "address of the event hub for the distributed roles")
+    concertID := flag.String("concert", "", "concert to buy a ticket for with -role tickets")
+    journalPath := flag.String("journal", "", "append events to this journal file and restore state from it on start")
+    schedulePath := flag.String("schedule", "", "keep scheduled events in this file so they survive a restart")
+    schemaLock := flag.String("schema-lock", "schemas.lock.json", "payload schema lock file checked by -role schemas")
+    updateSchemas := flag.Bool("update-schemas", false, "with -role schemas, record new event types and version bumps in the lock file")
+    emailWebhook := flag.String("email-webhook", "", "push ticket confirmations to the email platform at this URL, signed with $EMAIL_WEBHOOK_SECRET")
+    smtpAddr := flag.String("smtp", "", "send notification emails through the SMTP server at this address, authenticating as $SMTP_USERNAME with $SMTP_PASSWORD if set; without it emails are only logged")
+    smtpFrom := flag.String("smtp-from", "tickets@example.com", "sender address of notification emails")
+    httpAddr := flag.String("http", "", "with -role all, serve the HTTP API and admin endpoints on this address and keep running until interrupted")
+    flag.Parse()
+
+    switch *role {
+    case "all":
+        runAll(*journalPath, *schedulePath, *emailWebhook, *smtpAddr, *smtpFrom, *httpAddr)
+    case "hub":
+        runHub(*hubAddr)
+    case "schemas":
+        runSchemas(*schemaLock, *updateSchemas)
+    case "concerts":
+        runConcerts(*hubAddr, *journalPath, *schedulePath)
+    case "tickets":
+        runTickets(*hubAddr, *concertID, *schedulePath)
+    case "notifications":
+        runNotifications(*hubAddr, *emailWebhook, *smtpAddr, *smtpFrom)
+    default:
+        log.Fatalf("Unknown role %q", *role)
+    }
+}
+
+// runAll runs every service on one bus and walks through a purchase. With
+// httpAddr it then keeps serving the HTTP API until interrupted.
+func runAll(journalPath, schedulePath, emailWebhook, smtpAddr, smtpFrom, httpAddr string) {
     ctx := context.Background()
-    eventBus := NewEventBus()
+    metrics := NewMetrics()
+    eventBus, closeJournal := newEventBus(journalPath, schedulePath,
+        WithMiddleware(Logging(slog.Default()), metrics.Middleware(), Timeout(5*time.Second)),
+        WithHistory(100),
+    )
+    defer closeJournal()
+
+    concertService, err := NewConcertService(eventBus)
+    if err != nil {
+        log.Fatalf("Failed to start concert service: %v", err)
+    }
+    if journalPath != "" {
+        n, err := concertService.Restore(ctx)
+        if err != nil {
+            log.Fatalf("Failed to restore concerts from journal: %v", err)
+        }
+        log.Printf("Restored %d concert(s) from %s", n, journalPath)
+    }
+    ticketService, err := NewTicketService(eventBus)
+    if err != nil {
+        log.Fatalf("Failed to start ticket service: %v", err)
+    }
+    notifier := newNotifier(smtpAddr, smtpFrom)
+    notificationService, err := NewNotificationService(eventBus, notifier)
+    if err != nil {
+        log.Fatalf("Failed to start notification service: %v", err)
+    }
+    defer notificationService.Close()
+    webhooks := useEmailWebhook(eventBus, notificationService, emailWebhook)
+    auditService, err := NewAuditService(eventBus)
+    if err != nil {
+        log.Fatalf("Failed to start audit service: %v", err)
+    }
+    defer auditService.Close()
 
-    concertService := NewConcertService(eventBus)
-    ticketService := NewTicketService(eventBus)
-    NewNotificationService(eventBus)
+    // Every subscriber is registered, so events left over from the last run
+    // can be published
+    resumeScheduled(eventBus)
+
+    var server *http.Server
+    if httpAddr != "" {
+        server = serveAPI(httpAddr, NewAPI(eventBus, concertService, ticketService, notifier, metrics, webhooks))
+    }
+
+    // John reads German and wants text messages too; Jane keeps the defaults
+    err = notifier.Preferences().Set(Preferences{
+        CustomerID: "john@example.com",
+        Name:       "John Doe",
+        Email:      "john@example.com",
+        Phone:      "+4915112345678",
+        Locale:     "de-DE",
+        Channels:   []string{ChannelEmail, ChannelSMS, ChannelInApp},
+    })
+    if err != nil {
+        log.Fatalf("Failed to store notification preferences: %v", err)
+    }
 
     // Add a concert
     concert := &Concert{
@@ -201,20 +661,97 @@ func main() {
     }
     simulateDBOperation()
 
-    // Purchase a ticket
+    // Purchase a ticket as part of the concert's flow, so that tracing the
+    // concert shows the purchase and the notifications it caused
+    var added Event
+    for _, event := range eventBus.RecentEvents() {
+        if payload, ok := event.Payload.(ConcertAdded); ok && payload.Concert.ID == concert.ID {
+            added = event
+        }
+    }
     ticket := &Ticket{
         ConcertID:    concert.ID,
         CustomerName: "John Doe",
         CustomerEmail: "john@example.com",
     }
-    if err := ticketService.PurchaseTicket(ctx, ticket); err != nil {
+    if err := ticketService.PurchaseTicket(ContextWithEvent(ctx, added), ticket); err != nil {
         log.Fatalf("Failed to purchase ticket: %v", err)
     }
     simulateDBOperation()
 
-    // Wait for a moment to allow events to be processed
-    time.Sleep(time.Second)
+    // Ask for a ticket to a concert that does not exist; it will be rejected
+    unknown := &Ticket{
+        ConcertID:    "no-such-concert",
+        CustomerName: "Jane Roe",
+        CustomerEmail: "jane@example.com",
+    }
+    if err := ticketService.PurchaseTicket(ctx, unknown); err != nil {
+        log.Fatalf("Failed to purchase ticket: %v", err)
+    }
+
+    // Wait for every handler triggered so far, including follow-up events
+    if err := eventBus.Drain(ctx); err != nil {
+        log.Fatalf("Failed to drain event bus: %v", err)
+    }
+
+    if inbox, ok := notifier.Inbox(); ok {
+        log.Printf("%s has %d unread in-app message(s)", ticket.CustomerEmail, inbox.Unread(ticket.CustomerEmail))
+    }
+
+    for _, d := range webhooks.Deliveries("") {
+        log.Printf("Webhook delivery of %s %s, attempt %d: status %d %s", d.EventType, d.EventID, d.Attempt, d.StatusCode, d.Error)
+    }
+
+    if letters, err := eventBus.DeadLetters(); err == nil && len(letters) > 0 {
+        log.Printf("%d event(s) were dead-lettered", len(letters))
+    }
+
+    if scheduled, err := eventBus.Scheduled(); err == nil {
+        for _, s := range scheduled {
+            log.Printf("Scheduled %s for %s", s.Event.Type, s.At.Format(time.RFC3339))
+        }
+    }
+
+    for _, eventType := range metrics.EventTypes() {
+        m := metrics.Snapshot()[eventType]
+        log.Printf("Metrics %s: %d handled, %d failed, avg %s", eventType, m.Count, m.Errors, m.AverageDuration())
+    }
+
+    // Trace the concert's flow: concert added, ticket requested, seat
+    // reserved, ticket purchased and the notifications sent
+    chain, err := eventBus.CausalChain(ctx, added.ID)
+    if err != nil {
+        log.Fatalf("Failed to trace event %s: %v", added.ID, err)
+    }
+    for _, step := range chain {
+        log.Printf("Flow %s: %s (%s) caused by %q", step.CorrelationID(), step.Type, step.ID, step.CausationID())
+    }
+
+    for _, t := range []*Ticket{ticket, unknown} {
+        status, reason, _ := ticketService.Status(t.ID)
+        if reason != "" {
+            log.Printf("Ticket %s for %s is %s: %s", t.ID, t.CustomerName, status, reason)
+        } else {
+            log.Printf("Ticket %s for %s is %s", t.ID, t.CustomerName, status)
+        }
+    }
 
     // Print final state
-    fmt.Printf("Concert %s has %d tickets remaining\n", concert.ID, concertService.concerts[concert.ID].AvailableTickets)
+    seats, err := Request[SeatsQuery, SeatsAvailable](ctx, eventBus, SeatsQuery{ConcertID: concert.ID})
+    if err != nil {
+        log.Fatalf("Failed to query seats: %v", err)
+    }
+    fmt.Printf("Concert %s has %d tickets remaining\n", concert.ID, seats.Available)
+
+    if server != nil {
+        untilInterrupted()
+        shutdownCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+        defer cancel()
+        if err := server.Shutdown(shutdownCtx); err != nil {
+            log.Printf("Failed to shut down HTTP server: %v", err)
+        }
+    }
+    if err := eventBus.Close(ctx); err != nil {
+        log.Printf("Failed to close event bus: %v", err)
+    }
 }
\ No newline at end of file
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAddConcertPublishesConcertAdded(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	eb, rec := NewRecordingBus(t, WithClock(clock))
+	cs, err := NewConcertService(eb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	concert := &Concert{Name: "Rock Concert", Venue: "Stadium", Date: clock.Now().AddDate(0, 1, 0), AvailableTickets: 100, TicketPrice: 50}
+	if err := cs.AddConcert(context.Background(), concert); err != nil {
+		t.Fatal(err)
+	}
+
+	added := ExpectPayload(t, rec, func(e ConcertAdded) bool { return e.Concert.ID == concert.ID })
+	if added.Concert.Name != concert.Name || added.Concert.AvailableTickets != 100 {
+		t.Fatalf("want %+v, got %+v", *concert, added.Concert)
+	}
+	event := rec.ExpectPublished(t, "concert.added", nil)
+	if !event.Timestamp.Equal(clock.Now()) {
+		t.Fatalf("want event stamped %s by the bus clock, got %s", clock.Now(), event.Timestamp)
+	}
+}
+
+// TestConcurrentPurchasesDoNotOversell runs many purchases against one
+// concert at once, with readers alongside; run it with -race
+func TestConcurrentPurchasesDoNotOversell(t *testing.T) {
+	const seats, buyers = 10, 50
+	eb, rec := NewRecordingBus(t)
+	cs, err := NewConcertService(eb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts, err := NewTicketService(eb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	concert := &Concert{Name: "Jazz Night", Venue: "Club", Date: time.Now().AddDate(0, 1, 0), AvailableTickets: seats, TicketPrice: 30}
+	if err := cs.AddConcert(ctx, concert); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < buyers; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			ticket := &Ticket{ConcertID: concert.ID, CustomerName: "Buyer", CustomerEmail: "buyer@example.com"}
+			if err := ts.PurchaseTicket(ctx, ticket); err != nil {
+				t.Error(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			cs.Concerts()
+			ts.Tickets()
+		}()
+	}
+	wg.Wait()
+	drainCtx, cancel := context.WithTimeout(ctx, awaitTimeout)
+	defer cancel()
+	if err := eb.Drain(drainCtx); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, _ := cs.Concert(concert.ID); got.AvailableTickets != 0 {
+		t.Fatalf("want the concert sold out, got %d seats left", got.AvailableTickets)
+	}
+	rec.ExpectCount(t, EventTypeOf[TicketRequested](), buyers)
+	rec.ExpectCount(t, EventTypeOf[SeatReserved](), seats)
+	rec.ExpectCount(t, EventTypeOf[TicketRejected](), buyers-seats)
+	confirmed := 0
+	for _, ticket := range ts.Tickets() {
+		if ticket.Status == TicketConfirmed {
+			confirmed++
+		}
+	}
+	if confirmed != seats {
+		t.Fatalf("want %d confirmed tickets, got %d", seats, confirmed)
+	}
+}
+
+// TestExpiredReservationReleasesSeat lets a ticket's reservation window end
+// while ConcertService is still reserving its seat, so the seat is reserved
+// for a ticket that has already been voided and must be given back
+func TestExpiredReservationReleasesSeat(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), awaitTimeout)
+	defer cancel()
+	journal, err := OpenJournal(filepath.Join(t.TempDir(), "events.journal"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+
+	clock := NewFakeClock(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	eb, rec := NewRecordingBus(t, WithClock(clock), WithJournal(journal))
+	if err := registerPayloads(eb); err != nil {
+		t.Fatal(err)
+	}
+	cs, err := NewConcertService(eb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts, err := NewTicketService(eb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	concert := &Concert{Name: "Jazz Night", Date: clock.Now().AddDate(0, 1, 0), AvailableTickets: 1}
+	if err := cs.AddConcert(ctx, concert); err != nil {
+		t.Fatal(err)
+	}
+
+	// Holding the service lock keeps the seat from being reserved until
+	// the ticket has expired
+	cs.mu.Lock()
+	ticket := &Ticket{ConcertID: concert.ID, CustomerName: "Buyer", CustomerEmail: "buyer@example.com"}
+	if err := ts.PurchaseTicket(ctx, ticket); err != nil {
+		cs.mu.Unlock()
+		t.Fatal(err)
+	}
+	clock.Advance(reservationWindow)
+	AwaitPayload(t, rec, func(e ReservationExpired) bool { return e.TicketID == ticket.ID })
+	for {
+		if status, _, _ := ts.Status(ticket.ID); status == TicketVoided {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			cs.mu.Unlock()
+			t.Fatal("ticket was never voided")
+		case <-time.After(time.Millisecond):
+		}
+	}
+	cs.mu.Unlock()
+
+	AwaitPayload(t, rec, func(e SeatReleased) bool { return e.TicketID == ticket.ID })
+	if err := eb.Drain(ctx); err != nil {
+		t.Fatal(err)
+	}
+	rec.ExpectNotPublished(t, EventTypeOf[TicketPurchased](), nil)
+	if got, _ := cs.Concert(concert.ID); got.AvailableTickets != 1 {
+		t.Fatalf("want the seat given back, got %d seats left", got.AvailableTickets)
+	}
+
+	// A restored service replays the release after the reservation
+	restoredBus, _ := NewRecordingBus(t, WithJournal(journal))
+	restored, err := NewConcertService(restoredBus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := restored.Restore(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := restored.Concert(concert.ID); got.AvailableTickets != 1 {
+		t.Fatalf("want 1 seat after restoring, got %d", got.AvailableTickets)
+	}
+}
+
+func TestCloseDeliversFollowUpEvents(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), awaitTimeout)
+	defer cancel()
+	eb, rec := NewRecordingBus(t)
+	cs, err := NewConcertService(eb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	concert := &Concert{Name: "Jazz Night", Date: time.Now().AddDate(0, 1, 0), AvailableTickets: 1}
+	if err := cs.AddConcert(ctx, concert); err != nil {
+		t.Fatal(err)
+	}
+
+	// The reservation is held back until the bus is closing
+	cs.mu.Lock()
+	if err := Publish(ctx, eb, TicketRequested{Ticket: Ticket{ID: "t1", ConcertID: concert.ID}}); err != nil {
+		cs.mu.Unlock()
+		t.Fatal(err)
+	}
+	closed := make(chan error, 1)
+	go func() { closed <- eb.Close(ctx) }()
+	for !errors.Is(Publish(ctx, eb, TicketRequested{Ticket: Ticket{ID: "t2", ConcertID: concert.ID}}), ErrBusClosed) {
+		time.Sleep(time.Millisecond)
+	}
+	cs.mu.Unlock()
+
+	if err := <-closed; err != nil {
+		t.Fatal(err)
+	}
+	rec.ExpectPublished(t, EventTypeOf[SeatReserved](), nil)
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrHandlerTimeout is returned when a handler exceeds the Timeout middleware
+var ErrHandlerTimeout = errors.New("handler timed out")
+
+// Middleware wraps handler invocation. Middlewares registered first run
+// outermost.
+type Middleware func(next EventHandler) EventHandler
+
+// PanicError is returned in place of a handler panic
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("handler panicked: %v", e.Value)
+}
+
+type subscriptionKey struct{}
+
+// SubscriptionFromContext returns the subscription being delivered to, for
+// use by middlewares
+func SubscriptionFromContext(ctx context.Context) (*Subscription, bool) {
+	sub, ok := ctx.Value(subscriptionKey{}).(*Subscription)
+	return sub, ok
+}
+
+// WithMiddleware wraps every handler on the bus with mws
+func WithMiddleware(mws ...Middleware) BusOption {
+	return func(eb *EventBus) {
+		eb.middlewares = append(eb.middlewares, mws...)
+	}
+}
+
+// WithHandlerMiddleware wraps only this subscription's handler with mws,
+// inside the bus-wide middlewares
+func WithHandlerMiddleware(mws ...Middleware) SubscribeOption {
+	return func(s *Subscription) {
+		s.middlewares = append(s.middlewares, mws...)
+	}
+}
+
+// Use appends bus-wide middlewares; they apply to deliveries started after
+// the call
+func (eb *EventBus) Use(mws ...Middleware) {
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+	eb.middlewares = append(append([]Middleware(nil), eb.middlewares...), mws...)
+}
+
+// chain builds the handler for sub wrapped in the bus and subscription
+// middlewares
+func (eb *EventBus) chain(sub *Subscription) EventHandler {
+	eb.mu.RLock()
+	mws := eb.middlewares
+	eb.mu.RUnlock()
+
+	handler := sub.handler
+	for i := len(sub.middlewares) - 1; i >= 0; i-- {
+		handler = sub.middlewares[i](handler)
+	}
+	for i := len(mws) - 1; i >= 0; i-- {
+		handler = mws[i](handler)
+	}
+	return handler
+}
+
+// Recover turns a handler panic into a *PanicError
+func Recover() Middleware {
+	return func(next EventHandler) EventHandler {
+		return func(ctx context.Context, event Event) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = &PanicError{Value: r, Stack: debug.Stack()}
+				}
+			}()
+			return next(ctx, event)
+		}
+	}
+}
+
+// Logging records each handler invocation on logger: successes at debug
+// level and failures at error level
+func Logging(logger *slog.Logger) Middleware {
+	return func(next EventHandler) EventHandler {
+		return func(ctx context.Context, event Event) error {
+			start := time.Now()
+			err := next(ctx, event)
+			attrs := []any{
+				slog.String("event_id", event.ID),
+				slog.String("event_type", event.Type),
+				slog.Duration("duration", time.Since(start)),
+			}
+			if sub, ok := SubscriptionFromContext(ctx); ok {
+				attrs = append(attrs, slog.String("subscription", sub.Name()))
+			}
+			if err != nil {
+				logger.ErrorContext(ctx, "event handler failed", append(attrs, slog.Any("error", err))...)
+			} else {
+				logger.DebugContext(ctx, "event handled", attrs...)
+			}
+			return err
+		}
+	}
+}
+
+// Timeout fails a handler that runs longer than d by cancelling its
+// context. It still waits for the handler to return, so Drain and Close
+// cover it and the next delivery with the same partition key cannot start
+// early. A handler that ignores its context is only waited for, and one that
+// finishes its work despite the deadline succeeds.
+func Timeout(d time.Duration) Middleware {
+	return func(next EventHandler) EventHandler {
+		return func(ctx context.Context, event Event) error {
+			handlerCtx, cancel := context.WithTimeout(ctx, d)
+			defer cancel()
+
+			err := next(handlerCtx, event)
+			if err != nil && ctx.Err() == nil && errors.Is(handlerCtx.Err(), context.DeadlineExceeded) {
+				return fmt.Errorf("%w after %s: %w", ErrHandlerTimeout, d, err)
+			}
+			return err
+		}
+	}
+}
+
+// TypeMetrics summarises handler invocations for one event type
+type TypeMetrics struct {
+	Count         uint64
+	Errors        uint64
+	TotalDuration time.Duration
+	MaxDuration   time.Duration
+}
+
+// AverageDuration is the mean handler latency
+func (m TypeMetrics) AverageDuration() time.Duration {
+	if m.Count == 0 {
+		return 0
+	}
+	return m.TotalDuration / time.Duration(m.Count)
+}
+
+// Metrics collects per-event-type handler latency and error counts
+type Metrics struct {
+	byType map[string]*TypeMetrics
+	mu     sync.Mutex
+}
+
+// NewMetrics creates an empty Metrics collector
+func NewMetrics() *Metrics {
+	return &Metrics{byType: make(map[string]*TypeMetrics)}
+}
+
+// Middleware records every handler invocation in m
+func (m *Metrics) Middleware() Middleware {
+	return func(next EventHandler) EventHandler {
+		return func(ctx context.Context, event Event) error {
+			start := time.Now()
+			err := next(ctx, event)
+			elapsed := time.Since(start)
+
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			tm, ok := m.byType[event.Type]
+			if !ok {
+				tm = &TypeMetrics{}
+				m.byType[event.Type] = tm
+			}
+			tm.Count++
+			tm.TotalDuration += elapsed
+			if elapsed > tm.MaxDuration {
+				tm.MaxDuration = elapsed
+			}
+			if err != nil {
+				tm.Errors++
+			}
+			return err
+		}
+	}
+}
+
+// Snapshot returns a copy of the metrics keyed by event type
+func (m *Metrics) Snapshot() map[string]TypeMetrics {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	snapshot := make(map[string]TypeMetrics, len(m.byType))
+	for eventType, tm := range m.byType {
+		snapshot[eventType] = *tm
+	}
+	return snapshot
+}
+
+// EventTypes lists the event types seen so far in sorted order
+func (m *Metrics) EventTypes() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	types := make([]string, 0, len(m.byType))
+	for eventType := range m.byType {
+		types = append(types, eventType)
+	}
+	sort.Strings(types)
+	return types
+}
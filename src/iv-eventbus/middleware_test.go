@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTimeoutCancelsHandler(t *testing.T) {
+	handler := Timeout(time.Millisecond)(func(ctx context.Context, event Event) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	err := handler(context.Background(), Event{ID: "e1"})
+	if !errors.Is(err, ErrHandlerTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("want ErrHandlerTimeout wrapping the handler error, got %v", err)
+	}
+}
+
+func TestTimeoutWaitsForHandlerAndKeepsKeyOrder(t *testing.T) {
+	var mu sync.Mutex
+	var steps []string
+	step := func(s string) {
+		mu.Lock()
+		defer mu.Unlock()
+		steps = append(steps, s)
+	}
+
+	eb, _ := NewRecordingBus(t,
+		WithMiddleware(Timeout(time.Millisecond)),
+		WithWorkerPool(PoolConfig{Partitions: 4, QueueSize: 8, PartitionKey: func(Event) string { return "same" }}),
+	)
+	_, err := eb.Subscribe("concert.*", func(ctx context.Context, event Event) error {
+		added := event.Payload.(ConcertAdded)
+		step(added.Concert.ID + " start")
+		// Keep working well past the deadline, ignoring the context
+		time.Sleep(50 * time.Millisecond)
+		step(added.Concert.ID + " end")
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), awaitTimeout)
+	defer cancel()
+	for _, id := range []string{"c1", "c2"} {
+		if err := Publish(ctx, eb, ConcertAdded{Concert: Concert{ID: id}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := eb.Drain(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"c1 start", "c1 end", "c2 start", "c2 end"}
+	if len(steps) != len(want) {
+		t.Fatalf("want Drain to wait for both handlers, got %v", steps)
+	}
+	for i := range want {
+		if steps[i] != want[i] {
+			t.Fatalf("want %v, got %v", want, steps)
+		}
+	}
+}
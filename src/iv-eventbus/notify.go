@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Names of the built-in notification channels
+const (
+	ChannelEmail = "email"
+	ChannelSMS   = "sms"
+	ChannelInApp = "inapp"
+)
+
+// DefaultChannels are used for customers without stored preferences
+var DefaultChannels = []string{ChannelEmail, ChannelInApp}
+
+// ErrPreferencesNotFound is returned for a customer without stored
+// preferences
+var ErrPreferencesNotFound = errors.New("notification preferences not found")
+
+// Message is a rendered notification addressed to one customer on one
+// channel
+type Message struct {
+	ID         string    `json:"id"`
+	CustomerID string    `json:"customer_id"`
+	Channel    string    `json:"channel"`
+	To         string    `json:"to"`
+	Subject    string    `json:"subject"`
+	Body       string    `json:"body"`
+	Locale     string    `json:"locale"`
+	EventID    string    `json:"event_id"`
+	EventType  string    `json:"event_type"`
+	At         time.Time `json:"at"`
+}
+
+// Channel delivers messages to customers, such as by email or SMS
+type Channel interface {
+	// Name is the name customers enable the channel by in their preferences
+	Name() string
+	// Address is where the customer is reached on this channel, or "" if
+	// they cannot be
+	Address(Preferences) string
+	Send(ctx context.Context, message Message) error
+}
+
+// Preferences are one customer's notification settings. Customers are
+// identified by their email address, as tickets are.
+type Preferences struct {
+	CustomerID string   `json:"customer_id"`
+	Name       string   `json:"name"`
+	Email      string   `json:"email"`
+	Phone      string   `json:"phone,omitempty"`
+	Locale     string   `json:"locale"`
+	Channels   []string `json:"channels"`
+	// Muted lists the event types the customer does not want to hear about
+	Muted []string `json:"muted,omitempty"`
+}
+
+// Wants reports whether the customer wants notifications for eventType
+func (p Preferences) Wants(eventType string) bool {
+	return !slices.Contains(p.Muted, eventType)
+}
+
+// PreferenceStore keeps customers' notification preferences
+type PreferenceStore interface {
+	Get(customerID string) (Preferences, error)
+	Set(Preferences) error
+	List() ([]Preferences, error)
+}
+
+// MemoryPreferenceStore is an in-process PreferenceStore
+type MemoryPreferenceStore struct {
+	mu    sync.RWMutex
+	prefs map[string]Preferences
+}
+
+// NewMemoryPreferenceStore creates an empty MemoryPreferenceStore
+func NewMemoryPreferenceStore() *MemoryPreferenceStore {
+	return &MemoryPreferenceStore{prefs: make(map[string]Preferences)}
+}
+
+// Get implements PreferenceStore
+func (s *MemoryPreferenceStore) Get(customerID string) (Preferences, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	prefs, ok := s.prefs[customerID]
+	if !ok {
+		return Preferences{}, fmt.Errorf("%w: %s", ErrPreferencesNotFound, customerID)
+	}
+	return clonePreferences(prefs), nil
+}
+
+// Set implements PreferenceStore
+func (s *MemoryPreferenceStore) Set(prefs Preferences) error {
+	if prefs.CustomerID == "" {
+		return errors.New("preferences need a customer ID")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prefs[prefs.CustomerID] = clonePreferences(prefs)
+	return nil
+}
+
+// List implements PreferenceStore, ordered by customer ID
+func (s *MemoryPreferenceStore) List() ([]Preferences, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]Preferences, 0, len(s.prefs))
+	for _, prefs := range s.prefs {
+		list = append(list, clonePreferences(prefs))
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CustomerID < list[j].CustomerID })
+	return list, nil
+}
+
+func clonePreferences(p Preferences) Preferences {
+	p.Channels = append([]string(nil), p.Channels...)
+	p.Muted = append([]string(nil), p.Muted...)
+	return p
+}
+
+// Notifier renders notifications from templates and sends them on the
+// channels each customer has enabled
+type Notifier struct {
+	templates   *Templates
+	preferences PreferenceStore
+	channels    map[string]Channel
+	clock       Clock
+}
+
+// NewNotifier creates a Notifier sending on channels; a customer's enabled
+// channels that are not among them are ignored
+func NewNotifier(templates *Templates, preferences PreferenceStore, channels ...Channel) *Notifier {
+	n := &Notifier{
+		templates:   templates,
+		preferences: preferences,
+		channels:    make(map[string]Channel),
+		clock:       SystemClock{},
+	}
+	for _, channel := range channels {
+		n.channels[channel.Name()] = channel
+	}
+	return n
+}
+
+// UseClock stamps messages with the time on clock, which also dates the
+// emails sent for them
+func (n *Notifier) UseClock(clock Clock) {
+	n.clock = clock
+}
+
+// Channel returns the channel registered under name
+func (n *Notifier) Channel(name string) (Channel, bool) {
+	channel, ok := n.channels[name]
+	return channel, ok
+}
+
+// Inbox returns the in-app channel if it is an Inbox
+func (n *Notifier) Inbox() (*Inbox, bool) {
+	inbox, ok := n.channels[ChannelInApp].(*Inbox)
+	return inbox, ok
+}
+
+// Preferences returns the store customers' preferences are kept in
+func (n *Notifier) Preferences() PreferenceStore {
+	return n.preferences
+}
+
+// PreferencesFor returns the stored preferences of customerID, filling in
+// a missing name or email, or defaults if none are stored
+func (n *Notifier) PreferencesFor(customerID, name, email string) (Preferences, error) {
+	prefs, err := n.preferences.Get(customerID)
+	if errors.Is(err, ErrPreferencesNotFound) {
+		prefs = Preferences{CustomerID: customerID, Channels: slices.Clone(DefaultChannels)}
+	} else if err != nil {
+		return Preferences{}, err
+	}
+	if prefs.Name == "" {
+		prefs.Name = name
+	}
+	if prefs.Email == "" {
+		prefs.Email = email
+	}
+	return prefs, nil
+}
+
+// Notify renders the template for event's type in the customer's locale
+// with data and sends it on each of the customer's channels except skip.
+// It returns the messages sent; a channel failing does not stop the
+// others, and the failures are returned together.
+func (n *Notifier) Notify(ctx context.Context, prefs Preferences, event Event, data interface{}, skip ...string) ([]Message, error) {
+	if !prefs.Wants(event.Type) {
+		return nil, nil
+	}
+	subject, body, locale, err := n.templates.Render(event.Type, prefs.Locale, data)
+	if err != nil {
+		return nil, err
+	}
+
+	var sent []Message
+	var errs []error
+	for _, name := range prefs.Channels {
+		channel, ok := n.channels[name]
+		if !ok || slices.Contains(skip, name) {
+			continue
+		}
+		to := channel.Address(prefs)
+		if to == "" {
+			continue
+		}
+		message := Message{
+			ID:         uuid.New().String(),
+			CustomerID: prefs.CustomerID,
+			Channel:    name,
+			To:         to,
+			Subject:    subject,
+			Body:       body,
+			Locale:     locale,
+			EventID:    event.ID,
+			EventType:  event.Type,
+			At:         n.clock.Now(),
+		}
+		if err := channel.Send(ctx, message); err != nil {
+			errs = append(errs, fmt.Errorf("%s to %s: %w", name, to, err))
+			continue
+		}
+		sent = append(sent, message)
+	}
+	return sent, errors.Join(errs...)
+}
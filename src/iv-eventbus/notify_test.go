@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingChannel is a Channel that keeps what it is asked to send
+type recordingChannel struct {
+	name    string
+	address func(Preferences) string
+
+	mu   sync.Mutex
+	sent []Message
+}
+
+func (c *recordingChannel) Name() string { return c.name }
+
+func (c *recordingChannel) Address(prefs Preferences) string { return c.address(prefs) }
+
+func (c *recordingChannel) Send(ctx context.Context, message Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sent = append(c.sent, message)
+	return nil
+}
+
+func (c *recordingChannel) Sent() []Message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.sent)
+}
+
+func TestTemplatesLocaleFallback(t *testing.T) {
+	templates := DefaultTemplates()
+	data := NotificationData{Customer: Preferences{Name: "Ada"}, Concert: Concert{Name: "Jazz Night"}}
+	tests := []struct {
+		locale, wantLocale, wantSubject string
+	}{
+		{"de", "de", "Neues Konzert: Jazz Night"},
+		{"de-AT", "de", "Neues Konzert: Jazz Night"},
+		{"DE_ch", "de", "Neues Konzert: Jazz Night"},
+		{"en-GB", "en", "New concert: Jazz Night"},
+		{"fr", "en", "New concert: Jazz Night"},
+		{"", "en", "New concert: Jazz Night"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.locale, func(t *testing.T) {
+			subject, _, used, err := templates.Render(EventTypeOf[ConcertAdded](), tt.locale, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if used != tt.wantLocale || subject != tt.wantSubject {
+				t.Fatalf("want %q in %s, got %q in %s", tt.wantSubject, tt.wantLocale, subject, used)
+			}
+		})
+	}
+
+	if _, _, _, err := templates.Render("no.such.event", "en", data); !errors.Is(err, ErrNoTemplate) {
+		t.Fatalf("want ErrNoTemplate, got %v", err)
+	}
+}
+
+func TestNotifierFollowsPreferences(t *testing.T) {
+	email := &recordingChannel{name: ChannelEmail, address: func(p Preferences) string { return p.Email }}
+	sms := &recordingChannel{name: ChannelSMS, address: func(p Preferences) string { return p.Phone }}
+	inbox := NewInbox(10)
+	prefs := NewMemoryPreferenceStore()
+	notifier := NewNotifier(DefaultTemplates(), prefs, email, sms, inbox)
+	clock := NewFakeClock(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	notifier.UseClock(clock)
+	if err := prefs.Set(Preferences{
+		CustomerID: "ada@example.com",
+		Phone:      "+4915100000000",
+		Locale:     "de-DE",
+		Channels:   []string{ChannelSMS, ChannelInApp},
+		Muted:      []string{EventTypeOf[ConcertReminder]()},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	concert := Concert{Name: "Jazz Night"}
+
+	ada, err := notifier.PreferencesFor("ada@example.com", "Ada", "ada@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	added := Event{ID: "e1", Type: EventTypeOf[ConcertAdded]()}
+	sent, err := notifier.Notify(ctx, ada, added, NotificationData{Customer: ada, Concert: concert})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sent) != 2 || sent[0].Channel != ChannelSMS || sent[1].Channel != ChannelInApp {
+		t.Fatalf("want sms and inapp messages, got %+v", sent)
+	}
+	if sent[0].Locale != "de" || sent[0].Subject != "Neues Konzert: Jazz Night" || sent[0].To != "+4915100000000" {
+		t.Fatalf("want a German text to Ada's phone, got %+v", sent[0])
+	}
+	if !sent[0].At.Equal(clock.Now()) {
+		t.Fatalf("want the message stamped %s by the notifier clock, got %s", clock.Now(), sent[0].At)
+	}
+	if got := inbox.Unread("ada@example.com"); got != 1 {
+		t.Fatalf("want 1 unread in-app message, got %d", got)
+	}
+
+	reminder := Event{ID: "e2", Type: EventTypeOf[ConcertReminder]()}
+	if sent, err := notifier.Notify(ctx, ada, reminder, NotificationData{Customer: ada, Concert: concert}); err != nil || len(sent) != 0 {
+		t.Fatalf("want the muted reminder dropped, got %+v, %v", sent, err)
+	}
+
+	// A customer without stored preferences gets the default channels in
+	// the fallback locale, minus any skipped channel
+	bob, err := notifier.PreferencesFor("bob@example.com", "Bob", "bob@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent, err = notifier.Notify(ctx, bob, added, NotificationData{Customer: bob, Concert: concert}, ChannelInApp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sent) != 1 || sent[0].Channel != ChannelEmail || sent[0].Locale != "en" {
+		t.Fatalf("want one English email, got %+v", sent)
+	}
+	if got := len(email.Sent()); got != 1 {
+		t.Fatalf("want 1 email, got %d", got)
+	}
+	if got := len(sms.Sent()); got != 1 {
+		t.Fatalf("want 1 text message, got %d", got)
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrDeliveryDropped is reported for a delivery discarded because its
+// partition queue was full under OverflowDrop
+var ErrDeliveryDropped = errors.New("delivery dropped: partition queue full")
+
+// OverflowPolicy decides what publishing does when a partition queue is full
+type OverflowPolicy int
+
+const (
+	// OverflowBlock makes the publisher wait for space, or for its context
+	// to end, which applies backpressure
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDrop discards the delivery and dead-letters the event so it
+	// can be replayed later
+	OverflowDrop
+)
+
+// PartitionKeyer lets a payload choose the key that orders its deliveries
+type PartitionKeyer interface {
+	PartitionKey() string
+}
+
+// PoolConfig configures partitioned delivery. Each subscription's
+// deliveries are spread over Partitions queues by partition key, and each
+// queue is served by a single worker, so events with the same key reach a
+// given handler in publish order while at most Partitions handlers run.
+type PoolConfig struct {
+	Partitions int
+	QueueSize  int
+	Overflow   OverflowPolicy
+	// PartitionKey picks an event's key; by default the payload's
+	// PartitionKey is used, falling back to the event ID (no ordering)
+	PartitionKey func(Event) string
+}
+
+// PoolStats is a snapshot of worker pool activity
+type PoolStats struct {
+	Partitions  int    `json:"partitions"`
+	QueueDepths []int  `json:"queue_depths"`
+	Processed   uint64 `json:"processed"`
+	Dropped     uint64 `json:"dropped"`
+}
+
+// WithWorkerPool delivers events on a bounded, partitioned worker pool
+// instead of a goroutine per handler
+func WithWorkerPool(config PoolConfig) BusOption {
+	return func(eb *EventBus) {
+		eb.pool = newWorkerPool(config)
+	}
+}
+
+type poolWorkerKey struct{}
+
+type workerPool struct {
+	config     PoolConfig
+	partitions []*partition
+	processed  atomic.Uint64
+	dropped    atomic.Uint64
+	wg         sync.WaitGroup
+}
+
+type partition struct {
+	mu     sync.Mutex
+	jobs   []func()
+	closed bool
+	// ready wakes the worker; space is closed and replaced whenever a job
+	// is taken off the queue, waking blocked publishers
+	ready chan struct{}
+	space chan struct{}
+}
+
+func newWorkerPool(config PoolConfig) *workerPool {
+	if config.Partitions < 1 {
+		config.Partitions = 1
+	}
+	if config.QueueSize < 1 {
+		config.QueueSize = 1
+	}
+	if config.PartitionKey == nil {
+		config.PartitionKey = defaultPartitionKey
+	}
+	p := &workerPool{config: config}
+	for i := 0; i < config.Partitions; i++ {
+		part := &partition{ready: make(chan struct{}, 1), space: make(chan struct{})}
+		p.partitions = append(p.partitions, part)
+		p.wg.Add(1)
+		go p.work(part)
+	}
+	return p
+}
+
+func defaultPartitionKey(event Event) string {
+	if keyer, ok := event.Payload.(PartitionKeyer); ok {
+		return keyer.PartitionKey()
+	}
+	return event.ID
+}
+
+// submit queues job on the partition for sub and event. Handlers running
+// on the pool that publish follow-up events are never blocked or dropped,
+// since waiting on their own queue could deadlock the worker.
+func (p *workerPool) submit(ctx context.Context, sub *Subscription, event Event, job func(context.Context)) error {
+	h := fnv.New32a()
+	h.Write([]byte(sub.id))
+	h.Write([]byte{0})
+	h.Write([]byte(p.config.PartitionKey(event)))
+	part := p.partitions[h.Sum32()%uint32(len(p.partitions))]
+	fromWorker := ctx.Value(poolWorkerKey{}) != nil
+	run := func() {
+		job(context.WithValue(ctx, poolWorkerKey{}, true))
+		p.processed.Add(1)
+	}
+
+	for {
+		part.mu.Lock()
+		if len(part.jobs) < p.config.QueueSize || fromWorker {
+			part.jobs = append(part.jobs, run)
+			part.mu.Unlock()
+			select {
+			case part.ready <- struct{}{}:
+			default:
+			}
+			return nil
+		}
+		space := part.space
+		part.mu.Unlock()
+
+		if p.config.Overflow == OverflowDrop {
+			p.dropped.Add(1)
+			return ErrDeliveryDropped
+		}
+		select {
+		case <-space:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (p *workerPool) work(part *partition) {
+	defer p.wg.Done()
+	for {
+		part.mu.Lock()
+		if len(part.jobs) == 0 {
+			closed := part.closed
+			part.mu.Unlock()
+			if closed {
+				return
+			}
+			<-part.ready
+			continue
+		}
+		job := part.jobs[0]
+		part.jobs[0] = nil
+		part.jobs = part.jobs[1:]
+		close(part.space)
+		part.space = make(chan struct{})
+		part.mu.Unlock()
+		job()
+	}
+}
+
+// stop lets workers finish their queues and waits for them to exit
+func (p *workerPool) stop() {
+	for _, part := range p.partitions {
+		part.mu.Lock()
+		part.closed = true
+		part.mu.Unlock()
+		select {
+		case part.ready <- struct{}{}:
+		default:
+		}
+	}
+	p.wg.Wait()
+}
+
+func (p *workerPool) stats() PoolStats {
+	stats := PoolStats{
+		Partitions: len(p.partitions),
+		Processed:  p.processed.Load(),
+		Dropped:    p.dropped.Load(),
+	}
+	for _, part := range p.partitions {
+		part.mu.Lock()
+		stats.QueueDepths = append(stats.QueueDepths, len(part.jobs))
+		part.mu.Unlock()
+	}
+	return stats
+}
+
+// PoolStats reports worker pool activity, or false if the bus delivers
+// with a goroutine per handler
+func (eb *EventBus) PoolStats() (PoolStats, bool) {
+	if eb.pool == nil {
+		return PoolStats{}, false
+	}
+	return eb.pool.stats(), true
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// ErrNoJournal is returned by replay operations on a bus without a journal
+var ErrNoJournal = errors.New("event bus has no journal")
+
+// WithJournal appends every published event to j before it is dispatched,
+// so subscribers can later replay history
+func WithJournal(j *Journal) BusOption {
+	return func(eb *EventBus) {
+		eb.journal = j
+	}
+}
+
+// Replay calls handler, in order, for every journaled event from sequence
+// number from onwards whose type matches pattern. Payloads are upcast to
+// the current schema version and decoded into their registered types;
+// unregistered payloads are left as json.RawMessage.
+func (eb *EventBus) Replay(ctx context.Context, from uint64, pattern string, handler EventHandler) error {
+	if eb.journal == nil {
+		return ErrNoJournal
+	}
+	return eb.replay(ctx, from, 0, pattern, handler)
+}
+
+func (eb *EventBus) replay(ctx context.Context, from, to uint64, pattern string, handler EventHandler) error {
+	if err := validatePattern(pattern); err != nil {
+		return err
+	}
+	return eb.journal.Read(from, to, func(entry JournalEntry) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !topicMatches(pattern, entry.Type) {
+			return nil
+		}
+		event, err := eb.decodeEntry(entry)
+		if err != nil {
+			return err
+		}
+		return handler(ctx, event)
+	})
+}
+
+// CompactJournal removes journaled events for which keep returns false,
+// along with any replies journaled before replies were left out, and
+// returns how many were removed
+func (eb *EventBus) CompactJournal(keep func(JournalEntry) bool) (int, error) {
+	if eb.journal == nil {
+		return 0, ErrNoJournal
+	}
+	return eb.journal.Compact(func(entry JournalEntry) bool {
+		return !strings.HasPrefix(entry.Type, replyTopicPrefix) && keep(entry)
+	})
+}
+
+// SubscribeFrom replays journaled events from sequence number from and then
+// continues with live events, with no gap or overlap between the two. Live
+// events that arrive during the replay wait until it has finished.
+func (eb *EventBus) SubscribeFrom(ctx context.Context, from uint64, pattern string, handler EventHandler, opts ...SubscribeOption) (*Subscription, error) {
+	if eb.journal == nil {
+		return nil, ErrNoJournal
+	}
+
+	caughtUp := make(chan struct{})
+	live := func(ctx context.Context, event Event) error {
+		select {
+		case <-caughtUp:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		return handler(ctx, event)
+	}
+
+	// Publishing appends to the journal under the read lock, so with the
+	// write lock held every event up to upTo has already been dispatched
+	// and every later one will reach the new subscription.
+	eb.mu.Lock()
+	upTo := eb.journal.LastSeq()
+	sub, err := eb.subscribeLocked(pattern, live, opts...)
+	eb.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	if upTo >= from {
+		if err := eb.replay(ctx, from, upTo, pattern, handler); err != nil {
+			sub.Unsubscribe()
+			close(caughtUp)
+			return nil, err
+		}
+	}
+	close(caughtUp)
+	return sub, nil
+}
+
+// decodeEntry rebuilds an Event from a journal entry
+func (eb *EventBus) decodeEntry(entry JournalEntry) (Event, error) {
+	return eb.decodeEvent(Event{
+		ID:        entry.ID,
+		Type:      entry.Type,
+		Payload:   entry.Payload,
+		Timestamp: entry.Timestamp,
+		Headers:   entry.Headers,
+	})
+}
+
+// decodeEvent decodes a payload still held as JSON, as read from a journal,
+// schedule store or transport, into the payload type registered for the
+// event type, upcasting it from the event's schema version first. Payloads
+// of unregistered types are left as json.RawMessage.
+func (eb *EventBus) decodeEvent(event Event) (Event, error) {
+	raw, ok := event.Payload.(json.RawMessage)
+	if !ok {
+		return event, nil
+	}
+	eb.mu.RLock()
+	t, ok := eb.payloadTypes[event.Type]
+	eb.mu.RUnlock()
+	if !ok {
+		return event, nil
+	}
+
+	current := schemaVersionOf(t)
+	raw, err := eb.upcast(event.Type, event.SchemaVersion(), current, raw)
+	if err != nil {
+		return event, err
+	}
+	payload := reflect.New(t)
+	if err := json.Unmarshal(raw, payload.Interface()); err != nil {
+		return event, err
+	}
+	event.Payload = payload.Elem().Interface()
+	return event.withHeader(HeaderSchemaVersion, strconv.Itoa(current)), nil
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Header names used by request/reply
+const (
+	HeaderReplyTo    = "reply-to"
+	HeaderReplyError = "reply-error"
+)
+
+// replyTopicPrefix starts the private event type each request's reply is
+// published under
+const replyTopicPrefix = "reply."
+
+// DefaultRequestTimeout bounds a Request whose context has no deadline
+const DefaultRequestTimeout = 5 * time.Second
+
+var (
+	// ErrNoResponder is returned by Request when no responder is registered
+	// for the event type
+	ErrNoResponder = errors.New("no responder for request")
+
+	// ErrMultipleResponders is returned by Respond when the event type
+	// already has a responder
+	ErrMultipleResponders = errors.New("event type already has a responder")
+
+	// ErrRequestTimeout is returned when no reply arrives in time
+	ErrRequestTimeout = errors.New("request timed out")
+
+	// ErrRequestFailed wraps the error a responder answered with
+	ErrRequestFailed = errors.New("request failed")
+)
+
+// Responder answers a request event with a reply payload
+type Responder func(context.Context, Event) (interface{}, error)
+
+// ReplyTo is the address the reply to the event must be published to, or
+// "" if the event is not a request
+func (e Event) ReplyTo() string {
+	return e.Headers[HeaderReplyTo]
+}
+
+// Respond registers the single responder for eventType. Requests of that
+// type are answered with the payload it returns, or with its error; events
+// of that type published without Request reach it as well, and its answer
+// is discarded. Other subscriptions to eventType keep receiving the events
+// but do not answer.
+func (eb *EventBus) Respond(eventType string, responder Responder, opts ...SubscribeOption) (*Subscription, error) {
+	if isWildcardPattern(eventType) {
+		return nil, fmt.Errorf("responder for %s: requests need an exact event type", eventType)
+	}
+	handler := func(ctx context.Context, event Event) error {
+		payload, err := responder(ctx, event)
+		if event.ReplyTo() == "" {
+			return err
+		}
+		reply := Event{
+			ID:        uuid.New().String(),
+			Type:      event.ReplyTo(),
+			Payload:   payload,
+			Timestamp: eb.Now(),
+		}
+		if err != nil {
+			// The requester gets the error; retrying would answer twice
+			reply.Payload = nil
+			reply = reply.withHeader(HeaderReplyError, err.Error())
+		}
+		return eb.Publish(ctx, reply)
+	}
+
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+	if eb.responderLocked(eventType) != nil {
+		return nil, fmt.Errorf("%w: %s", ErrMultipleResponders, eventType)
+	}
+	sub, err := eb.subscribeLocked(eventType, handler, opts...)
+	if err != nil {
+		return nil, err
+	}
+	sub.responder = true
+	return sub, nil
+}
+
+// responderLocked returns the responder for eventType; eb.mu must be held
+func (eb *EventBus) responderLocked(eventType string) *Subscription {
+	for _, sub := range eb.handlers[eventType] {
+		if sub.responder {
+			return sub
+		}
+	}
+	return nil
+}
+
+// Request publishes event and waits for its responder's reply. The reply
+// is addressed to a topic private to this request and carries the
+// request's correlation ID. It fails with ErrNoResponder if the event type
+// has no responder on this bus and the bus has no transport. Without a
+// deadline on ctx the wait is bounded by DefaultRequestTimeout.
+func (eb *EventBus) Request(ctx context.Context, event Event) (Event, error) {
+	eb.mu.RLock()
+	responder := eb.responderLocked(event.Type)
+	eb.mu.RUnlock()
+	if responder == nil && eb.transport == nil {
+		return Event{}, fmt.Errorf("%w: %s", ErrNoResponder, event.Type)
+	}
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultRequestTimeout)
+		defer cancel()
+	}
+	if event.ID == "" {
+		event.ID = uuid.New().String()
+	}
+	replyTo := replyTopicPrefix + uuid.New().String()
+	replies := make(chan Event, 1)
+	sub, err := eb.Subscribe(replyTo, func(_ context.Context, reply Event) error {
+		select {
+		case replies <- reply:
+		default:
+		}
+		return nil
+	}, WithName("reply to "+event.Type))
+	if err != nil {
+		return Event{}, err
+	}
+	defer sub.Unsubscribe()
+
+	if err := eb.Publish(ctx, event.withHeader(HeaderReplyTo, replyTo)); err != nil {
+		return Event{}, err
+	}
+	select {
+	case reply := <-replies:
+		if msg, ok := reply.Headers[HeaderReplyError]; ok {
+			return reply, fmt.Errorf("%w: %s: %s", ErrRequestFailed, event.Type, msg)
+		}
+		return reply, nil
+	case <-ctx.Done():
+		return Event{}, fmt.Errorf("%w: no reply to %s: %v", ErrRequestTimeout, event.Type, ctx.Err())
+	}
+}
+
+// Request sends req as a new event of the type derived from Req and
+// returns the responder's reply payload
+func Request[Req, Resp any](ctx context.Context, eb *EventBus, req Req) (Resp, error) {
+	var zero Resp
+	if err := RegisterPayload[Req](eb); err != nil {
+		return zero, err
+	}
+	reply, err := eb.Request(ctx, Event{
+		ID:        uuid.New().String(),
+		Type:      EventTypeOf[Req](),
+		Payload:   req,
+		Timestamp: eb.Now(),
+	})
+	if err != nil {
+		return zero, err
+	}
+	// A reply from another process arrives as JSON, since its private
+	// topic has no registered payload type
+	if raw, ok := reply.Payload.(json.RawMessage); ok {
+		var resp Resp
+		if err := json.Unmarshal(raw, &resp); err != nil {
+			return zero, fmt.Errorf("decoding reply to %s: %w", reply.Type, err)
+		}
+		return resp, nil
+	}
+	resp, ok := reply.Payload.(Resp)
+	if !ok {
+		return zero, payloadTypeError(reply.Type, reflect.TypeOf((*Resp)(nil)).Elem(), reply.Payload)
+	}
+	return resp, nil
+}
+
+// Respond registers handler as the single responder for requests of type
+// Req, answering each with a Resp
+func Respond[Req, Resp any](eb *EventBus, handler func(context.Context, Req) (Resp, error), opts ...SubscribeOption) (*Subscription, error) {
+	if err := RegisterPayload[Req](eb); err != nil {
+		return nil, err
+	}
+	return eb.Respond(EventTypeOf[Req](), func(ctx context.Context, event Event) (interface{}, error) {
+		req, ok := event.Payload.(Req)
+		if !ok {
+			return nil, payloadTypeError(event.Type, reflect.TypeOf((*Req)(nil)).Elem(), event.Payload)
+		}
+		return handler(ctx, req)
+	}, opts...)
+}
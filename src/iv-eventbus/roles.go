@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+	"time"
+)
+
+// newEventBus builds the bus shared by every role: a partitioned worker
+// pool plus an optional journal and schedule store. The returned function
+// closes the journal.
+func newEventBus(journalPath, schedulePath string, opts ...BusOption) (*EventBus, func()) {
+	busOptions := []BusOption{
+		WithWorkerPool(PoolConfig{Partitions: 8, QueueSize: 256, Overflow: OverflowBlock}),
+	}
+	closeJournal := func() {}
+	if journalPath != "" {
+		journal, err := OpenJournal(journalPath)
+		if err != nil {
+			log.Fatalf("Failed to open journal: %v", err)
+		}
+		closeJournal = func() { journal.Close() }
+		busOptions = append(busOptions, WithJournal(journal))
+	}
+	if schedulePath != "" {
+		store, err := OpenFileScheduleStore(schedulePath)
+		if err != nil {
+			log.Fatalf("Failed to open schedule store: %v", err)
+		}
+		busOptions = append(busOptions, WithScheduleStore(store))
+	}
+	eventBus := NewEventBus(append(busOptions, opts...)...)
+	if err := registerPayloads(eventBus); err != nil {
+		log.Fatalf("Failed to register payload types: %v", err)
+	}
+	return eventBus, closeJournal
+}
+
+// newRemoteEventBus builds a bus connected to the hub at hubAddr under the
+// role's name
+func newRemoteEventBus(role, hubAddr, journalPath, schedulePath string) (*EventBus, func()) {
+	return newEventBus(journalPath, schedulePath,
+		WithMiddleware(Logging(slog.Default()), Timeout(5*time.Second)),
+		WithTransport(DialTCP(hubAddr, role)),
+	)
+}
+
+// resumeScheduled publishes events scheduled by an earlier run; call it
+// once the role's subscribers are registered. Reservations are held in
+// memory only, so reservation expiries from an earlier run are dropped:
+// no TicketService knows their tickets any more.
+func resumeScheduled(eventBus *EventBus) {
+	expiries, err := eventBus.DiscardScheduled(EventTypeOf[ReservationExpired]())
+	if err != nil {
+		log.Fatalf("Failed to drop stale reservation expiries: %v", err)
+	}
+	for _, expiry := range expiries {
+		log.Printf("Dropped reservation expiry %s due %s from an earlier run", expiry.Event.ID, expiry.At.Format(time.RFC3339))
+	}
+	if n, err := eventBus.ResumeScheduled(); err != nil {
+		log.Fatalf("Failed to resume scheduled events: %v", err)
+	} else if n > 0 {
+		log.Printf("Resumed %d scheduled event(s)", n)
+	}
+}
+
+// newNotifier builds the notifier NotificationService sends through:
+// email via the SMTP server at smtpAddr, text messages via the log and an
+// in-app inbox. Without smtpAddr, emails are only logged. Credentials, if
+// the server needs them, come from $SMTP_USERNAME and $SMTP_PASSWORD.
+func newNotifier(smtpAddr, smtpFrom string) *Notifier {
+	var email Channel = LogEmailChannel{}
+	if smtpAddr != "" {
+		var auth smtp.Auth
+		if username := os.Getenv("SMTP_USERNAME"); username != "" {
+			host, _, err := net.SplitHostPort(smtpAddr)
+			if err != nil {
+				log.Fatalf("Invalid SMTP address %q: %v", smtpAddr, err)
+			}
+			auth = smtp.PlainAuth("", username, os.Getenv("SMTP_PASSWORD"), host)
+		}
+		email = NewSMTPChannel(smtpAddr, smtpFrom, auth)
+	} else {
+		log.Printf("No SMTP server set; emails are only logged")
+	}
+	return NewNotifier(DefaultTemplates(), NewMemoryPreferenceStore(),
+		email,
+		NewSMSChannel(LogSMSProvider{}),
+		NewInbox(100),
+	)
+}
+
+// useEmailWebhook hands ticket confirmations to the email platform's
+// webhook if url is set; the secret comes from $EMAIL_WEBHOOK_SECRET so it
+// stays out of the process list
+func useEmailWebhook(eventBus *EventBus, ns *NotificationService, url string) *WebhookDispatcher {
+	webhooks := NewWebhookDispatcher(eventBus, nil)
+	if url == "" {
+		return webhooks
+	}
+	if err := ns.UseEmailWebhook(webhooks, url, os.Getenv("EMAIL_WEBHOOK_SECRET")); err != nil {
+		log.Fatalf("Failed to register email webhook: %v", err)
+	}
+	log.Printf("Ticket confirmations go to the email platform at %s", url)
+	return webhooks
+}
+
+// untilInterrupted blocks until the process is asked to stop
+func untilInterrupted() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+}
+
+// serveAPI serves handler on addr in the background
+func serveAPI(addr string, handler http.Handler) *http.Server {
+	server := &http.Server{Addr: addr, Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("HTTP server failed: %v", err)
+		}
+	}()
+	log.Printf("Serving the HTTP API on %s", addr)
+	return server
+}
+
+func closeEventBus(eventBus *EventBus) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := eventBus.Close(ctx); err != nil {
+		log.Printf("Failed to close event bus: %v", err)
+	}
+}
+
+// runSchemas checks the payload schemas against the lock file at path and
+// exits non-zero if one changed without a version bump. With update, a
+// lock that is merely out of date, after a bump or a new event type, is
+// rewritten; otherwise that fails too, so the lock is kept in step.
+func runSchemas(path string, update bool) {
+	eventBus := NewEventBus()
+	if err := registerPayloads(eventBus); err != nil {
+		log.Fatalf("Failed to register payload types: %v", err)
+	}
+	locked, err := ReadSchemaLock(path)
+	if err != nil {
+		log.Fatalf("Failed to read schema lock: %v", err)
+	}
+	current, err := CheckSchemas(eventBus.Schemas(), locked)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if reflect.DeepEqual(current, locked) {
+		log.Printf("%d payload schema(s) match %s", len(current), path)
+		return
+	}
+	if !update {
+		log.Fatalf("%s is out of date; run with -update-schemas and commit it", path)
+	}
+	if err := WriteSchemaLock(path, current); err != nil {
+		log.Fatalf("Failed to write schema lock: %v", err)
+	}
+	log.Printf("Updated %s with %d payload schema(s)", path, len(current))
+}
+
+// runHub relays events between the services until interrupted
+func runHub(addr string) {
+	hub, err := ListenTCPHub(addr)
+	if err != nil {
+		log.Fatalf("Failed to start hub: %v", err)
+	}
+	log.Printf("Hub listening on %s", hub.Addr())
+	untilInterrupted()
+	if err := hub.Close(); err != nil {
+		log.Printf("Failed to close hub: %v", err)
+	}
+}
+
+// runConcerts serves ConcertService on its own and adds a concert to buy
+// tickets for
+func runConcerts(hubAddr, journalPath, schedulePath string) {
+	ctx := context.Background()
+	eventBus, closeJournal := newRemoteEventBus("concerts", hubAddr, journalPath, schedulePath)
+	defer closeJournal()
+
+	concertService, err := NewConcertService(eventBus)
+	if err != nil {
+		log.Fatalf("Failed to start concert service: %v", err)
+	}
+	if journalPath != "" {
+		n, err := concertService.Restore(ctx)
+		if err != nil {
+			log.Fatalf("Failed to restore concerts from journal: %v", err)
+		}
+		log.Printf("Restored %d concert(s) from %s", n, journalPath)
+	}
+	resumeScheduled(eventBus)
+
+	concert := &Concert{
+		Name:             "Rock Festival 2023",
+		Date:             time.Now().AddDate(0, 1, 0),
+		Venue:            "Central Park",
+		AvailableTickets: 1000,
+		TicketPrice:      99.99,
+	}
+	if err := concertService.AddConcert(ctx, concert); err != nil {
+		log.Fatalf("Failed to add concert: %v", err)
+	}
+	log.Printf("Added concert %s; buy a ticket with -role tickets -concert %s", concert.ID, concert.ID)
+
+	untilInterrupted()
+	closeEventBus(eventBus)
+}
+
+// runTickets buys one ticket for concertID through the hub, reports the
+// outcome and exits
+func runTickets(hubAddr, concertID, schedulePath string) {
+	if concertID == "" {
+		log.Fatal("-role tickets needs -concert")
+	}
+	ctx := context.Background()
+	eventBus, closeJournal := newRemoteEventBus("tickets", hubAddr, "", schedulePath)
+	defer closeJournal()
+
+	ticketService, err := NewTicketService(eventBus)
+	if err != nil {
+		log.Fatalf("Failed to start ticket service: %v", err)
+	}
+	resumeScheduled(eventBus)
+
+	ticket := &Ticket{
+		ConcertID:     concertID,
+		CustomerName:  "John Doe",
+		CustomerEmail: "john@example.com",
+	}
+	if err := ticketService.PurchaseTicket(ctx, ticket); err != nil {
+		log.Fatalf("Failed to purchase ticket: %v", err)
+	}
+
+	// ConcertService answers from another process, so poll until it has
+	status, reason, _ := ticketService.Status(ticket.ID)
+	for deadline := time.Now().Add(10 * time.Second); status == TicketPending && time.Now().Before(deadline); {
+		time.Sleep(50 * time.Millisecond)
+		status, reason, _ = ticketService.Status(ticket.ID)
+	}
+	if reason != "" {
+		log.Printf("Ticket %s is %s: %s", ticket.ID, status, reason)
+	} else {
+		log.Printf("Ticket %s is %s", ticket.ID, status)
+	}
+
+	seats, err := Request[SeatsQuery, SeatsAvailable](ctx, eventBus, SeatsQuery{ConcertID: concertID})
+	if err != nil {
+		log.Fatalf("Failed to query seats: %v", err)
+	}
+	fmt.Printf("Concert %s has %d tickets remaining\n", concertID, seats.Available)
+	closeEventBus(eventBus)
+}
+
+// runNotifications serves NotificationService and AuditService on their
+// own until interrupted
+func runNotifications(hubAddr, emailWebhook, smtpAddr, smtpFrom string) {
+	eventBus, closeJournal := newRemoteEventBus("notifications", hubAddr, "", "")
+	defer closeJournal()
+
+	notifier := newNotifier(smtpAddr, smtpFrom)
+	notificationService, err := NewNotificationService(eventBus, notifier)
+	if err != nil {
+		log.Fatalf("Failed to start notification service: %v", err)
+	}
+	defer notificationService.Close()
+	useEmailWebhook(eventBus, notificationService, emailWebhook)
+	auditService, err := NewAuditService(eventBus)
+	if err != nil {
+		log.Fatalf("Failed to start audit service: %v", err)
+	}
+	defer auditService.Close()
+
+	untilInterrupted()
+	closeEventBus(eventBus)
+}
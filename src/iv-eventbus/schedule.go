@@ -0,0 +1,545 @@
+package main
+
+import (
+	"container/heap"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrScheduleNotFound is returned when cancelling a scheduled event that has
+// already been published or cancelled
+var ErrScheduleNotFound = errors.New("scheduled event not found")
+
+// ScheduledEvent is an event waiting to be published at a later time
+type ScheduledEvent struct {
+	ID    string
+	Event Event
+	At    time.Time
+}
+
+// ScheduleStore keeps scheduled events until they are published or
+// cancelled. A persistent store lets them survive a restart; see
+// EventBus.ResumeScheduled.
+type ScheduleStore interface {
+	Add(ScheduledEvent) error
+	List() ([]ScheduledEvent, error)
+	Remove(id string) error
+}
+
+// WithScheduleStore keeps scheduled events in store instead of the default
+// in-memory store
+func WithScheduleStore(store ScheduleStore) BusOption {
+	return func(eb *EventBus) {
+		eb.schedules = store
+	}
+}
+
+// ScheduleToken identifies a scheduled event so it can be cancelled
+type ScheduleToken struct {
+	id  string
+	bus *EventBus
+}
+
+// ID identifies the scheduled event; it stays valid across restarts when
+// the bus uses a persistent ScheduleStore
+func (t *ScheduleToken) ID() string {
+	return t.id
+}
+
+// Cancel stops the event being published. It returns ErrScheduleNotFound if
+// the event has already been published or cancelled.
+func (t *ScheduleToken) Cancel() error {
+	return t.bus.CancelScheduled(t.id)
+}
+
+// PublishAt publishes event at the given time. The correlation and
+// causation headers are taken from ctx now, so a delayed event still
+// belongs to the flow that scheduled it; the event's Timestamp is set when
+// it is published. A time in the past publishes the event at once.
+func (eb *EventBus) PublishAt(ctx context.Context, event Event, at time.Time) (*ScheduleToken, error) {
+	eb.mu.RLock()
+	closed := eb.closed
+	err := eb.checkPayload(event)
+	eb.mu.RUnlock()
+	if closed {
+		return nil, ErrBusClosed
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if event.ID == "" {
+		event.ID = uuid.New().String()
+	}
+	event = withSchemaVersion(correlate(ctx, event))
+	scheduled := ScheduledEvent{ID: uuid.New().String(), Event: event, At: at}
+	if err := eb.schedules.Add(scheduled); err != nil {
+		return nil, fmt.Errorf("scheduling event %s: %w", event.ID, err)
+	}
+	eb.scheduler.add(scheduled)
+	return &ScheduleToken{id: scheduled.ID, bus: eb}, nil
+}
+
+// PublishAfter publishes event once delay has passed
+func (eb *EventBus) PublishAfter(ctx context.Context, event Event, delay time.Duration) (*ScheduleToken, error) {
+	return eb.PublishAt(ctx, event, eb.Now().Add(delay))
+}
+
+// PublishAt schedules payload as a new event of the type derived from T
+func PublishAt[T any](ctx context.Context, eb *EventBus, payload T, at time.Time) (*ScheduleToken, error) {
+	if err := RegisterPayload[T](eb); err != nil {
+		return nil, err
+	}
+	return eb.PublishAt(ctx, Event{
+		ID:      uuid.New().String(),
+		Type:    EventTypeOf[T](),
+		Payload: payload,
+	}, at)
+}
+
+// PublishAfter schedules payload as a new event of the type derived from T
+// once delay has passed
+func PublishAfter[T any](ctx context.Context, eb *EventBus, payload T, delay time.Duration) (*ScheduleToken, error) {
+	return PublishAt(ctx, eb, payload, eb.Now().Add(delay))
+}
+
+// CancelScheduled cancels the scheduled event with the given ID
+func (eb *EventBus) CancelScheduled(id string) error {
+	if eb.scheduler.remove(id) == scheduleFiring {
+		return ErrScheduleNotFound
+	}
+	// An event that is neither queued nor firing has been published
+	// already, or is in the store waiting for ResumeScheduled
+	return eb.schedules.Remove(id)
+}
+
+// Scheduled lists the events waiting to be published, soonest first
+func (eb *EventBus) Scheduled() ([]ScheduledEvent, error) {
+	return eb.schedules.List()
+}
+
+// ResumeScheduled loads the events left in the schedule store by a previous
+// run and publishes each at its time, or at once if that has passed. Call
+// it after the subscribers are registered, so overdue events reach them.
+// It returns the number of events resumed.
+func (eb *EventBus) ResumeScheduled() (int, error) {
+	pending, err := eb.schedules.List()
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, scheduled := range pending {
+		if eb.scheduler.add(scheduled) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// DiscardScheduled removes the events of eventType left in the schedule
+// store by a previous run and returns them. Call it before ResumeScheduled
+// for events that would refer to state the run did not keep.
+func (eb *EventBus) DiscardScheduled(eventType string) ([]ScheduledEvent, error) {
+	pending, err := eb.schedules.List()
+	if err != nil {
+		return nil, err
+	}
+	var discarded []ScheduledEvent
+	for _, scheduled := range pending {
+		if scheduled.Event.Type != eventType {
+			continue
+		}
+		if err := eb.schedules.Remove(scheduled.ID); err != nil && !errors.Is(err, ErrScheduleNotFound) {
+			return discarded, err
+		}
+		discarded = append(discarded, scheduled)
+	}
+	return discarded, nil
+}
+
+// fireScheduled publishes a due event and removes it from the store. The
+// event is removed only after it has been published, so a crash in between
+// publishes it again on the next run.
+func (eb *EventBus) fireScheduled(scheduled ScheduledEvent) {
+	event, err := eb.decodeEvent(scheduled.Event)
+	if err != nil {
+		log.Printf("Dropping scheduled event %s: decoding %s payload: %v", scheduled.ID, event.Type, err)
+		eb.removeScheduled(scheduled.ID)
+		return
+	}
+	event.Timestamp = eb.Now()
+	if err := eb.Publish(context.Background(), event); err != nil {
+		if errors.Is(err, ErrBusClosed) {
+			return
+		}
+		log.Printf("Dropping scheduled event %s: %v", scheduled.ID, err)
+	}
+	eb.removeScheduled(scheduled.ID)
+}
+
+func (eb *EventBus) removeScheduled(id string) {
+	if err := eb.schedules.Remove(id); err != nil && !errors.Is(err, ErrScheduleNotFound) {
+		log.Printf("Failed to remove scheduled event %s: %v", id, err)
+	}
+}
+
+// scheduler publishes scheduled events when they fall due. Pending events
+// are kept in a heap ordered by time, and a single goroutine sleeps until
+// the earliest one.
+type scheduler struct {
+	bus     *EventBus
+	mu      sync.Mutex
+	queue   scheduleQueue
+	byID    map[string]*scheduleItem
+	firing  string
+	wake    chan struct{}
+	stopped chan struct{}
+	done    chan struct{}
+}
+
+type scheduleItem struct {
+	ScheduledEvent
+	index int
+}
+
+func newScheduler(eb *EventBus) *scheduler {
+	s := &scheduler{
+		bus:     eb,
+		byID:    make(map[string]*scheduleItem),
+		wake:    make(chan struct{}, 1),
+		stopped: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// add queues scheduled unless it is already queued or being published
+func (s *scheduler) add(scheduled ScheduledEvent) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.byID[scheduled.ID]; ok || s.firing == scheduled.ID {
+		return false
+	}
+	item := &scheduleItem{ScheduledEvent: scheduled}
+	heap.Push(&s.queue, item)
+	s.byID[scheduled.ID] = item
+	s.notify()
+	return true
+}
+
+// scheduleState is where an event was found when it was removed
+type scheduleState int
+
+const (
+	scheduleAbsent scheduleState = iota
+	scheduleQueued
+	scheduleFiring
+)
+
+// remove dequeues the event with the given ID; an event already being
+// published is left alone
+func (s *scheduler) remove(id string) scheduleState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.firing == id {
+		return scheduleFiring
+	}
+	item, ok := s.byID[id]
+	if !ok {
+		return scheduleAbsent
+	}
+	heap.Remove(&s.queue, item.index)
+	delete(s.byID, id)
+	s.notify()
+	return scheduleQueued
+}
+
+// notify wakes the run loop to recompute its deadline; s.mu must be held
+func (s *scheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *scheduler) run() {
+	defer close(s.done)
+	for {
+		s.mu.Lock()
+		var due *scheduleItem
+		var wait time.Duration = -1
+		if s.queue.Len() > 0 {
+			next := s.queue[0]
+			if wait = next.At.Sub(s.bus.Now()); wait <= 0 {
+				due = heap.Pop(&s.queue).(*scheduleItem)
+				delete(s.byID, due.ID)
+				s.firing = due.ID
+			}
+		}
+		s.mu.Unlock()
+
+		if due != nil {
+			s.bus.fireScheduled(due.ScheduledEvent)
+			s.mu.Lock()
+			s.firing = ""
+			s.mu.Unlock()
+			continue
+		}
+		// The timer is stopped on every wake, since the next pass computes
+		// a fresh deadline and would otherwise leave this one pending
+		var timer Timer
+		var fire <-chan time.Time
+		if wait > 0 {
+			timer = s.bus.clock.NewTimer(wait)
+			fire = timer.C()
+		}
+		select {
+		case <-fire:
+		case <-s.wake:
+		case <-s.stopped:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		select {
+		case <-s.stopped:
+			return
+		default:
+		}
+	}
+}
+
+// stop ends the run loop; events still queued stay in the store
+func (s *scheduler) stop() {
+	select {
+	case <-s.stopped:
+	default:
+		close(s.stopped)
+	}
+	<-s.done
+}
+
+// scheduleQueue is a min-heap of scheduled events by time
+type scheduleQueue []*scheduleItem
+
+func (q scheduleQueue) Len() int { return len(q) }
+
+func (q scheduleQueue) Less(i, j int) bool { return q[i].At.Before(q[j].At) }
+
+func (q scheduleQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *scheduleQueue) Push(x any) {
+	item := x.(*scheduleItem)
+	item.index = len(*q)
+	*q = append(*q, item)
+}
+
+func (q *scheduleQueue) Pop() any {
+	old := *q
+	item := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	return item
+}
+
+// MemoryScheduleStore is an in-process ScheduleStore; its events are lost
+// when the process exits
+type MemoryScheduleStore struct {
+	events map[string]ScheduledEvent
+	mu     sync.RWMutex
+}
+
+// NewMemoryScheduleStore creates an empty MemoryScheduleStore
+func NewMemoryScheduleStore() *MemoryScheduleStore {
+	return &MemoryScheduleStore{events: make(map[string]ScheduledEvent)}
+}
+
+// Add stores a scheduled event, replacing any with the same ID
+func (s *MemoryScheduleStore) Add(scheduled ScheduledEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events[scheduled.ID] = scheduled
+	return nil
+}
+
+// List returns all scheduled events, soonest first
+func (s *MemoryScheduleStore) List() ([]ScheduledEvent, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	events := make([]ScheduledEvent, 0, len(s.events))
+	for _, scheduled := range s.events {
+		events = append(events, scheduled)
+	}
+	sortScheduled(events)
+	return events, nil
+}
+
+// Remove deletes the scheduled event with the given ID
+func (s *MemoryScheduleStore) Remove(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.events[id]; !ok {
+		return ErrScheduleNotFound
+	}
+	delete(s.events, id)
+	return nil
+}
+
+func sortScheduled(events []ScheduledEvent) {
+	sort.Slice(events, func(i, j int) bool { return events[i].At.Before(events[j].At) })
+}
+
+// FileScheduleStore is a ScheduleStore kept in a JSON file, which is
+// rewritten atomically on every change. Payloads are stored as JSON and
+// listed as json.RawMessage; the bus decodes them into their registered
+// types when the events are published.
+type FileScheduleStore struct {
+	path   string
+	mu     sync.Mutex
+	events map[string]ScheduledEvent
+}
+
+// scheduleRecord is one scheduled event as stored in the file
+type scheduleRecord struct {
+	ID        string            `json:"id"`
+	At        time.Time         `json:"at"`
+	EventID   string            `json:"event_id"`
+	Type      string            `json:"type"`
+	Payload   json.RawMessage   `json:"payload"`
+	Timestamp time.Time         `json:"timestamp"`
+	Headers   map[string]string `json:"headers,omitempty"`
+}
+
+// OpenFileScheduleStore loads the store at path, creating it on first use
+func OpenFileScheduleStore(path string) (*FileScheduleStore, error) {
+	s := &FileScheduleStore{path: path, events: make(map[string]ScheduledEvent)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var records []scheduleRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("reading schedule store %s: %w", path, err)
+	}
+	for _, r := range records {
+		s.events[r.ID] = ScheduledEvent{
+			ID: r.ID,
+			At: r.At,
+			Event: Event{
+				ID:        r.EventID,
+				Type:      r.Type,
+				Payload:   r.Payload,
+				Timestamp: r.Timestamp,
+				Headers:   r.Headers,
+			},
+		}
+	}
+	return s, nil
+}
+
+// Add stores a scheduled event, replacing any with the same ID
+func (s *FileScheduleStore) Add(scheduled ScheduledEvent) error {
+	payload, err := json.Marshal(scheduled.Event.Payload)
+	if err != nil {
+		return fmt.Errorf("encoding payload of %s: %w", scheduled.Event.Type, err)
+	}
+	scheduled.Event.Payload = json.RawMessage(payload)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	previous, existed := s.events[scheduled.ID]
+	s.events[scheduled.ID] = scheduled
+	if err := s.save(); err != nil {
+		if existed {
+			s.events[scheduled.ID] = previous
+		} else {
+			delete(s.events, scheduled.ID)
+		}
+		return err
+	}
+	return nil
+}
+
+// List returns all scheduled events, soonest first
+func (s *FileScheduleStore) List() ([]ScheduledEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	events := make([]ScheduledEvent, 0, len(s.events))
+	for _, scheduled := range s.events {
+		events = append(events, scheduled)
+	}
+	sortScheduled(events)
+	return events, nil
+}
+
+// Remove deletes the scheduled event with the given ID
+func (s *FileScheduleStore) Remove(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	scheduled, ok := s.events[id]
+	if !ok {
+		return ErrScheduleNotFound
+	}
+	delete(s.events, id)
+	if err := s.save(); err != nil {
+		s.events[id] = scheduled
+		return err
+	}
+	return nil
+}
+
+// save writes every event to a temporary file and renames it over the
+// store; s.mu must be held
+func (s *FileScheduleStore) save() error {
+	records := make([]scheduleRecord, 0, len(s.events))
+	for _, scheduled := range s.events {
+		records = append(records, scheduleRecord{
+			ID:        scheduled.ID,
+			At:        scheduled.At,
+			EventID:   scheduled.Event.ID,
+			Type:      scheduled.Event.Type,
+			Payload:   scheduled.Event.Payload.(json.RawMessage),
+			Timestamp: scheduled.Event.Timestamp,
+			Headers:   scheduled.Event.Headers,
+		})
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].At.Before(records[j].At) })
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
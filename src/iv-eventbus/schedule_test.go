@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPublishAfterFiresOnAdvance(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	eb, rec := NewRecordingBus(t, WithClock(clock))
+	ctx := context.Background()
+	if _, err := PublishAfter(ctx, eb, ReservationExpired{TicketID: "t1", ConcertID: "c1"}, 15*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	clock.AwaitWaiters(t, 1)
+
+	clock.Advance(14 * time.Minute)
+	rec.ExpectNotPublished(t, EventTypeOf[ReservationExpired](), nil)
+
+	clock.Advance(time.Minute)
+	expired := AwaitPayload(t, rec, func(e ReservationExpired) bool { return e.TicketID == "t1" })
+	if expired.ConcertID != "c1" {
+		t.Fatalf("want concert c1, got %q", expired.ConcertID)
+	}
+}
+
+func TestSchedulerStopsStaleTimers(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	eb, _ := NewRecordingBus(t, WithClock(clock))
+	ctx := context.Background()
+	// Each event scheduled earlier than the last wakes the run loop, which
+	// must cancel its previous timer before arming the next
+	for i := 10; i > 0; i-- {
+		if _, err := PublishAfter(ctx, eb, ReservationExpired{TicketID: "t"}, time.Duration(i)*time.Hour); err != nil {
+			t.Fatal(err)
+		}
+		clock.AwaitWaiters(t, 1)
+	}
+	if _, err := PublishAfter(ctx, eb, ReservationExpired{TicketID: "late"}, 20*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	clock.AwaitWaiters(t, 1)
+}
+
+func TestResumeDropsReservationExpiries(t *testing.T) {
+	ctx := context.Background()
+	store, err := OpenFileScheduleStore(filepath.Join(t.TempDir(), "schedule.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := NewFakeClock(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	before, _ := NewRecordingBus(t, WithClock(clock), WithScheduleStore(store))
+	if _, err := PublishAfter(ctx, before, ReservationExpired{TicketID: "t1", ConcertID: "c1"}, 15*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := PublishAfter(ctx, before, ConcertReminder{ConcertID: "c1"}, 24*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := before.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// The next run knows no reservations, so only the reminder comes back
+	after, rec := NewRecordingBus(t, WithClock(clock), WithScheduleStore(store))
+	if err := registerPayloads(after); err != nil {
+		t.Fatal(err)
+	}
+	resumeScheduled(after)
+	scheduled, err := after.Scheduled()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scheduled) != 1 || scheduled[0].Event.Type != EventTypeOf[ConcertReminder]() {
+		t.Fatalf("want only the reminder left, got %+v", scheduled)
+	}
+	// The reminder fires after the expiry would have
+	clock.AwaitWaiters(t, 1)
+	clock.Advance(24 * time.Hour)
+	rec.AwaitEvent(t, EventTypeOf[ConcertReminder]())
+	rec.ExpectNotPublished(t, EventTypeOf[ReservationExpired](), nil)
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// HeaderSchemaVersion carries the schema version of an event's payload
+const HeaderSchemaVersion = "schema-version"
+
+var (
+	// ErrNoUpcaster is returned when a stored or received payload is older
+	// than its type and no upcaster covers the gap
+	ErrNoUpcaster = errors.New("no upcaster")
+
+	// ErrSchemaVersion is returned for a payload newer than its type, such as
+	// one sent by a more recent build of another service
+	ErrSchemaVersion = errors.New("unsupported schema version")
+
+	// ErrSchemaChanged is returned by CheckSchemas when a payload type
+	// changed shape without a version bump
+	ErrSchemaChanged = errors.New("payload schema changed without a version bump")
+)
+
+// SchemaVersioner lets a payload type declare the version of its shape.
+// Bump it whenever the JSON shape changes, and register an upcaster from
+// the previous version. Payload types without it are at version 1.
+type SchemaVersioner interface {
+	SchemaVersion() int
+}
+
+// Upcaster transforms a JSON payload from one schema version to the next
+type Upcaster func(json.RawMessage) (json.RawMessage, error)
+
+// SchemaVersion returns the schema version of the event's payload; events
+// recorded before versioning count as version 1
+func (e Event) SchemaVersion() int {
+	version, err := strconv.Atoi(e.Headers[HeaderSchemaVersion])
+	if err != nil || version < 1 {
+		return 1
+	}
+	return version
+}
+
+// schemaVersionOf returns the current schema version of payload type t
+func schemaVersionOf(t reflect.Type) int {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() == reflect.Interface {
+		return 1
+	}
+	if versioner, ok := reflect.New(t).Elem().Interface().(SchemaVersioner); ok {
+		return versioner.SchemaVersion()
+	}
+	return 1
+}
+
+// withSchemaVersion stamps an event with its payload's schema version
+// unless it already carries one
+func withSchemaVersion(event Event) Event {
+	if _, ok := event.Headers[HeaderSchemaVersion]; ok {
+		return event
+	}
+	if _, raw := event.Payload.(json.RawMessage); raw {
+		return event
+	}
+	version := schemaVersionOf(reflect.TypeOf(event.Payload))
+	return event.withHeader(HeaderSchemaVersion, strconv.Itoa(version))
+}
+
+type upcasterKey struct {
+	eventType string
+	from      int
+}
+
+// RegisterUpcaster registers fn to turn payloads of eventType at schema
+// version from into version from+1. Payloads read from the journal, the
+// schedule store or a transport pass through every upcaster between their
+// version and the current one before they are decoded.
+func (eb *EventBus) RegisterUpcaster(eventType string, from int, fn Upcaster) error {
+	if from < 1 {
+		return fmt.Errorf("upcaster for %s: versions start at 1", eventType)
+	}
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+	key := upcasterKey{eventType: eventType, from: from}
+	if _, ok := eb.upcasters[key]; ok {
+		return fmt.Errorf("upcaster for %s from version %d is already registered", eventType, from)
+	}
+	eb.upcasters[key] = fn
+	return nil
+}
+
+// RegisterUpcaster registers fn to upcast payloads of type T from schema
+// version from to from+1
+func RegisterUpcaster[T any](eb *EventBus, from int, fn Upcaster) error {
+	if err := RegisterPayload[T](eb); err != nil {
+		return err
+	}
+	return eb.RegisterUpcaster(EventTypeOf[T](), from, fn)
+}
+
+// upcast brings raw from schema version from up to version to
+func (eb *EventBus) upcast(eventType string, from, to int, raw json.RawMessage) (json.RawMessage, error) {
+	if from > to {
+		return nil, fmt.Errorf("%w: %s version %d is newer than %d", ErrSchemaVersion, eventType, from, to)
+	}
+	for version := from; version < to; version++ {
+		eb.mu.RLock()
+		fn, ok := eb.upcasters[upcasterKey{eventType: eventType, from: version}]
+		eb.mu.RUnlock()
+		if !ok {
+			return nil, fmt.Errorf("%w for %s from version %d to %d", ErrNoUpcaster, eventType, version, version+1)
+		}
+		var err error
+		if raw, err = fn(raw); err != nil {
+			return nil, fmt.Errorf("upcasting %s from version %d: %w", eventType, version, err)
+		}
+	}
+	return raw, nil
+}
+
+// SchemaFingerprint identifies the JSON shape of payload type t: its
+// fields' names, JSON tags and types, recursively. Renaming the Go type
+// leaves it unchanged.
+func SchemaFingerprint(t reflect.Type) string {
+	var b strings.Builder
+	describeType(&b, t, map[reflect.Type]bool{})
+	sum := sha256.Sum256([]byte(b.String()))
+	return hex.EncodeToString(sum[:])
+}
+
+func describeType(b *strings.Builder, t reflect.Type, seen map[reflect.Type]bool) {
+	if t.PkgPath() != "" && t.PkgPath() != reflect.TypeOf(Event{}).PkgPath() {
+		// Types from other packages, such as time.Time, are described by
+		// name; a change there is not ours to version
+		b.WriteString(t.PkgPath() + "." + t.Name())
+		return
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		// A nil pointer marshals as null, which its target never does, so
+		// swapping one for the other changes the schema
+		b.WriteString("*")
+		describeType(b, t.Elem(), seen)
+	case reflect.Slice, reflect.Array:
+		b.WriteString("[]")
+		describeType(b, t.Elem(), seen)
+	case reflect.Map:
+		b.WriteString("map[")
+		describeType(b, t.Key(), seen)
+		b.WriteString("]")
+		describeType(b, t.Elem(), seen)
+	case reflect.Struct:
+		if seen[t] {
+			b.WriteString("cycle(" + t.Name() + ")")
+			return
+		}
+		seen[t] = true
+		defer delete(seen, t)
+		b.WriteString("struct{")
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			fmt.Fprintf(b, "%s %q ", field.Name, field.Tag.Get("json"))
+			describeType(b, field.Type, seen)
+			b.WriteString(";")
+		}
+		b.WriteString("}")
+	default:
+		b.WriteString(t.Kind().String())
+	}
+}
+
+// SchemaLockEntry pins the version and fingerprint of one payload type
+type SchemaLockEntry struct {
+	Version     int    `json:"version"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+// SchemaLock maps event types to their pinned payload schemas
+type SchemaLock map[string]SchemaLockEntry
+
+// Schemas returns the current schema of every registered payload type
+func (eb *EventBus) Schemas() SchemaLock {
+	eb.mu.RLock()
+	defer eb.mu.RUnlock()
+	lock := make(SchemaLock, len(eb.payloadTypes))
+	for eventType, t := range eb.payloadTypes {
+		lock[eventType] = SchemaLockEntry{Version: schemaVersionOf(t), Fingerprint: SchemaFingerprint(t)}
+	}
+	return lock
+}
+
+// CheckSchemas compares the current schemas with a lock recorded earlier.
+// It fails with ErrSchemaChanged for every payload type whose fingerprint
+// changed while its version stayed the same, or whose version went down.
+// Types that are new or were bumped are fine; they are returned in the
+// updated lock, which should replace the old one.
+func CheckSchemas(current, locked SchemaLock) (SchemaLock, error) {
+	updated := make(SchemaLock, len(current))
+	var problems []string
+	for eventType, now := range current {
+		updated[eventType] = now
+		was, ok := locked[eventType]
+		switch {
+		case !ok:
+		case now.Version < was.Version:
+			problems = append(problems, fmt.Sprintf("%s went back from version %d to %d", eventType, was.Version, now.Version))
+		case now.Version == was.Version && now.Fingerprint != was.Fingerprint:
+			problems = append(problems, fmt.Sprintf("%s changed at version %d", eventType, now.Version))
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return updated, fmt.Errorf("%w: %s", ErrSchemaChanged, strings.Join(problems, "; "))
+	}
+	return updated, nil
+}
+
+// ReadSchemaLock loads a lock file; a missing file is an empty lock
+func ReadSchemaLock(path string) (SchemaLock, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return SchemaLock{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var lock SchemaLock
+	if err := json.Unmarshal(data, &lock); err != nil {
+		return nil, fmt.Errorf("reading schema lock %s: %w", path, err)
+	}
+	return lock, nil
+}
+
+// WriteSchemaLock saves a lock file with its event types in order
+func WriteSchemaLock(path string, lock SchemaLock) error {
+	data, err := json.MarshalIndent(lock, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
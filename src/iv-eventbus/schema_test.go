@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestSchemaLockIsCurrent(t *testing.T) {
+	eb := NewEventBus()
+	if err := registerPayloads(eb); err != nil {
+		t.Fatal(err)
+	}
+	locked, err := ReadSchemaLock("schemas.lock.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := CheckSchemas(eb.Schemas(), locked)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(current, locked) {
+		t.Fatal("schemas.lock.json is out of date; run with -role schemas -update-schemas")
+	}
+}
+
+func TestSchemaFingerprintSeesPointers(t *testing.T) {
+	byValue := SchemaFingerprint(reflect.TypeOf(struct{ Concert Concert }{}))
+	byPointer := SchemaFingerprint(reflect.TypeOf(struct{ Concert *Concert }{}))
+	if byValue == byPointer {
+		t.Fatal("swapping a value for a pointer left the fingerprint unchanged")
+	}
+}
+
+// TestDecodePointerEraPayloads decodes payloads as they were written when
+// ConcertAdded, TicketRequested and TicketPurchased held pointers
+func TestDecodePointerEraPayloads(t *testing.T) {
+	eb := NewEventBus()
+	if err := registerPayloads(eb); err != nil {
+		t.Fatal(err)
+	}
+	concert := Concert{ID: "c1", Name: "Rock Concert", AvailableTickets: 5}
+	ticket := Ticket{ID: "t1", ConcertID: "c1", CustomerName: "Ada", Status: TicketConfirmed}
+	encode := func(payload any) json.RawMessage {
+		raw, err := json.Marshal(payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return raw
+	}
+	// Version 1 of TicketPurchased predates Status and VoidReason
+	v1Ticket := map[string]any{"ID": ticket.ID, "ConcertID": ticket.ConcertID, "CustomerName": ticket.CustomerName}
+
+	tests := []struct {
+		name    string
+		event   Event
+		want    any
+		version int
+	}{
+		{"concert added v1", Event{Type: "concert.added", Payload: encode(struct{ Concert *Concert }{&concert})}, ConcertAdded{Concert: concert}, 1},
+		{"concert added v1 null", Event{Type: "concert.added", Payload: encode(struct{ Concert *Concert }{})}, ConcertAdded{}, 1},
+		{"ticket requested v1", Event{Type: "ticket.requested", Payload: encode(struct{ Ticket *Ticket }{&ticket})}, TicketRequested{Ticket: ticket}, 1},
+		{"ticket purchased v1", Event{Type: "ticket.purchased", Payload: encode(map[string]any{"Ticket": v1Ticket})}, TicketPurchased{Ticket: ticket}, 1},
+		{"ticket purchased v2", Event{Type: "ticket.purchased", Payload: encode(struct{ Ticket *Ticket }{&ticket})}, TicketPurchased{Ticket: ticket}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := tt.event.withHeader(HeaderSchemaVersion, strconv.Itoa(tt.version))
+			decoded, err := eb.decodeEvent(event)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(decoded.Payload, tt.want) {
+				t.Fatalf("want %+v, got %+v", tt.want, decoded.Payload)
+			}
+			if want := schemaVersionOf(reflect.TypeOf(tt.want)); decoded.SchemaVersion() != want {
+				t.Fatalf("want schema version %d, got %d", want, decoded.SchemaVersion())
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// SMTPChannel sends notifications as plain-text email through an SMTP
+// server, upgrading to TLS when the server offers STARTTLS
+type SMTPChannel struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+// NewSMTPChannel creates a channel sending from the address from through
+// the server at addr, authenticating with auth if it is not nil
+func NewSMTPChannel(addr, from string, auth smtp.Auth) *SMTPChannel {
+	return &SMTPChannel{addr: addr, from: from, auth: auth}
+}
+
+// Name implements Channel
+func (c *SMTPChannel) Name() string { return ChannelEmail }
+
+// Address implements Channel
+func (c *SMTPChannel) Address(prefs Preferences) string { return prefs.Email }
+
+// Send implements Channel. The SMTP exchange is abandoned when ctx ends.
+func (c *SMTPChannel) Send(ctx context.Context, message Message) error {
+	data, err := c.compose(message)
+	if err != nil {
+		return err
+	}
+	host, _, err := net.SplitHostPort(c.addr)
+	if err != nil {
+		return err
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return err
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	if err := c.exchange(conn, host, message.To, data); err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("sending email to %s: %w", message.To, ctx.Err())
+		}
+		return err
+	}
+	return nil
+}
+
+// exchange runs the SMTP conversation delivering data to rcpt over conn
+func (c *SMTPChannel) exchange(conn net.Conn, host, rcpt string, data []byte) error {
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if c.auth != nil {
+		if err := client.Auth(c.auth); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(c.from); err != nil {
+		return err
+	}
+	if err := client.Rcpt(rcpt); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// compose renders message as an RFC 5322 email with a quoted-printable
+// UTF-8 body
+func (c *SMTPChannel) compose(message Message) ([]byte, error) {
+	for _, addr := range []string{c.from, message.To} {
+		if strings.ContainsAny(addr, "\r\n") {
+			return nil, fmt.Errorf("invalid email address %q", addr)
+		}
+	}
+	var buf bytes.Buffer
+	header := func(key, value string) {
+		fmt.Fprintf(&buf, "%s: %s\r\n", key, value)
+	}
+	header("From", c.from)
+	header("To", message.To)
+	header("Subject", mime.QEncoding.Encode("utf-8", message.Subject))
+	header("Date", message.At.Format(time.RFC1123Z))
+	header("Message-ID", "<"+message.ID+"@eventbus>")
+	header("MIME-Version", "1.0")
+	header("Content-Type", `text/plain; charset="utf-8"`)
+	header("Content-Transfer-Encoding", "quoted-printable")
+	buf.WriteString("\r\n")
+
+	qp := quotedprintable.NewWriter(&buf)
+	body := strings.ReplaceAll(strings.ReplaceAll(message.Body, "\r\n", "\n"), "\n", "\r\n")
+	if _, err := qp.Write([]byte(body)); err != nil {
+		return nil, err
+	}
+	if err := qp.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// SMTPMessage is an email received by a FakeSMTPServer
+type SMTPMessage struct {
+	From string
+	To   []string
+	Data []byte
+}
+
+// Parse reads the message's headers and decodes its body
+func (m SMTPMessage) Parse() (*mail.Message, string, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(m.Data))
+	if err != nil {
+		return nil, "", err
+	}
+	var body bytes.Buffer
+	reader := msg.Body
+	if strings.EqualFold(msg.Header.Get("Content-Transfer-Encoding"), "quoted-printable") {
+		reader = quotedprintable.NewReader(msg.Body)
+	}
+	if _, err := body.ReadFrom(reader); err != nil {
+		return nil, "", err
+	}
+	return msg, body.String(), nil
+}
+
+// FakeSMTPServer is a minimal SMTP server on the loopback interface that
+// keeps every message it receives, for exercising SMTPChannel without a
+// real mail server
+type FakeSMTPServer struct {
+	listener net.Listener
+	wg       sync.WaitGroup
+
+	mu       sync.Mutex
+	closed   bool
+	messages []SMTPMessage
+	conns    map[net.Conn]struct{}
+	// received is closed and replaced whenever a message arrives
+	received chan struct{}
+}
+
+// StartFakeSMTPServer starts a FakeSMTPServer on a free loopback port,
+// closed when the test ends
+func StartFakeSMTPServer(t testing.TB) *FakeSMTPServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &FakeSMTPServer{
+		listener: listener,
+		conns:    make(map[net.Conn]struct{}),
+		received: make(chan struct{}),
+	}
+	s.wg.Add(1)
+	go s.serve()
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// Addr is the address to point an SMTPChannel at
+func (s *FakeSMTPServer) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Messages returns the messages received so far
+func (s *FakeSMTPServer) Messages() []SMTPMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]SMTPMessage(nil), s.messages...)
+}
+
+// Await blocks until at least n messages have been received, failing the
+// test after awaitTimeout
+func (s *FakeSMTPServer) Await(t testing.TB, n int) []SMTPMessage {
+	t.Helper()
+	timeout := time.After(awaitTimeout)
+	for {
+		s.mu.Lock()
+		if len(s.messages) >= n {
+			messages := append([]SMTPMessage(nil), s.messages...)
+			s.mu.Unlock()
+			return messages
+		}
+		received := s.received
+		s.mu.Unlock()
+		select {
+		case <-received:
+		case <-timeout:
+			t.Fatalf("want %d email(s) within %s, got %d", n, awaitTimeout, len(s.Messages()))
+		}
+	}
+}
+
+// Close stops the server and drops its open connections; StartFakeSMTPServer
+// arranges for it to run when the test ends
+func (s *FakeSMTPServer) Close() error {
+	err := s.listener.Close()
+	s.mu.Lock()
+	s.closed = true
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+func (s *FakeSMTPServer) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.session(conn)
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+			conn.Close()
+		}()
+	}
+}
+
+// session speaks just enough SMTP for net/smtp: HELO/EHLO, MAIL, RCPT,
+// DATA, RSET, NOOP and QUIT
+func (s *FakeSMTPServer) session(conn net.Conn) {
+	tp := textproto.NewConn(conn)
+	reply := func(code int, text string) bool {
+		return tp.PrintfLine("%d %s", code, text) == nil
+	}
+	if !reply(220, "fake smtp ready") {
+		return
+	}
+	var current SMTPMessage
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		var ok bool
+		switch strings.ToUpper(verb) {
+		case "HELO", "EHLO":
+			current = SMTPMessage{}
+			ok = reply(250, "fake smtp")
+		case "MAIL":
+			current = SMTPMessage{From: smtpPath(arg, "FROM:")}
+			ok = reply(250, "ok")
+		case "RCPT":
+			current.To = append(current.To, smtpPath(arg, "TO:"))
+			ok = reply(250, "ok")
+		case "DATA":
+			if len(current.To) == 0 {
+				ok = reply(503, "need RCPT first")
+				break
+			}
+			if !reply(354, "end data with <CR><LF>.<CR><LF>") {
+				return
+			}
+			data, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			current.Data = data
+			s.deliver(current)
+			current = SMTPMessage{}
+			ok = reply(250, "ok: queued")
+		case "RSET":
+			current = SMTPMessage{}
+			ok = reply(250, "ok")
+		case "NOOP":
+			ok = reply(250, "ok")
+		case "QUIT":
+			reply(221, "bye")
+			return
+		default:
+			ok = reply(502, "command not implemented")
+		}
+		if !ok {
+			return
+		}
+	}
+}
+
+func (s *FakeSMTPServer) deliver(message SMTPMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = append(s.messages, message)
+	close(s.received)
+	s.received = make(chan struct{})
+}
+
+// smtpPath extracts the address from a MAIL FROM:<addr> or RCPT TO:<addr>
+// argument
+func smtpPath(arg, prefix string) string {
+	if len(arg) >= len(prefix) && strings.EqualFold(arg[:len(prefix)], prefix) {
+		arg = arg[len(prefix):]
+	}
+	arg, _, _ = strings.Cut(strings.TrimSpace(arg), " ")
+	return strings.TrimSuffix(strings.TrimPrefix(arg, "<"), ">")
+}
+
+func TestSMTPChannelSends(t *testing.T) {
+	server := StartFakeSMTPServer(t)
+	channel := NewSMTPChannel(server.Addr(), "tickets@example.com", nil)
+	message := Message{
+		ID:      "m1",
+		To:      "ada@example.com",
+		Subject: "Deine Karte für Über Jazz",
+		Body:    "Hallo Ada,\n\ndeine Karte ist bestätigt. " + strings.Repeat("lang ", 30) + "\n",
+		At:      time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC),
+	}
+	if err := channel.Send(context.Background(), message); err != nil {
+		t.Fatal(err)
+	}
+
+	emails := server.Await(t, 1)
+	if emails[0].From != "tickets@example.com" || len(emails[0].To) != 1 || emails[0].To[0] != message.To {
+		t.Fatalf("want envelope tickets@example.com -> %s, got %s -> %v", message.To, emails[0].From, emails[0].To)
+	}
+	msg, body, err := emails[0].Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for header, want := range map[string]string{
+		"From":         "tickets@example.com",
+		"To":           message.To,
+		"Message-Id":   "<m1@eventbus>",
+		"Content-Type": `text/plain; charset="utf-8"`,
+		"Date":         message.At.Format(time.RFC1123Z),
+	} {
+		if got := msg.Header.Get(header); got != want {
+			t.Errorf("%s: want %q, got %q", header, want, got)
+		}
+	}
+	if subject != message.Subject {
+		t.Errorf("want subject %q, got %q", message.Subject, subject)
+	}
+	if body != message.Body {
+		t.Errorf("want body %q, got %q", message.Body, body)
+	}
+}
+
+func TestSMTPChannelRejectsHeaderInjection(t *testing.T) {
+	server := StartFakeSMTPServer(t)
+	channel := NewSMTPChannel(server.Addr(), "tickets@example.com", nil)
+	err := channel.Send(context.Background(), Message{To: "ada@example.com\r\nBcc: eve@example.com", Subject: "Hi"})
+	if err == nil {
+		t.Fatal("want an error for an address spanning lines")
+	}
+	if got := len(server.Messages()); got != 0 {
+		t.Fatalf("want no email sent, got %d", got)
+	}
+}
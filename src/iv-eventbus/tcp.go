@@ -0,0 +1,707 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// The TCP transport connects buses through a hub. Each bus dials the hub
+// and names itself; the hub relays every event a bus sends to all other
+// buses it has seen. Frames are JSON objects preceded by their length as a
+// 4-byte big-endian integer.
+//
+// Delivery is at least once in both directions. A bus keeps each event it
+// sends until the hub acknowledges it and sends it again after
+// reconnecting; the hub keeps each event it relays until the receiving bus
+// acknowledges it, including while that bus is disconnected, and sends it
+// again when the bus reconnects under the same name. Receivers drop
+// duplicates by event ID. The hub keeps events in memory, so those it has
+// not delivered are lost if it restarts, and it forgets a bus that stays
+// disconnected for tcpPeerExpiry along with its undelivered events.
+
+var (
+	// ErrTransportClosed is returned when sending on a closed transport
+	ErrTransportClosed = errors.New("transport is closed")
+
+	// ErrTransportBacklog is returned when too many sent events are still
+	// waiting for the hub to acknowledge them
+	ErrTransportBacklog = errors.New("transport backlog is full")
+)
+
+const (
+	frameHello = "hello"
+	frameEvent = "event"
+	frameAck   = "ack"
+
+	maxFrameSize = 16 << 20
+
+	// tcpMaxPending bounds the unacknowledged events a bus or the hub keeps
+	// for one peer
+	tcpMaxPending = 10000
+
+	// tcpSeenIDs is how many received event IDs are remembered to drop
+	// duplicates
+	tcpSeenIDs = 4096
+
+	// tcpPeerExpiry is how long the hub keeps events for a bus that has
+	// disconnected
+	tcpPeerExpiry = 10 * time.Minute
+
+	// tcpReceiveTimeout is how long a received event may wait for room in
+	// the worker pool before it is left unacknowledged
+	tcpReceiveTimeout = 10 * time.Second
+
+	tcpDialTimeout  = 5 * time.Second
+	tcpWriteTimeout = 10 * time.Second
+	tcpCloseTimeout = 2 * time.Second
+)
+
+// tcpReconnectPolicy paces attempts to reach the hub
+var tcpReconnectPolicy = RetryPolicy{
+	InitialBackoff: 100 * time.Millisecond,
+	MaxBackoff:     5 * time.Second,
+	Multiplier:     2,
+	Jitter:         0.2,
+}
+
+type tcpFrame struct {
+	Kind  string     `json:"kind"`
+	Name  string     `json:"name,omitempty"`
+	Seq   uint64     `json:"seq,omitempty"`
+	Event *wireEvent `json:"event,omitempty"`
+}
+
+// wireEvent is an Event with its payload encoded as JSON
+type wireEvent struct {
+	ID        string            `json:"id"`
+	Type      string            `json:"type"`
+	Payload   json.RawMessage   `json:"payload"`
+	Timestamp time.Time         `json:"timestamp"`
+	Headers   map[string]string `json:"headers,omitempty"`
+}
+
+func toWire(event Event) (*wireEvent, error) {
+	payload, err := json.Marshal(event.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("encoding payload of %s: %w", event.Type, err)
+	}
+	return &wireEvent{
+		ID:        event.ID,
+		Type:      event.Type,
+		Payload:   payload,
+		Timestamp: event.Timestamp,
+		Headers:   event.Headers,
+	}, nil
+}
+
+// event returns the Event with its payload still JSON; the receiving bus
+// decodes it into the registered payload type
+func (w *wireEvent) event() Event {
+	return Event{
+		ID:        w.ID,
+		Type:      w.Type,
+		Payload:   w.Payload,
+		Timestamp: w.Timestamp,
+		Headers:   w.Headers,
+	}
+}
+
+func writeFrame(conn net.Conn, frame tcpFrame) error {
+	data, err := json.Marshal(frame)
+	if err != nil {
+		return err
+	}
+	if len(data) > maxFrameSize {
+		return fmt.Errorf("frame of %d bytes exceeds the %d byte limit", len(data), maxFrameSize)
+	}
+	buf := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint32(buf, uint32(len(data)))
+	copy(buf[4:], data)
+	conn.SetWriteDeadline(time.Now().Add(tcpWriteTimeout))
+	_, err = conn.Write(buf)
+	return err
+}
+
+func readFrame(r io.Reader) (tcpFrame, error) {
+	var frame tcpFrame
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return frame, err
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n > maxFrameSize {
+		return frame, fmt.Errorf("frame of %d bytes exceeds the %d byte limit", n, maxFrameSize)
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return frame, err
+	}
+	if err := json.Unmarshal(data, &frame); err != nil {
+		return frame, fmt.Errorf("decoding frame: %w", err)
+	}
+	return frame, nil
+}
+
+// TCPHub relays events between buses connected with DialTCP
+type TCPHub struct {
+	listener net.Listener
+	mu       sync.Mutex
+	peers    map[string]*hubPeer
+	conns    map[net.Conn]struct{}
+	wg       sync.WaitGroup
+	// peerExpiry is how long the events for a disconnected peer are kept
+	// for it to reconnect
+	peerExpiry time.Duration
+}
+
+// hubPeer is a named bus and the events relayed to it that it has not
+// acknowledged yet. Frames for the peer are written by the writer of its
+// current connection, so relaying never waits on a slow peer.
+type hubPeer struct {
+	name    string
+	mu      sync.Mutex
+	conn    *hubConn
+	seq     uint64
+	pending []hubPending
+	// expiry drops the peer once it has been gone for peerExpiry;
+	// departures counts disconnections so a stale expiry does nothing
+	expiry     *time.Timer
+	departures uint64
+}
+
+type hubPending struct {
+	seq   uint64
+	event *wireEvent
+}
+
+// hubConn is one connection of a peer. Its writer sends the peer's pending
+// events it has not sent on this connection yet, and the acks queued for
+// it.
+type hubConn struct {
+	conn net.Conn
+	// sent is the highest sequence number written to conn
+	sent uint64
+	acks []uint64
+	wake chan struct{}
+	done chan struct{}
+}
+
+// ListenTCPHub starts a hub accepting buses on addr
+func ListenTCPHub(addr string) (*TCPHub, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	h := &TCPHub{
+		listener:   listener,
+		peers:      make(map[string]*hubPeer),
+		conns:      make(map[net.Conn]struct{}),
+		peerExpiry: tcpPeerExpiry,
+	}
+	h.wg.Add(1)
+	go h.accept()
+	return h, nil
+}
+
+// Addr is the address the hub listens on
+func (h *TCPHub) Addr() net.Addr {
+	return h.listener.Addr()
+}
+
+// Close stops accepting buses and disconnects those connected
+func (h *TCPHub) Close() error {
+	err := h.listener.Close()
+	h.mu.Lock()
+	for conn := range h.conns {
+		conn.Close()
+	}
+	for _, peer := range h.peers {
+		peer.mu.Lock()
+		if peer.expiry != nil {
+			peer.expiry.Stop()
+		}
+		peer.mu.Unlock()
+	}
+	h.mu.Unlock()
+	h.wg.Wait()
+	return err
+}
+
+func (h *TCPHub) accept() {
+	defer h.wg.Done()
+	for {
+		conn, err := h.listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("Hub stopped accepting connections: %v", err)
+			}
+			return
+		}
+		h.mu.Lock()
+		h.conns[conn] = struct{}{}
+		h.mu.Unlock()
+		h.wg.Add(1)
+		go h.serve(conn)
+	}
+}
+
+func (h *TCPHub) serve(conn net.Conn) {
+	defer h.wg.Done()
+	defer func() {
+		h.mu.Lock()
+		delete(h.conns, conn)
+		h.mu.Unlock()
+		conn.Close()
+	}()
+
+	reader := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(tcpDialTimeout))
+	hello, err := readFrame(reader)
+	if err != nil || hello.Kind != frameHello || hello.Name == "" {
+		log.Printf("Hub rejected %s: expected a hello frame", conn.RemoteAddr())
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	hc := &hubConn{conn: conn, wake: make(chan struct{}, 1), done: make(chan struct{})}
+	peer := h.connect(hello.Name, hc)
+	writerDone := make(chan struct{})
+	go func() {
+		defer close(writerDone)
+		peer.writer(hc)
+	}()
+	defer func() {
+		h.disconnect(peer, hc)
+		close(hc.done)
+		<-writerDone
+	}()
+	log.Printf("Hub: %s connected from %s", peer.name, conn.RemoteAddr())
+
+	for {
+		frame, err := readFrame(reader)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				log.Printf("Hub: %s disconnected: %v", peer.name, err)
+			}
+			return
+		}
+		switch frame.Kind {
+		case frameEvent:
+			if frame.Event == nil {
+				continue
+			}
+			h.relay(peer, frame.Event)
+			peer.queueAck(hc, frame.Seq)
+		case frameAck:
+			peer.ack(frame.Seq)
+		}
+	}
+}
+
+// connect attaches hc to the named peer, replacing any older connection;
+// the new connection's writer resends what the peer has not acknowledged
+func (h *TCPHub) connect(name string, hc *hubConn) *hubPeer {
+	h.mu.Lock()
+	peer, ok := h.peers[name]
+	if !ok {
+		peer = &hubPeer{name: name}
+		h.peers[name] = peer
+	}
+	h.mu.Unlock()
+
+	peer.mu.Lock()
+	defer peer.mu.Unlock()
+	if peer.expiry != nil {
+		peer.expiry.Stop()
+		peer.expiry = nil
+	}
+	if peer.conn != nil {
+		peer.conn.conn.Close()
+	}
+	peer.conn = hc
+	hc.signal()
+	return peer
+}
+
+// disconnect detaches hc from peer and, unless the peer has reconnected
+// already, drops the peer and its pending events if it stays away for
+// peerExpiry
+func (h *TCPHub) disconnect(peer *hubPeer, hc *hubConn) {
+	peer.mu.Lock()
+	defer peer.mu.Unlock()
+	if peer.conn != hc {
+		return
+	}
+	peer.conn = nil
+	peer.departures++
+	departure := peer.departures
+	peer.expiry = time.AfterFunc(h.peerExpiry, func() { h.expire(peer, departure) })
+}
+
+// expire forgets peer if it has stayed gone since the given departure
+func (h *TCPHub) expire(peer *hubPeer, departure uint64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	peer.mu.Lock()
+	defer peer.mu.Unlock()
+	if peer.departures != departure || peer.conn != nil {
+		return
+	}
+	if h.peers[peer.name] == peer {
+		delete(h.peers, peer.name)
+	}
+	if len(peer.pending) > 0 {
+		log.Printf("Hub: %s has been gone for %s, dropping %d undelivered event(s)", peer.name, h.peerExpiry, len(peer.pending))
+	}
+	peer.pending = nil
+}
+
+// relay queues event for every peer other than from
+func (h *TCPHub) relay(from *hubPeer, event *wireEvent) {
+	h.mu.Lock()
+	peers := make([]*hubPeer, 0, len(h.peers))
+	for _, peer := range h.peers {
+		if peer != from {
+			peers = append(peers, peer)
+		}
+	}
+	h.mu.Unlock()
+
+	for _, peer := range peers {
+		peer.mu.Lock()
+		peer.seq++
+		if len(peer.pending) >= tcpMaxPending {
+			log.Printf("Hub: %s is too far behind, dropping event %s", peer.name, peer.pending[0].event.ID)
+			peer.pending = peer.pending[1:]
+		}
+		peer.pending = append(peer.pending, hubPending{seq: peer.seq, event: event})
+		if peer.conn != nil {
+			peer.conn.signal()
+		}
+		peer.mu.Unlock()
+	}
+}
+
+// signal wakes the connection's writer
+func (c *hubConn) signal() {
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+// queueAck has the writer of hc acknowledge seq
+func (p *hubPeer) queueAck(hc *hubConn, seq uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conn != hc {
+		return
+	}
+	hc.acks = append(hc.acks, seq)
+	hc.signal()
+}
+
+// writer writes the frames due on hc until it is closed or fails. A write
+// error closes the connection; the reader notices, and the events stay
+// pending until the peer reconnects.
+func (p *hubPeer) writer(hc *hubConn) {
+	for {
+		select {
+		case <-hc.wake:
+		case <-hc.done:
+			return
+		}
+		p.mu.Lock()
+		if p.conn != hc {
+			p.mu.Unlock()
+			return
+		}
+		frames := make([]tcpFrame, 0, len(hc.acks))
+		for _, seq := range hc.acks {
+			frames = append(frames, tcpFrame{Kind: frameAck, Seq: seq})
+		}
+		hc.acks = nil
+		for _, pending := range p.pending {
+			if pending.seq > hc.sent {
+				frames = append(frames, tcpFrame{Kind: frameEvent, Seq: pending.seq, Event: pending.event})
+				hc.sent = pending.seq
+			}
+		}
+		p.mu.Unlock()
+
+		for _, frame := range frames {
+			if err := writeFrame(hc.conn, frame); err != nil {
+				hc.conn.Close()
+				return
+			}
+		}
+	}
+}
+
+func (p *hubPeer) ack(seq uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, pending := range p.pending {
+		if pending.seq == seq {
+			p.pending = append(p.pending[:i], p.pending[i+1:]...)
+			return
+		}
+	}
+}
+
+// TCPTransport connects a bus to a TCPHub, reconnecting whenever the
+// connection drops
+type TCPTransport struct {
+	addr string
+	name string
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	// writeMu serialises writes to conn; mu guards the rest
+	writeMu sync.Mutex
+	mu      sync.Mutex
+	conn    net.Conn
+	seq     uint64
+	outbox  []hubPending
+	// emptied is closed whenever the outbox becomes empty
+	emptied chan struct{}
+
+	receive func(context.Context, Event) error
+	seen    *recentIDs
+}
+
+// DialTCP returns a transport that connects to the hub at addr as name.
+// The name identifies the bus to the hub across reconnections, so it must
+// be unique among the buses sharing the hub.
+func DialTCP(addr, name string) *TCPTransport {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &TCPTransport{
+		addr:    addr,
+		name:    name,
+		ctx:     ctx,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+		emptied: closedChan(),
+		seen:    newRecentIDs(tcpSeenIDs),
+	}
+}
+
+func closedChan() chan struct{} {
+	c := make(chan struct{})
+	close(c)
+	return c
+}
+
+// Start connects to the hub in the background
+func (t *TCPTransport) Start(receive func(context.Context, Event) error) {
+	t.receive = receive
+	go t.run()
+}
+
+func (t *TCPTransport) run() {
+	defer close(t.done)
+	failures := 0
+	for t.ctx.Err() == nil {
+		dialer := net.Dialer{Timeout: tcpDialTimeout}
+		conn, err := dialer.DialContext(t.ctx, "tcp", t.addr)
+		if err != nil {
+			failures++
+			if failures == 1 {
+				log.Printf("Transport %s cannot reach hub %s, retrying: %v", t.name, t.addr, err)
+			}
+			sleepContext(t.ctx, SystemClock{}, tcpReconnectPolicy.Backoff(failures))
+			continue
+		}
+		failures = 0
+		log.Printf("Transport %s connected to hub %s", t.name, t.addr)
+		t.serve(conn)
+	}
+}
+
+// serve introduces the bus, resends unacknowledged events and then reads
+// from conn until it fails
+func (t *TCPTransport) serve(conn net.Conn) {
+	defer conn.Close()
+	stop := context.AfterFunc(t.ctx, func() { conn.Close() })
+	defer stop()
+
+	t.writeMu.Lock()
+	err := writeFrame(conn, tcpFrame{Kind: frameHello, Name: t.name})
+	t.mu.Lock()
+	t.conn = conn
+	outbox := append([]hubPending(nil), t.outbox...)
+	t.mu.Unlock()
+	for _, p := range outbox {
+		if err != nil {
+			break
+		}
+		err = writeFrame(conn, tcpFrame{Kind: frameEvent, Seq: p.seq, Event: p.event})
+	}
+	t.writeMu.Unlock()
+	defer func() {
+		t.mu.Lock()
+		if t.conn == conn {
+			t.conn = nil
+		}
+		t.mu.Unlock()
+	}()
+	if err != nil {
+		return
+	}
+
+	reader := bufio.NewReader(conn)
+	for {
+		frame, err := readFrame(reader)
+		if err != nil {
+			if t.ctx.Err() == nil {
+				log.Printf("Transport %s lost hub %s: %v", t.name, t.addr, err)
+			}
+			return
+		}
+		switch frame.Kind {
+		case frameAck:
+			t.ack(frame.Seq)
+		case frameEvent:
+			if frame.Event == nil {
+				continue
+			}
+			if !t.seen.contains(frame.Event.ID) {
+				ctx, cancel := context.WithTimeout(t.ctx, tcpReceiveTimeout)
+				err := t.receive(ctx, frame.Event.event())
+				cancel()
+				if err != nil {
+					// Left unacknowledged, the hub sends it again on
+					// the next connection
+					log.Printf("Transport %s could not accept event %s: %v", t.name, frame.Event.ID, err)
+					continue
+				}
+				t.seen.add(frame.Event.ID)
+			}
+			t.write(conn, tcpFrame{Kind: frameAck, Seq: frame.Seq})
+		}
+	}
+}
+
+// Send queues the event until the hub acknowledges it and writes it at once
+// if the hub is connected
+func (t *TCPTransport) Send(ctx context.Context, event Event) error {
+	wire, err := toWire(event)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	if t.ctx.Err() != nil {
+		t.mu.Unlock()
+		return ErrTransportClosed
+	}
+	if len(t.outbox) >= tcpMaxPending {
+		t.mu.Unlock()
+		return ErrTransportBacklog
+	}
+	t.seq++
+	pending := hubPending{seq: t.seq, event: wire}
+	if len(t.outbox) == 0 {
+		t.emptied = make(chan struct{})
+	}
+	t.outbox = append(t.outbox, pending)
+	conn := t.conn
+	t.mu.Unlock()
+
+	if conn != nil {
+		t.write(conn, tcpFrame{Kind: frameEvent, Seq: pending.seq, Event: wire})
+	}
+	return nil
+}
+
+func (t *TCPTransport) write(conn net.Conn, frame tcpFrame) {
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+	if err := writeFrame(conn, frame); err != nil {
+		// The reader notices and reconnects
+		conn.Close()
+	}
+}
+
+func (t *TCPTransport) ack(seq uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, pending := range t.outbox {
+		if pending.seq == seq {
+			t.outbox = append(t.outbox[:i], t.outbox[i+1:]...)
+			if len(t.outbox) == 0 {
+				close(t.emptied)
+			}
+			return
+		}
+	}
+}
+
+// Close disconnects from the hub after waiting up to tcpCloseTimeout for
+// it to acknowledge the events sent so far; any still unacknowledged are
+// dropped.
+func (t *TCPTransport) Close() error {
+	t.mu.Lock()
+	emptied := t.emptied
+	t.mu.Unlock()
+	timer := time.NewTimer(tcpCloseTimeout)
+	select {
+	case <-emptied:
+	case <-timer.C:
+	}
+	timer.Stop()
+
+	t.cancel()
+	if t.receive != nil {
+		<-t.done
+	}
+	t.mu.Lock()
+	unacked := len(t.outbox)
+	t.mu.Unlock()
+	if unacked > 0 {
+		log.Printf("Transport %s closed with %d unacknowledged event(s)", t.name, unacked)
+	}
+	return nil
+}
+
+// recentIDs remembers the last size IDs added
+type recentIDs struct {
+	mu    sync.Mutex
+	ids   map[string]struct{}
+	order []string
+	next  int
+}
+
+func newRecentIDs(size int) *recentIDs {
+	return &recentIDs{ids: make(map[string]struct{}, size), order: make([]string, size)}
+}
+
+func (r *recentIDs) contains(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.ids[id]
+	return ok
+}
+
+func (r *recentIDs) add(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.ids[id]; ok {
+		return
+	}
+	delete(r.ids, r.order[r.next])
+	r.order[r.next] = id
+	r.ids[id] = struct{}{}
+	r.next = (r.next + 1) % len(r.order)
+}
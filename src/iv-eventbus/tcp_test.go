@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startHub runs a hub on a free local port until the test ends
+func startHub(t *testing.T) *TCPHub {
+	t.Helper()
+	hub, err := ListenTCPHub("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { hub.Close() })
+	return hub
+}
+
+// hubClient is a raw connection to a hub under name
+type hubClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func dialHub(t *testing.T, hub *TCPHub, name string) *hubClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", hub.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	if err := writeFrame(conn, tcpFrame{Kind: frameHello, Name: name}); err != nil {
+		t.Fatal(err)
+	}
+	return &hubClient{conn: conn, reader: bufio.NewReader(conn)}
+}
+
+// awaitPeers waits until the hub knows exactly names
+func awaitPeers(t *testing.T, hub *TCPHub, names ...string) {
+	t.Helper()
+	deadline := time.Now().Add(awaitTimeout)
+	for {
+		hub.mu.Lock()
+		known := make([]string, 0, len(hub.peers))
+		for name := range hub.peers {
+			known = append(known, name)
+		}
+		hub.mu.Unlock()
+		if len(known) == len(names) {
+			match := true
+			for _, name := range names {
+				if _, ok := hub.peers[name]; !ok {
+					match = false
+				}
+			}
+			if match {
+				return
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("want peers %v, hub has %v", names, known)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestHubSlowPeerDoesNotBlockOthers(t *testing.T) {
+	hub := startHub(t)
+	// slow never reads, so its socket buffers fill up
+	dialHub(t, hub, "slow")
+	fast := dialHub(t, hub, "fast")
+	sender := dialHub(t, hub, "sender")
+	awaitPeers(t, hub, "slow", "fast", "sender")
+
+	const events = 200
+	payload, _ := json.Marshal(strings.Repeat("x", 64<<10))
+	go func() {
+		for i := 1; i <= events; i++ {
+			event := &wireEvent{ID: fmt.Sprintf("e%d", i), Type: "test.event", Payload: payload}
+			if err := writeFrame(sender.conn, tcpFrame{Kind: frameEvent, Seq: uint64(i), Event: event}); err != nil {
+				return
+			}
+		}
+	}()
+	go func() {
+		// Drain the sender's acks so it is never the one held up
+		for {
+			if _, err := readFrame(sender.reader); err != nil {
+				return
+			}
+		}
+	}()
+
+	fast.conn.SetReadDeadline(time.Now().Add(awaitTimeout))
+	for received := 0; received < events; {
+		frame, err := readFrame(fast.reader)
+		if err != nil {
+			t.Fatalf("fast peer got %d of %d events: %v", received, events, err)
+		}
+		if frame.Kind == frameEvent {
+			received++
+			if err := writeFrame(fast.conn, tcpFrame{Kind: frameAck, Seq: frame.Seq}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestHubForgetsDepartedPeer(t *testing.T) {
+	hub := startHub(t)
+	hub.peerExpiry = 20 * time.Millisecond
+	gone := dialHub(t, hub, "gone")
+	sender := dialHub(t, hub, "sender")
+	awaitPeers(t, hub, "gone", "sender")
+
+	gone.conn.Close()
+	event := &wireEvent{ID: "e1", Type: "test.event", Payload: json.RawMessage(`{}`)}
+	if err := writeFrame(sender.conn, tcpFrame{Kind: frameEvent, Seq: 1, Event: event}); err != nil {
+		t.Fatal(err)
+	}
+	awaitPeers(t, hub, "sender")
+
+	// A bus reconnecting under the same name later starts afresh
+	back := dialHub(t, hub, "gone")
+	awaitPeers(t, hub, "gone", "sender")
+	back.conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if frame, err := readFrame(back.reader); err == nil {
+		t.Fatalf("want the expired event dropped, got %+v", frame)
+	}
+}
+
+func TestReceiveLeavesEventWhenPoolIsFull(t *testing.T) {
+	eb, _ := NewRecordingBus(t, WithWorkerPool(PoolConfig{Partitions: 1, QueueSize: 1, Overflow: OverflowBlock}))
+	started := make(chan string, 3)
+	release := make(chan struct{})
+	if _, err := Subscribe(eb, func(ctx context.Context, e ReservationExpired) error {
+		started <- e.TicketID
+		<-release
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	expired := func(ticketID string) Event {
+		return Event{ID: ticketID, Type: EventTypeOf[ReservationExpired](), Payload: ReservationExpired{TicketID: ticketID}}
+	}
+
+	// One delivery runs and one waits in the queue, so the pool is full
+	ctx := context.Background()
+	if err := eb.Publish(ctx, expired("t1")); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	if err := eb.Publish(ctx, expired("t2")); err != nil {
+		t.Fatal(err)
+	}
+
+	short, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if err := eb.receive(short, expired("t3")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("want the received event refused once the wait ends, got %v", err)
+	}
+	if letters, err := eb.DeadLetters(); err != nil || len(letters) != 0 {
+		t.Fatalf("want the refused event left for the transport, got %v, %v", letters, err)
+	}
+
+	close(release)
+	long, cancel := context.WithTimeout(ctx, awaitTimeout)
+	defer cancel()
+	if err := eb.receive(long, expired("t3")); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"t2", "t3"} {
+		if got := <-started; got != want {
+			t.Fatalf("want %s delivered, got %s", want, got)
+		}
+	}
+}
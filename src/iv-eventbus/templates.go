@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// DefaultLocale is the locale templates fall back to
+const DefaultLocale = "en"
+
+// ErrNoTemplate is returned when no template exists for an event type in
+// the requested locale, its language or the fallback locale
+var ErrNoTemplate = errors.New("no notification template")
+
+// Templates holds a subject and body text/template per event type and
+// locale. A locale such as "de-AT" falls back to its language, "de", and
+// then to the fallback locale.
+type Templates struct {
+	fallback string
+
+	mu    sync.RWMutex
+	byKey map[templateKey]*messageTemplate
+}
+
+type templateKey struct {
+	eventType string
+	locale    string
+}
+
+type messageTemplate struct {
+	subject *template.Template
+	body    *template.Template
+}
+
+// NewTemplates creates an empty set of templates falling back to
+// fallback, or to DefaultLocale if fallback is empty
+func NewTemplates(fallback string) *Templates {
+	if fallback == "" {
+		fallback = DefaultLocale
+	}
+	return &Templates{fallback: normalizeLocale(fallback), byKey: make(map[templateKey]*messageTemplate)}
+}
+
+// Add parses and registers the subject and body templates for eventType in
+// locale, replacing any already registered
+func (t *Templates) Add(eventType, locale, subject, body string) error {
+	name := eventType + "/" + normalizeLocale(locale)
+	subjectTmpl, err := template.New(name + "/subject").Option("missingkey=error").Parse(subject)
+	if err != nil {
+		return err
+	}
+	bodyTmpl, err := template.New(name + "/body").Option("missingkey=error").Parse(body)
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.byKey[templateKey{eventType, normalizeLocale(locale)}] = &messageTemplate{subject: subjectTmpl, body: bodyTmpl}
+	return nil
+}
+
+// Render executes the templates for eventType in locale with data and
+// returns the subject, the body and the locale actually used
+func (t *Templates) Render(eventType, locale string, data interface{}) (subject, body, used string, err error) {
+	tmpl, used, ok := t.lookup(eventType, locale)
+	if !ok {
+		return "", "", "", fmt.Errorf("%w for %s in %q", ErrNoTemplate, eventType, locale)
+	}
+	var sb strings.Builder
+	if err := tmpl.subject.Execute(&sb, data); err != nil {
+		return "", "", "", err
+	}
+	subject = strings.TrimSpace(sb.String())
+	sb.Reset()
+	if err := tmpl.body.Execute(&sb, data); err != nil {
+		return "", "", "", err
+	}
+	return subject, sb.String(), used, nil
+}
+
+func (t *Templates) lookup(eventType, locale string) (*messageTemplate, string, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	locale = normalizeLocale(locale)
+	candidates := []string{locale}
+	if language, _, found := strings.Cut(locale, "-"); found {
+		candidates = append(candidates, language)
+	}
+	candidates = append(candidates, t.fallback)
+	for _, candidate := range candidates {
+		if tmpl, ok := t.byKey[templateKey{eventType, candidate}]; ok {
+			return tmpl, candidate, true
+		}
+	}
+	return nil, "", false
+}
+
+// normalizeLocale turns "pt_BR" and "PT-br" into "pt-br"
+func normalizeLocale(locale string) string {
+	return strings.ToLower(strings.ReplaceAll(locale, "_", "-"))
+}
+
+// DefaultTemplates returns English and German templates for the events
+// NotificationService sends, executed with NotificationData
+func DefaultTemplates() *Templates {
+	t := NewTemplates(DefaultLocale)
+	for _, def := range []struct {
+		eventType, locale, subject, body string
+	}{
+		{EventTypeOf[ConcertAdded](), "en",
+			`New concert: {{.Concert.Name}}`,
+			"Hi {{.Customer.Name}},\n\n{{.Concert.Name}} plays {{.Concert.Venue}} on {{.Concert.Date.Format \"Mon 2 Jan 2006 at 15:04\"}}. Tickets cost {{printf \"%.2f\" .Concert.TicketPrice}}.\n"},
+		{EventTypeOf[ConcertAdded](), "de",
+			`Neues Konzert: {{.Concert.Name}}`,
+			"Hallo {{.Customer.Name}},\n\n{{.Concert.Name}} spielt am {{.Concert.Date.Format \"02.01.2006 um 15:04\"}} in {{.Concert.Venue}}. Karten kosten {{printf \"%.2f\" .Concert.TicketPrice}}.\n"},
+		{EventTypeOf[TicketPurchased](), "en",
+			`Your ticket for {{or .Concert.Name "your concert"}}`,
+			"Hi {{.Customer.Name}},\n\nyour ticket {{.Ticket.ID}} for {{or .Concert.Name .Ticket.ConcertID}}{{if not .Concert.Date.IsZero}} on {{.Concert.Date.Format \"Mon 2 Jan 2006 at 15:04\"}}{{end}} is confirmed.\n"},
+		{EventTypeOf[TicketPurchased](), "de",
+			`Deine Karte für {{or .Concert.Name "dein Konzert"}}`,
+			"Hallo {{.Customer.Name}},\n\ndeine Karte {{.Ticket.ID}} für {{or .Concert.Name .Ticket.ConcertID}}{{if not .Concert.Date.IsZero}} am {{.Concert.Date.Format \"02.01.2006 um 15:04\"}}{{end}} ist bestätigt.\n"},
+		{EventTypeOf[ConcertReminder](), "en",
+			`{{.Concert.Name}} is tomorrow`,
+			"Hi {{.Customer.Name}},\n\na reminder that {{.Concert.Name}} starts {{.Concert.Date.Format \"Mon 2 Jan 2006 at 15:04\"}}.\n"},
+		{EventTypeOf[ConcertReminder](), "de",
+			`{{.Concert.Name}} ist morgen`,
+			"Hallo {{.Customer.Name}},\n\nzur Erinnerung: {{.Concert.Name}} beginnt am {{.Concert.Date.Format \"02.01.2006 um 15:04\"}}.\n"},
+	} {
+		if err := t.Add(def.eventType, def.locale, def.subject, def.body); err != nil {
+			panic(fmt.Sprintf("default template %s/%s: %v", def.eventType, def.locale, err))
+		}
+	}
+	return t
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Topic patterns are dot-separated event types such as "ticket.purchased".
+// In a pattern, "*" matches exactly one segment and "#" matches zero or more
+// segments, so "ticket.#" matches "ticket", "ticket.purchased" and
+// "ticket.purchase.failed".
+const (
+	topicSeparator  = "."
+	wildcardSegment = "*"
+	wildcardTail    = "#"
+)
+
+// isWildcardPattern reports whether pattern contains wildcard segments
+func isWildcardPattern(pattern string) bool {
+	for _, segment := range strings.Split(pattern, topicSeparator) {
+		if segment == wildcardSegment || segment == wildcardTail {
+			return true
+		}
+	}
+	return false
+}
+
+// validatePattern rejects empty segments and wildcards mixed into a segment
+func validatePattern(pattern string) error {
+	for _, segment := range strings.Split(pattern, topicSeparator) {
+		if segment == "" {
+			return fmt.Errorf("invalid topic pattern %q: empty segment", pattern)
+		}
+		if segment != wildcardSegment && segment != wildcardTail && strings.ContainsAny(segment, wildcardSegment+wildcardTail) {
+			return fmt.Errorf("invalid topic pattern %q: wildcards must be whole segments", pattern)
+		}
+	}
+	return nil
+}
+
+// topicMatches reports whether the event type topic matches pattern
+func topicMatches(pattern, topic string) bool {
+	return matchSegments(strings.Split(pattern, topicSeparator), strings.Split(topic, topicSeparator))
+}
+
+func matchSegments(pattern, topic []string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case wildcardTail:
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(topic); i++ {
+				if matchSegments(pattern[1:], topic[i:]) {
+					return true
+				}
+			}
+			return false
+		case wildcardSegment:
+			if len(topic) == 0 {
+				return false
+			}
+		default:
+			if len(topic) == 0 || pattern[0] != topic[0] {
+				return false
+			}
+		}
+		pattern, topic = pattern[1:], topic[1:]
+	}
+	return len(topic) == 0
+}
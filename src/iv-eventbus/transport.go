@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// Transport carries events between event buses, typically in different
+// processes. The bus hands every event published locally to Send and
+// dispatches every event the transport receives to its own subscribers
+// without sending it on again.
+type Transport interface {
+	// Start begins delivering remote events to receive. An event is
+	// acknowledged to its sender only once receive returns nil; ctx bounds
+	// how long receive may wait for the subscribers to take it.
+	Start(receive func(ctx context.Context, event Event) error)
+	// Send forwards a locally published event to the other buses
+	Send(ctx context.Context, event Event) error
+	// Close stops the transport
+	Close() error
+}
+
+// WithTransport connects the bus to other buses through t. A request's
+// responder may then live on another bus, so Request no longer checks
+// for a local responder and a missing one surfaces as ErrRequestTimeout.
+func WithTransport(t Transport) BusOption {
+	return func(eb *EventBus) {
+		eb.transport = t
+	}
+}
+
+// receive dispatches an event from the transport to local subscribers.
+// Payloads that arrive as JSON are upcast and decoded into their
+// registered types. It fails if a subscriber could not take the event
+// before ctx ended, so that the transport delivers it again.
+func (eb *EventBus) receive(ctx context.Context, event Event) error {
+	event, err := eb.decodeEvent(event)
+	if err != nil {
+		return err
+	}
+	// A handler sending the event from another bus is not delivering on
+	// this one
+	ctx = context.WithValue(ctx, eventKey{}, nil)
+	_, err = eb.publish(ctx, event, true, false)
+	return err
+}
+
+// LocalHub connects event buses within one process, for services that run
+// on separate buses without a network in between
+type LocalHub struct {
+	mu      sync.RWMutex
+	members []*localTransport
+}
+
+// NewLocalHub creates a hub with no buses attached
+func NewLocalHub() *LocalHub {
+	return &LocalHub{}
+}
+
+// Transport returns a new Transport attached to the hub
+func (h *LocalHub) Transport() Transport {
+	t := &localTransport{hub: h}
+	h.mu.Lock()
+	h.members = append(h.members, t)
+	h.mu.Unlock()
+	return t
+}
+
+type localTransport struct {
+	hub     *LocalHub
+	mu      sync.RWMutex
+	receive func(context.Context, Event) error
+}
+
+func (t *localTransport) Start(receive func(context.Context, Event) error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.receive = receive
+}
+
+// Send hands the event to every other bus on the hub in turn, returning
+// their errors joined
+func (t *localTransport) Send(ctx context.Context, event Event) error {
+	t.hub.mu.RLock()
+	members := append([]*localTransport(nil), t.hub.members...)
+	t.hub.mu.RUnlock()
+
+	var errs []error
+	for _, member := range members {
+		if member == t {
+			continue
+		}
+		member.mu.RLock()
+		receive := member.receive
+		member.mu.RUnlock()
+		if receive == nil {
+			continue
+		}
+		if err := receive(ctx, event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (t *localTransport) Close() error {
+	t.hub.mu.Lock()
+	defer t.hub.mu.Unlock()
+	for i, member := range t.hub.members {
+		if member == t {
+			t.hub.members = append(t.hub.members[:i:i], t.hub.members[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/google/uuid"
+)
+
+// ErrPayloadType is returned when an event's payload is not the type
+// registered for its event type
+var ErrPayloadType = errors.New("payload type mismatch")
+
+// EventTyper lets a payload type choose its event type name; payload types
+// without it are published under their Go type name
+type EventTyper interface {
+	EventType() string
+}
+
+// EventTypeOf returns the event type used for payloads of type T
+func EventTypeOf[T any]() string {
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Interface {
+		if typer, ok := reflect.New(t).Interface().(EventTyper); ok {
+			return typer.EventType()
+		}
+	}
+	return t.Name()
+}
+
+// Subscribe registers a handler for payloads of type T under the event type
+// derived from T. It fails if another payload type is already registered
+// for that event type. Events whose payload is not a T reach the handler's
+// subscription as an ErrPayloadType error instead of a panic.
+func Subscribe[T any](eb *EventBus, handler func(context.Context, T) error, opts ...SubscribeOption) (*Subscription, error) {
+	eventType := EventTypeOf[T]()
+	if err := RegisterPayload[T](eb); err != nil {
+		return nil, err
+	}
+	return eb.Subscribe(eventType, func(ctx context.Context, event Event) error {
+		payload, ok := event.Payload.(T)
+		if !ok {
+			return payloadTypeError(event.Type, reflect.TypeOf((*T)(nil)).Elem(), event.Payload)
+		}
+		return handler(ctx, payload)
+	}, opts...)
+}
+
+// Publish sends payload as a new event of the type derived from T
+func Publish[T any](ctx context.Context, eb *EventBus, payload T) error {
+	eventType := EventTypeOf[T]()
+	if err := RegisterPayload[T](eb); err != nil {
+		return err
+	}
+	return eb.Publish(ctx, Event{
+		ID:        uuid.New().String(),
+		Type:      eventType,
+		Payload:   payload,
+		Timestamp: eb.Now(),
+	})
+}
+
+// RegisterPayload declares T as the payload type of the event type derived
+// from T, so journaled events of that type can be decoded
+func RegisterPayload[T any](eb *EventBus) error {
+	return eb.RegisterPayloadType(EventTypeOf[T](), reflect.TypeOf((*T)(nil)).Elem())
+}
+
+// RegisterPayloadType declares the payload type carried by eventType. Once
+// registered, publishing a different payload type for it is rejected.
+func (eb *EventBus) RegisterPayloadType(eventType string, t reflect.Type) error {
+	if eventType == "" {
+		return fmt.Errorf("%w: cannot derive an event type from %s", ErrPayloadType, t)
+	}
+	if isWildcardPattern(eventType) {
+		return fmt.Errorf("%w: %s is a topic pattern, not an event type", ErrPayloadType, eventType)
+	}
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+	if existing, ok := eb.payloadTypes[eventType]; ok && existing != t {
+		return fmt.Errorf("%w: %s is registered with %s, not %s", ErrPayloadType, eventType, existing, t)
+	}
+	eb.payloadTypes[eventType] = t
+	return nil
+}
+
+// checkPayload reports an error if event's payload does not match the type
+// registered for its event type; eb.mu must be held
+func (eb *EventBus) checkPayload(event Event) error {
+	t, ok := eb.payloadTypes[event.Type]
+	if !ok || reflect.TypeOf(event.Payload) == t {
+		return nil
+	}
+	return payloadTypeError(event.Type, t, event.Payload)
+}
+
+func payloadTypeError(eventType string, want reflect.Type, payload interface{}) error {
+	return fmt.Errorf("%w: %s expects %s, got %T", ErrPayloadType, eventType, want, payload)
+}
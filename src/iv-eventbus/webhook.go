@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Headers sent with every webhook request. The signature is
+// "sha256=" followed by the hex HMAC-SHA256, keyed with the webhook's
+// secret, of the timestamp header, a ".", and the request body; receivers
+// should recompute it and reject stale timestamps.
+const (
+	WebhookHeaderEventID   = "X-Event-ID"
+	WebhookHeaderEventType = "X-Event-Type"
+	WebhookHeaderTimestamp = "X-Webhook-Timestamp"
+	WebhookHeaderSignature = "X-Webhook-Signature"
+)
+
+// webhookLogSize bounds the delivery log kept by a WebhookDispatcher
+const webhookLogSize = 1000
+
+// ErrWebhookNotFound is returned for an unknown webhook ID
+var ErrWebhookNotFound = errors.New("webhook not found")
+
+// Webhook is an HTTP endpoint that receives the events matching Pattern
+type Webhook struct {
+	ID        string
+	Pattern   string
+	URL       string
+	CreatedAt time.Time
+
+	secret       []byte
+	retry        RetryPolicy
+	subscription *Subscription
+}
+
+// WebhookOption configures a webhook
+type WebhookOption func(*Webhook)
+
+// WithWebhookRetry retries failed deliveries according to policy instead of
+// DefaultRetryPolicy
+func WithWebhookRetry(policy RetryPolicy) WebhookOption {
+	return func(w *Webhook) {
+		w.retry = policy
+	}
+}
+
+// WebhookDelivery records one attempt to deliver an event to a webhook
+type WebhookDelivery struct {
+	WebhookID  string        `json:"webhook_id"`
+	EventID    string        `json:"event_id"`
+	EventType  string        `json:"event_type"`
+	Attempt    int           `json:"attempt"`
+	StatusCode int           `json:"status_code,omitempty"`
+	Error      string        `json:"error,omitempty"`
+	Permanent  bool          `json:"permanent,omitempty"`
+	Duration   time.Duration `json:"duration_ns"`
+	At         time.Time     `json:"at"`
+}
+
+// Succeeded reports whether the endpoint accepted the event
+func (d WebhookDelivery) Succeeded() bool {
+	return d.Error == ""
+}
+
+// webhookBody is the JSON document posted for an event
+type webhookBody struct {
+	ID        string            `json:"id"`
+	Type      string            `json:"type"`
+	Timestamp time.Time         `json:"timestamp"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	Payload   interface{}       `json:"payload"`
+}
+
+// WebhookDispatcher posts events to webhooks registered at runtime. Each
+// webhook is a subscription on the bus, so failed deliveries are retried
+// with backoff and end up as dead letters like any other handler's. A
+// client error other than 408 or 429 is dead-lettered without retrying.
+type WebhookDispatcher struct {
+	eventBus *EventBus
+	client   *http.Client
+
+	mu       sync.RWMutex
+	webhooks map[string]*Webhook
+
+	logMu      sync.Mutex
+	deliveries []WebhookDelivery
+}
+
+// NewWebhookDispatcher creates a dispatcher posting with client, or with a
+// client with a 10 second timeout if client is nil
+func NewWebhookDispatcher(eb *EventBus, client *http.Client) *WebhookDispatcher {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &WebhookDispatcher{
+		eventBus: eb,
+		client:   client,
+		webhooks: make(map[string]*Webhook),
+	}
+}
+
+// Register starts posting events matching pattern to endpoint, signed with
+// secret
+func (d *WebhookDispatcher) Register(pattern, endpoint, secret string, opts ...WebhookOption) (Webhook, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return Webhook{}, fmt.Errorf("webhook URL %q must be an absolute http or https URL", endpoint)
+	}
+	if secret == "" {
+		return Webhook{}, errors.New("webhook secret must not be empty")
+	}
+	w := &Webhook{
+		ID:        uuid.New().String(),
+		Pattern:   pattern,
+		URL:       endpoint,
+		CreatedAt: time.Now(),
+		secret:    []byte(secret),
+		retry:     DefaultRetryPolicy,
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+
+	sub, err := d.eventBus.Subscribe(pattern, func(ctx context.Context, event Event) error {
+		return d.deliver(ctx, w, event)
+	}, WithName("webhook "+endpoint), WithRetry(w.retry))
+	if err != nil {
+		return Webhook{}, err
+	}
+	w.subscription = sub
+
+	d.mu.Lock()
+	d.webhooks[w.ID] = w
+	d.mu.Unlock()
+	return *w, nil
+}
+
+// Unregister stops posting to the webhook; deliveries in progress finish
+func (d *WebhookDispatcher) Unregister(id string) error {
+	d.mu.Lock()
+	w, ok := d.webhooks[id]
+	delete(d.webhooks, id)
+	d.mu.Unlock()
+	if !ok {
+		return ErrWebhookNotFound
+	}
+	w.subscription.Unsubscribe()
+	return nil
+}
+
+// Webhooks lists the registered webhooks, oldest first
+func (d *WebhookDispatcher) Webhooks() []Webhook {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	webhooks := make([]Webhook, 0, len(d.webhooks))
+	for _, w := range d.webhooks {
+		webhooks = append(webhooks, *w)
+	}
+	sort.Slice(webhooks, func(i, j int) bool { return webhooks[i].CreatedAt.Before(webhooks[j].CreatedAt) })
+	return webhooks
+}
+
+// Deliveries returns the logged delivery attempts for a webhook, or for
+// every webhook if id is "", oldest first. Only the most recent attempts
+// are kept.
+func (d *WebhookDispatcher) Deliveries(id string) []WebhookDelivery {
+	d.logMu.Lock()
+	defer d.logMu.Unlock()
+	var deliveries []WebhookDelivery
+	for _, delivery := range d.deliveries {
+		if id == "" || delivery.WebhookID == id {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	return deliveries
+}
+
+// deliver posts event to w once and logs the attempt
+func (d *WebhookDispatcher) deliver(ctx context.Context, w *Webhook, event Event) error {
+	body, err := json.Marshal(webhookBody{
+		ID:        event.ID,
+		Type:      event.Type,
+		Timestamp: event.Timestamp,
+		Headers:   event.Headers,
+		Payload:   event.Payload,
+	})
+	if err != nil {
+		return fmt.Errorf("encoding %s for webhook: %w", event.Type, err)
+	}
+
+	start := time.Now()
+	status, err := d.post(ctx, w, event, body)
+	delivery := WebhookDelivery{
+		WebhookID:  w.ID,
+		EventID:    event.ID,
+		EventType:  event.Type,
+		StatusCode: status,
+		Duration:   time.Since(start),
+		At:         start,
+	}
+	if err != nil {
+		delivery.Error = err.Error()
+		delivery.Permanent = IsPermanent(err)
+	}
+	d.record(delivery)
+	return err
+}
+
+func (d *WebhookDispatcher) post(ctx context.Context, w *Webhook, event Event, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookHeaderEventID, event.ID)
+	req.Header.Set(WebhookHeaderEventType, event.Type)
+	req.Header.Set(WebhookHeaderTimestamp, timestamp)
+	req.Header.Set(WebhookHeaderSignature, SignWebhook(w.secret, timestamp, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		err := fmt.Errorf("webhook %s answered %s", w.URL, resp.Status)
+		if !retryableStatus(resp.StatusCode) {
+			err = Permanent(err)
+		}
+		return resp.StatusCode, err
+	}
+	return resp.StatusCode, nil
+}
+
+// retryableStatus reports whether a failed delivery answered with code may
+// succeed later. Client errors other than a timeout or rate limit mean the
+// endpoint rejects the event itself, so sending it again cannot help.
+func retryableStatus(code int) bool {
+	if code >= 400 && code < 500 {
+		return code == http.StatusRequestTimeout || code == http.StatusTooManyRequests
+	}
+	return true
+}
+
+// record appends to the delivery log, numbering the attempt among those
+// logged for the same webhook and event
+func (d *WebhookDispatcher) record(delivery WebhookDelivery) {
+	d.logMu.Lock()
+	defer d.logMu.Unlock()
+	delivery.Attempt = 1
+	for _, previous := range d.deliveries {
+		if previous.WebhookID == delivery.WebhookID && previous.EventID == delivery.EventID {
+			delivery.Attempt++
+		}
+	}
+	if len(d.deliveries) >= webhookLogSize {
+		d.deliveries = append(d.deliveries[:0:0], d.deliveries[1:]...)
+	}
+	d.deliveries = append(d.deliveries, delivery)
+}
+
+// SignWebhook returns the signature header value for a webhook body
+func SignWebhook(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook reports whether signature is valid for the body, for
+// receivers of webhooks sent by a WebhookDispatcher
+func VerifyWebhook(secret []byte, timestamp string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(SignWebhook(secret, timestamp, body)), []byte(signature))
+}
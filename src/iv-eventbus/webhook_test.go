@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// quickRetry keeps webhook retries fast in tests
+var quickRetry = RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+
+// webhookServer answers each request with the next status in statuses,
+// repeating the last one, and hands every request to inspect
+func webhookServer(t *testing.T, inspect func(*http.Request, []byte), statuses ...int) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		if inspect != nil {
+			inspect(r, body)
+		}
+		n := int(calls.Add(1))
+		w.WriteHeader(statuses[min(n, len(statuses))-1])
+	}))
+	t.Cleanup(server.Close)
+	return server, &calls
+}
+
+// publishToWebhook registers a webhook at url, publishes one ConcertAdded
+// and waits for every delivery attempt to finish
+func publishToWebhook(t *testing.T, url, secret string) (*EventBus, *WebhookDispatcher, Webhook) {
+	t.Helper()
+	eb, _ := NewRecordingBus(t)
+	dispatcher := NewWebhookDispatcher(eb, nil)
+	hook, err := dispatcher.Register("concert.*", url, secret, WithWebhookRetry(quickRetry))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), awaitTimeout)
+	defer cancel()
+	if err := Publish(ctx, eb, ConcertAdded{Concert: Concert{ID: "c1", Name: "Rock Concert"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := eb.Drain(ctx); err != nil {
+		t.Fatal(err)
+	}
+	return eb, dispatcher, hook
+}
+
+func TestWebhookSignsRequests(t *testing.T) {
+	const secret = "s3cret"
+	var verified atomic.Bool
+	server, _ := webhookServer(t, func(r *http.Request, body []byte) {
+		timestamp := r.Header.Get(WebhookHeaderTimestamp)
+		if !VerifyWebhook([]byte(secret), timestamp, body, r.Header.Get(WebhookHeaderSignature)) {
+			t.Errorf("signature %q does not verify", r.Header.Get(WebhookHeaderSignature))
+			return
+		}
+		if VerifyWebhook([]byte("wrong"), timestamp, body, r.Header.Get(WebhookHeaderSignature)) {
+			t.Error("signature verifies with the wrong secret")
+		}
+		if got := r.Header.Get(WebhookHeaderEventType); got != "concert.added" {
+			t.Errorf("want event type header concert.added, got %q", got)
+		}
+		var decoded webhookBody
+		if err := json.Unmarshal(body, &decoded); err != nil {
+			t.Error(err)
+			return
+		}
+		if decoded.ID != r.Header.Get(WebhookHeaderEventID) || decoded.Type != "concert.added" {
+			t.Errorf("body %+v does not match the event headers", decoded)
+		}
+		verified.Store(true)
+	}, http.StatusNoContent)
+
+	_, dispatcher, hook := publishToWebhook(t, server.URL, secret)
+	if !verified.Load() {
+		t.Fatal("webhook received no verified request")
+	}
+	deliveries := dispatcher.Deliveries(hook.ID)
+	if len(deliveries) != 1 || !deliveries[0].Succeeded() || deliveries[0].StatusCode != http.StatusNoContent {
+		t.Fatalf("want one successful delivery, got %+v", deliveries)
+	}
+}
+
+func TestWebhookRetriesUntilSuccess(t *testing.T) {
+	server, calls := webhookServer(t, nil, http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK)
+	eb, dispatcher, hook := publishToWebhook(t, server.URL, "s3cret")
+
+	if got := calls.Load(); got != 3 {
+		t.Fatalf("want 3 requests, got %d", got)
+	}
+	deliveries := dispatcher.Deliveries(hook.ID)
+	wantStatus := []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK}
+	if len(deliveries) != len(wantStatus) {
+		t.Fatalf("want %d logged attempts, got %+v", len(wantStatus), deliveries)
+	}
+	for i, delivery := range deliveries {
+		if delivery.Attempt != i+1 || delivery.StatusCode != wantStatus[i] || delivery.Permanent {
+			t.Fatalf("attempt %d: got %+v", i+1, delivery)
+		}
+	}
+	if !deliveries[2].Succeeded() {
+		t.Fatalf("want the last attempt to succeed, got %+v", deliveries[2])
+	}
+	if letters, _ := eb.DeadLetters(); len(letters) != 0 {
+		t.Fatalf("want no dead letters, got %d", len(letters))
+	}
+}
+
+func TestWebhookClientErrorIsPermanent(t *testing.T) {
+	server, calls := webhookServer(t, nil, http.StatusBadRequest, http.StatusOK)
+	eb, dispatcher, hook := publishToWebhook(t, server.URL, "s3cret")
+
+	if got := calls.Load(); got != 1 {
+		t.Fatalf("want a single request, got %d", got)
+	}
+	deliveries := dispatcher.Deliveries(hook.ID)
+	if len(deliveries) != 1 || deliveries[0].Succeeded() || !deliveries[0].Permanent || deliveries[0].StatusCode != http.StatusBadRequest {
+		t.Fatalf("want one permanent failure, got %+v", deliveries)
+	}
+	letters, err := eb.DeadLetters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(letters) != 1 {
+		t.Fatalf("want the event dead-lettered, got %d dead letters", len(letters))
+	}
+}
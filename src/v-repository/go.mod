@@ -3,3 +3,5 @@ module v-repository
 go 1.21.6
 
 require github.com/google/uuid v1.6.0
+
+require github.com/mattn/go-sqlite3 v1.14.22
@@ -2,6 +2,8 @@ package main
 
 import (
     "context"
+    "errors"
+    "flag"
     "fmt"
     "log"
     "sync"
@@ -76,26 +78,25 @@ type Ticket struct {
 // ConcertService manages concert-related operations
 type ConcertService struct {
     eventBus *EventBus
-    concerts map[string]*Concert
-    mu       sync.RWMutex
+    concerts ConcertRepository
 }
 
-// NewConcertService creates a new ConcertService
-func NewConcertService(eb *EventBus) *ConcertService {
+// NewConcertService creates a new ConcertService storing concerts in repo
+func NewConcertService(eb *EventBus, repo ConcertRepository) *ConcertService {
     cs := &ConcertService{
         eventBus: eb,
-        concerts: make(map[string]*Concert),
+        concerts: repo,
     }
     eb.Subscribe("TicketPurchased", cs.handleTicketPurchased)
     return cs
 }
 
-// AddConcert adds a new concert and publishes an event
+// AddConcert saves a new concert and publishes an event
 func (cs *ConcertService) AddConcert(ctx context.Context, concert *Concert) error {
-    cs.mu.Lock()
-    defer cs.mu.Unlock()
     concert.ID = uuid.New().String()
-    cs.concerts[concert.ID] = concert
+    if err := cs.concerts.Save(ctx, concert); err != nil {
+        return err
+    }
     
     cs.eventBus.Publish(ctx, Event{
         ID:        uuid.New().String(),
@@ -106,38 +107,46 @@ func (cs *ConcertService) AddConcert(ctx context.Context, concert *Concert) erro
     return nil
 }
 
+// Concert returns the concert with the given ID
+func (cs *ConcertService) Concert(ctx context.Context, id string) (*Concert, error) {
+    return cs.concerts.FindByID(ctx, id)
+}
+
+// Concerts returns every concert
+func (cs *ConcertService) Concerts(ctx context.Context) ([]*Concert, error) {
+    return cs.concerts.FindAll(ctx)
+}
+
 func (cs *ConcertService) handleTicketPurchased(ctx context.Context, event Event) error {
     ticket := event.Payload.(*Ticket)
-    cs.mu.Lock()
-    defer cs.mu.Unlock()
-    if concert, ok := cs.concerts[ticket.ConcertID]; ok {
-        concert.AvailableTickets--
+    err := cs.concerts.DecrementAvailableTickets(ctx, ticket.ConcertID)
+    if errors.Is(err, ErrConcertNotFound) {
+        return nil
     }
-    return nil
+    return err
 }
 
 // TicketService manages ticket-related operations
 type TicketService struct {
     eventBus *EventBus
-    tickets  map[string]*Ticket
-    mu       sync.RWMutex
+    tickets  TicketRepository
 }
 
-// NewTicketService creates a new TicketService
-func NewTicketService(eb *EventBus) *TicketService {
+// NewTicketService creates a new TicketService storing tickets in repo
+func NewTicketService(eb *EventBus, repo TicketRepository) *TicketService {
     return &TicketService{
         eventBus: eb,
-        tickets:  make(map[string]*Ticket),
+        tickets:  repo,
     }
 }
 
-// PurchaseTicket creates a new ticket and publishes an event
+// PurchaseTicket saves a new ticket and publishes an event
 func (ts *TicketService) PurchaseTicket(ctx context.Context, ticket *Ticket) error {
-    ts.mu.Lock()
-    defer ts.mu.Unlock()
     ticket.ID = uuid.New().String()
     ticket.PurchaseDate = time.Now()
-    ts.tickets[ticket.ID] = ticket
+    if err := ts.tickets.Save(ctx, ticket); err != nil {
+        return err
+    }
     
     ts.eventBus.Publish(ctx, Event{
         ID:        uuid.New().String(),
@@ -148,6 +157,16 @@ func (ts *TicketService) PurchaseTicket(ctx context.Context, ticket *Ticket) err
     return nil
 }
 
+// Ticket returns the ticket with the given ID
+func (ts *TicketService) Ticket(ctx context.Context, id string) (*Ticket, error) {
+    return ts.tickets.FindByID(ctx, id)
+}
+
+// Tickets returns every ticket
+func (ts *TicketService) Tickets(ctx context.Context) ([]*Ticket, error) {
+    return ts.tickets.FindAll(ctx)
+}
+
 // NotificationService sends notifications based on events
 type NotificationService struct {
     eventBus *EventBus
@@ -180,12 +199,56 @@ func simulateDBOperation() {
     time.Sleep(time.Millisecond * 100)
 }
 
+// openRepositories returns the SQLite repositories for the database at
+// dbPath, or in-memory ones if dbPath is empty. The returned function
+// releases them.
+func openRepositories(ctx context.Context, dbPath string) (ConcertRepository, TicketRepository, func(), error) {
+    if dbPath == "" {
+        return NewInMemoryConcertRepository(), NewInMemoryTicketRepository(), func() {}, nil
+    }
+    db, err := OpenSQLiteDB(dbPath)
+    if err != nil {
+        return nil, nil, nil, err
+    }
+    concerts, err := NewSQLiteConcertRepository(ctx, db)
+    if err != nil {
+        db.Close()
+        return nil, nil, nil, err
+    }
+    tickets, err := NewSQLiteTicketRepository(ctx, db)
+    if err != nil {
+        concerts.Close()
+        db.Close()
+        return nil, nil, nil, err
+    }
+    closeAll := func() {
+        concerts.Close()
+        tickets.Close()
+        db.Close()
+    }
+    return concerts, tickets, closeAll, nil
+}
+
 func main() {
+    dbPath := flag.String("db", "", "keep concerts and tickets in this SQLite database instead of in memory")
+    flag.Parse()
+
     ctx := context.Background()
     eventBus := NewEventBus()
 
-    concertService := NewConcertService(eventBus)
-    ticketService := NewTicketService(eventBus)
+    concertRepo, ticketRepo, closeRepos, err := openRepositories(ctx, *dbPath)
+    if err != nil {
+        log.Fatalf("Failed to open repositories: %v", err)
+    }
+    defer closeRepos()
+    if existing, err := concertRepo.FindAll(ctx); err != nil {
+        log.Fatalf("Failed to load concerts: %v", err)
+    } else if len(existing) > 0 {
+        log.Printf("Found %d concert(s) from earlier runs in %s", len(existing), *dbPath)
+    }
+
+    concertService := NewConcertService(eventBus, concertRepo)
+    ticketService := NewTicketService(eventBus, ticketRepo)
     NewNotificationService(eventBus)
 
     // Add a concert
@@ -216,5 +279,9 @@ func main() {
     time.Sleep(time.Second)
 
     // Print final state
-    fmt.Printf("Concert %s has %d tickets remaining\n", concert.ID, concertService.concerts[concert.ID].AvailableTickets)
+    stored, err := concertService.Concert(ctx, concert.ID)
+    if err != nil {
+        log.Fatalf("Failed to load concert: %v", err)
+    }
+    fmt.Printf("Concert %s has %d tickets remaining\n", stored.ID, stored.AvailableTickets)
 }
\ No newline at end of file
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Errors returned by repositories for unknown IDs
+var (
+	ErrConcertNotFound = errors.New("concert not found")
+	ErrTicketNotFound  = errors.New("ticket not found")
+)
+
+// ErrSoldOut is returned when taking a ticket from a concert with none left
+var ErrSoldOut = errors.New("concert sold out")
+
+// ConcertRepository stores concerts. Implementations hand out copies, so a
+// change to a returned Concert only takes effect once it is saved.
+type ConcertRepository interface {
+	FindByID(ctx context.Context, id string) (*Concert, error)
+	FindAll(ctx context.Context) ([]*Concert, error)
+	Save(ctx context.Context, concert *Concert) error
+	Delete(ctx context.Context, id string) error
+	// DecrementAvailableTickets takes one ticket from the concert in a
+	// single step, so concurrent purchases cannot overwrite each other. It
+	// returns ErrSoldOut if none are left.
+	DecrementAvailableTickets(ctx context.Context, id string) error
+}
+
+// TicketRepository stores tickets, handing out copies like
+// ConcertRepository
+type TicketRepository interface {
+	FindByID(ctx context.Context, id string) (*Ticket, error)
+	FindAll(ctx context.Context) ([]*Ticket, error)
+	Save(ctx context.Context, ticket *Ticket) error
+	Delete(ctx context.Context, id string) error
+}
+
+// InMemoryConcertRepository is a ConcertRepository backed by a map
+type InMemoryConcertRepository struct {
+	mu       sync.RWMutex
+	concerts map[string]Concert
+}
+
+// NewInMemoryConcertRepository creates an empty InMemoryConcertRepository
+func NewInMemoryConcertRepository() *InMemoryConcertRepository {
+	return &InMemoryConcertRepository{concerts: make(map[string]Concert)}
+}
+
+// FindByID implements ConcertRepository
+func (r *InMemoryConcertRepository) FindByID(ctx context.Context, id string) (*Concert, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	concert, ok := r.concerts[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrConcertNotFound, id)
+	}
+	return &concert, nil
+}
+
+// FindAll implements ConcertRepository, ordered by date
+func (r *InMemoryConcertRepository) FindAll(ctx context.Context) ([]*Concert, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	concerts := make([]*Concert, 0, len(r.concerts))
+	for _, concert := range r.concerts {
+		concert := concert
+		concerts = append(concerts, &concert)
+	}
+	sort.Slice(concerts, func(i, j int) bool {
+		if !concerts[i].Date.Equal(concerts[j].Date) {
+			return concerts[i].Date.Before(concerts[j].Date)
+		}
+		return concerts[i].ID < concerts[j].ID
+	})
+	return concerts, nil
+}
+
+// Save implements ConcertRepository, inserting or replacing the concert
+func (r *InMemoryConcertRepository) Save(ctx context.Context, concert *Concert) error {
+	if concert.ID == "" {
+		return errors.New("concert has no ID")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.concerts[concert.ID] = *concert
+	return nil
+}
+
+// Delete implements ConcertRepository
+func (r *InMemoryConcertRepository) Delete(ctx context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.concerts[id]; !ok {
+		return fmt.Errorf("%w: %s", ErrConcertNotFound, id)
+	}
+	delete(r.concerts, id)
+	return nil
+}
+
+// DecrementAvailableTickets implements ConcertRepository
+func (r *InMemoryConcertRepository) DecrementAvailableTickets(ctx context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	concert, ok := r.concerts[id]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrConcertNotFound, id)
+	}
+	if concert.AvailableTickets <= 0 {
+		return fmt.Errorf("%w: %s", ErrSoldOut, id)
+	}
+	concert.AvailableTickets--
+	r.concerts[id] = concert
+	return nil
+}
+
+// InMemoryTicketRepository is a TicketRepository backed by a map
+type InMemoryTicketRepository struct {
+	mu      sync.RWMutex
+	tickets map[string]Ticket
+}
+
+// NewInMemoryTicketRepository creates an empty InMemoryTicketRepository
+func NewInMemoryTicketRepository() *InMemoryTicketRepository {
+	return &InMemoryTicketRepository{tickets: make(map[string]Ticket)}
+}
+
+// FindByID implements TicketRepository
+func (r *InMemoryTicketRepository) FindByID(ctx context.Context, id string) (*Ticket, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	ticket, ok := r.tickets[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrTicketNotFound, id)
+	}
+	return &ticket, nil
+}
+
+// FindAll implements TicketRepository, ordered by purchase date
+func (r *InMemoryTicketRepository) FindAll(ctx context.Context) ([]*Ticket, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	tickets := make([]*Ticket, 0, len(r.tickets))
+	for _, ticket := range r.tickets {
+		ticket := ticket
+		tickets = append(tickets, &ticket)
+	}
+	sort.Slice(tickets, func(i, j int) bool {
+		if !tickets[i].PurchaseDate.Equal(tickets[j].PurchaseDate) {
+			return tickets[i].PurchaseDate.Before(tickets[j].PurchaseDate)
+		}
+		return tickets[i].ID < tickets[j].ID
+	})
+	return tickets, nil
+}
+
+// Save implements TicketRepository, inserting or replacing the ticket
+func (r *InMemoryTicketRepository) Save(ctx context.Context, ticket *Ticket) error {
+	if ticket.ID == "" {
+		return errors.New("ticket has no ID")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tickets[ticket.ID] = *ticket
+	return nil
+}
+
+// Delete implements TicketRepository
+func (r *InMemoryTicketRepository) Delete(ctx context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.tickets[id]; !ok {
+		return fmt.Errorf("%w: %s", ErrTicketNotFound, id)
+	}
+	delete(r.tickets, id)
+	return nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestInMemoryConcertRepositoryHandsOutCopies(t *testing.T) {
+	ctx := context.Background()
+	repo := NewInMemoryConcertRepository()
+	concert := &Concert{ID: "c1", Name: "Matinee", Date: time.Date(2025, 7, 1, 14, 0, 0, 0, time.UTC), AvailableTickets: 100}
+	if err := repo.Save(ctx, concert); err != nil {
+		t.Fatal(err)
+	}
+
+	// Changing the saved value or a loaded one leaves the store alone
+	concert.AvailableTickets = 1
+	found, err := repo.FindByID(ctx, "c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	found.AvailableTickets = 2
+	all, err := repo.FindAll(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	all[0].AvailableTickets = 3
+	if got, _ := repo.FindByID(ctx, "c1"); got.AvailableTickets != 100 {
+		t.Fatalf("want 100 tickets until Save, got %d", got.AvailableTickets)
+	}
+
+	if err := repo.Save(ctx, found); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := repo.FindByID(ctx, "c1"); got.AvailableTickets != 2 {
+		t.Fatalf("want 2 tickets after Save, got %d", got.AvailableTickets)
+	}
+}
+
+func TestInMemoryTicketRepositoryHandsOutCopies(t *testing.T) {
+	ctx := context.Background()
+	repo := NewInMemoryTicketRepository()
+	if err := repo.Save(ctx, &Ticket{ID: "t1", ConcertID: "c1", CustomerName: "Jane"}); err != nil {
+		t.Fatal(err)
+	}
+	found, err := repo.FindByID(ctx, "t1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	found.CustomerName = "Mallory"
+	if got, _ := repo.FindByID(ctx, "t1"); got.CustomerName != "Jane" {
+		t.Fatalf("want the stored ticket unchanged until Save, got %q", got.CustomerName)
+	}
+}
+
+func TestInMemoryRepositoriesReportUnknownIDs(t *testing.T) {
+	ctx := context.Background()
+	concerts := NewInMemoryConcertRepository()
+	tickets := NewInMemoryTicketRepository()
+
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"find concert", second(concerts.FindByID(ctx, "missing")), ErrConcertNotFound},
+		{"delete concert", concerts.Delete(ctx, "missing"), ErrConcertNotFound},
+		{"decrement concert", concerts.DecrementAvailableTickets(ctx, "missing"), ErrConcertNotFound},
+		{"find ticket", second(tickets.FindByID(ctx, "missing")), ErrTicketNotFound},
+		{"delete ticket", tickets.Delete(ctx, "missing"), ErrTicketNotFound},
+	}
+	for _, tt := range tests {
+		if !errors.Is(tt.err, tt.want) {
+			t.Errorf("%s: want %v, got %v", tt.name, tt.want, tt.err)
+		}
+		// The sentinel is wrapped with the ID that was asked for
+		if tt.err != nil && tt.err.Error() != tt.want.Error()+": missing" {
+			t.Errorf("%s: want the ID in %q", tt.name, tt.err)
+		}
+	}
+}
+
+func TestInMemoryDecrementStopsAtZero(t *testing.T) {
+	ctx := context.Background()
+	repo := NewInMemoryConcertRepository()
+	if err := repo.Save(ctx, &Concert{ID: "c1", AvailableTickets: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.DecrementAvailableTickets(ctx, "c1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.DecrementAvailableTickets(ctx, "c1"); !errors.Is(err, ErrSoldOut) {
+		t.Fatalf("want ErrSoldOut, got %v", err)
+	}
+	if got, _ := repo.FindByID(ctx, "c1"); got.AvailableTickets != 0 {
+		t.Fatalf("want no tickets left, got %d", got.AvailableTickets)
+	}
+}
+
+// second returns the error of a two-value call
+func second[T any](_ T, err error) error {
+	return err
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// sqliteTimeLayout stores times in UTC with a fixed width, so that the
+// text columns sort in time order
+const sqliteTimeLayout = "2006-01-02T15:04:05.000000000Z07:00"
+
+const concertSchema = `
+CREATE TABLE IF NOT EXISTS concerts (
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    date TEXT NOT NULL,
+    venue TEXT NOT NULL,
+    available_tickets INTEGER NOT NULL,
+    ticket_price REAL NOT NULL
+)`
+
+const ticketSchema = `
+CREATE TABLE IF NOT EXISTS tickets (
+    id TEXT PRIMARY KEY,
+    concert_id TEXT NOT NULL,
+    customer_name TEXT NOT NULL,
+    customer_email TEXT NOT NULL,
+    purchase_date TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS tickets_concert_id ON tickets (concert_id)`
+
+// OpenSQLiteDB opens the SQLite database at path, creating the file if
+// needed. Write-ahead logging lets readers proceed while a write is in
+// progress, and a busy timeout makes writers wait for each other instead of
+// failing.
+func OpenSQLiteDB(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", "file:"+path+"?_journal_mode=WAL&_busy_timeout=5000")
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// prepareAll prepares queries on db, closing those already prepared if one
+// fails
+func prepareAll(ctx context.Context, db *sql.DB, queries ...string) ([]*sql.Stmt, error) {
+	stmts := make([]*sql.Stmt, 0, len(queries))
+	for _, query := range queries {
+		stmt, err := db.PrepareContext(ctx, query)
+		if err != nil {
+			closeAll(stmts)
+			return nil, fmt.Errorf("preparing %q: %w", query, err)
+		}
+		stmts = append(stmts, stmt)
+	}
+	return stmts, nil
+}
+
+func closeAll(stmts []*sql.Stmt) error {
+	var errs []error
+	for _, stmt := range stmts {
+		errs = append(errs, stmt.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// parseSQLiteTime reads a time written with sqliteTimeLayout
+func parseSQLiteTime(value string) (time.Time, error) {
+	return time.Parse(sqliteTimeLayout, value)
+}
+
+// rowScanner is satisfied by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// SQLiteConcertRepository is a ConcertRepository stored in SQLite
+type SQLiteConcertRepository struct {
+	findByID  *sql.Stmt
+	findAll   *sql.Stmt
+	save      *sql.Stmt
+	delete    *sql.Stmt
+	decrement *sql.Stmt
+}
+
+// NewSQLiteConcertRepository creates the concerts table in db if it does
+// not exist and prepares the repository's statements. The caller keeps
+// ownership of db; Close releases only the statements.
+func NewSQLiteConcertRepository(ctx context.Context, db *sql.DB) (*SQLiteConcertRepository, error) {
+	if _, err := db.ExecContext(ctx, concertSchema); err != nil {
+		return nil, fmt.Errorf("creating concerts table: %w", err)
+	}
+	const columns = "id, name, date, venue, available_tickets, ticket_price"
+	stmts, err := prepareAll(ctx, db,
+		"SELECT "+columns+" FROM concerts WHERE id = ?",
+		"SELECT "+columns+" FROM concerts ORDER BY date, id",
+		"INSERT INTO concerts ("+columns+") VALUES (?, ?, ?, ?, ?, ?) "+
+			"ON CONFLICT (id) DO UPDATE SET name = excluded.name, date = excluded.date, venue = excluded.venue, "+
+			"available_tickets = excluded.available_tickets, ticket_price = excluded.ticket_price",
+		"DELETE FROM concerts WHERE id = ?",
+		"UPDATE concerts SET available_tickets = available_tickets - 1 WHERE id = ? AND available_tickets > 0",
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &SQLiteConcertRepository{
+		findByID: stmts[0], findAll: stmts[1], save: stmts[2], delete: stmts[3], decrement: stmts[4],
+	}, nil
+}
+
+// Close releases the prepared statements
+func (r *SQLiteConcertRepository) Close() error {
+	return closeAll([]*sql.Stmt{r.findByID, r.findAll, r.save, r.delete, r.decrement})
+}
+
+func scanConcert(row rowScanner) (*Concert, error) {
+	var concert Concert
+	var date string
+	if err := row.Scan(&concert.ID, &concert.Name, &date, &concert.Venue, &concert.AvailableTickets, &concert.TicketPrice); err != nil {
+		return nil, err
+	}
+	var err error
+	if concert.Date, err = parseSQLiteTime(date); err != nil {
+		return nil, fmt.Errorf("concert %s: %w", concert.ID, err)
+	}
+	return &concert, nil
+}
+
+// FindByID implements ConcertRepository
+func (r *SQLiteConcertRepository) FindByID(ctx context.Context, id string) (*Concert, error) {
+	concert, err := scanConcert(r.findByID.QueryRowContext(ctx, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: %s", ErrConcertNotFound, id)
+	}
+	return concert, err
+}
+
+// FindAll implements ConcertRepository, ordered by date
+func (r *SQLiteConcertRepository) FindAll(ctx context.Context) ([]*Concert, error) {
+	rows, err := r.findAll.QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	concerts := []*Concert{}
+	for rows.Next() {
+		concert, err := scanConcert(rows)
+		if err != nil {
+			return nil, err
+		}
+		concerts = append(concerts, concert)
+	}
+	return concerts, rows.Err()
+}
+
+// Save implements ConcertRepository, inserting or replacing the concert
+func (r *SQLiteConcertRepository) Save(ctx context.Context, concert *Concert) error {
+	if concert.ID == "" {
+		return errors.New("concert has no ID")
+	}
+	_, err := r.save.ExecContext(ctx, concert.ID, concert.Name, concert.Date.UTC().Format(sqliteTimeLayout),
+		concert.Venue, concert.AvailableTickets, concert.TicketPrice)
+	return err
+}
+
+// Delete implements ConcertRepository
+func (r *SQLiteConcertRepository) Delete(ctx context.Context, id string) error {
+	return deleteRow(ctx, r.delete, id, ErrConcertNotFound)
+}
+
+// DecrementAvailableTickets implements ConcertRepository with a single
+// conditional UPDATE. When it changes no row, the concert is looked up to
+// tell a missing concert from a sold-out one.
+func (r *SQLiteConcertRepository) DecrementAvailableTickets(ctx context.Context, id string) error {
+	result, err := r.decrement.ExecContext(ctx, id)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+	if _, err := r.FindByID(ctx, id); err != nil {
+		return err
+	}
+	return fmt.Errorf("%w: %s", ErrSoldOut, id)
+}
+
+// SQLiteTicketRepository is a TicketRepository stored in SQLite
+type SQLiteTicketRepository struct {
+	findByID *sql.Stmt
+	findAll  *sql.Stmt
+	save     *sql.Stmt
+	delete   *sql.Stmt
+}
+
+// NewSQLiteTicketRepository creates the tickets table in db if it does not
+// exist and prepares the repository's statements. The caller keeps
+// ownership of db; Close releases only the statements.
+func NewSQLiteTicketRepository(ctx context.Context, db *sql.DB) (*SQLiteTicketRepository, error) {
+	if _, err := db.ExecContext(ctx, ticketSchema); err != nil {
+		return nil, fmt.Errorf("creating tickets table: %w", err)
+	}
+	const columns = "id, concert_id, customer_name, customer_email, purchase_date"
+	stmts, err := prepareAll(ctx, db,
+		"SELECT "+columns+" FROM tickets WHERE id = ?",
+		"SELECT "+columns+" FROM tickets ORDER BY purchase_date, id",
+		"INSERT INTO tickets ("+columns+") VALUES (?, ?, ?, ?, ?) "+
+			"ON CONFLICT (id) DO UPDATE SET concert_id = excluded.concert_id, customer_name = excluded.customer_name, "+
+			"customer_email = excluded.customer_email, purchase_date = excluded.purchase_date",
+		"DELETE FROM tickets WHERE id = ?",
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &SQLiteTicketRepository{findByID: stmts[0], findAll: stmts[1], save: stmts[2], delete: stmts[3]}, nil
+}
+
+// Close releases the prepared statements
+func (r *SQLiteTicketRepository) Close() error {
+	return closeAll([]*sql.Stmt{r.findByID, r.findAll, r.save, r.delete})
+}
+
+func scanTicket(row rowScanner) (*Ticket, error) {
+	var ticket Ticket
+	var purchased string
+	if err := row.Scan(&ticket.ID, &ticket.ConcertID, &ticket.CustomerName, &ticket.CustomerEmail, &purchased); err != nil {
+		return nil, err
+	}
+	var err error
+	if ticket.PurchaseDate, err = parseSQLiteTime(purchased); err != nil {
+		return nil, fmt.Errorf("ticket %s: %w", ticket.ID, err)
+	}
+	return &ticket, nil
+}
+
+// FindByID implements TicketRepository
+func (r *SQLiteTicketRepository) FindByID(ctx context.Context, id string) (*Ticket, error) {
+	ticket, err := scanTicket(r.findByID.QueryRowContext(ctx, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: %s", ErrTicketNotFound, id)
+	}
+	return ticket, err
+}
+
+// FindAll implements TicketRepository, ordered by purchase date
+func (r *SQLiteTicketRepository) FindAll(ctx context.Context) ([]*Ticket, error) {
+	rows, err := r.findAll.QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tickets := []*Ticket{}
+	for rows.Next() {
+		ticket, err := scanTicket(rows)
+		if err != nil {
+			return nil, err
+		}
+		tickets = append(tickets, ticket)
+	}
+	return tickets, rows.Err()
+}
+
+// Save implements TicketRepository, inserting or replacing the ticket
+func (r *SQLiteTicketRepository) Save(ctx context.Context, ticket *Ticket) error {
+	if ticket.ID == "" {
+		return errors.New("ticket has no ID")
+	}
+	_, err := r.save.ExecContext(ctx, ticket.ID, ticket.ConcertID, ticket.CustomerName, ticket.CustomerEmail,
+		ticket.PurchaseDate.UTC().Format(sqliteTimeLayout))
+	return err
+}
+
+// Delete implements TicketRepository
+func (r *SQLiteTicketRepository) Delete(ctx context.Context, id string) error {
+	return deleteRow(ctx, r.delete, id, ErrTicketNotFound)
+}
+
+// deleteRow runs a prepared DELETE by ID, reporting notFound if no row
+// had that ID
+func deleteRow(ctx context.Context, stmt *sql.Stmt, id string, notFound error) error {
+	result, err := stmt.ExecContext(ctx, id)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("%w: %s", notFound, id)
+	}
+	return nil
+}
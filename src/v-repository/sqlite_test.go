@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// openSQLiteRepositories opens both repositories on a database at path and
+// releases them when the test ends
+func openSQLiteRepositories(t *testing.T, path string) (*SQLiteConcertRepository, *SQLiteTicketRepository, *sql.DB) {
+	t.Helper()
+	ctx := context.Background()
+	db, err := OpenSQLiteDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	concerts, err := NewSQLiteConcertRepository(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { concerts.Close() })
+	tickets, err := NewSQLiteTicketRepository(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tickets.Close() })
+	return concerts, tickets, db
+}
+
+func TestSQLiteConcertRepository(t *testing.T) {
+	ctx := context.Background()
+	concerts, _, _ := openSQLiteRepositories(t, filepath.Join(t.TempDir(), "concerts.db"))
+
+	// A time zone other than UTC and nanoseconds must survive the trip
+	berlin := time.FixedZone("CEST", 2*60*60)
+	late := &Concert{ID: "c2", Name: "Late Show", Date: time.Date(2025, 7, 1, 21, 30, 0, 123456789, berlin), Venue: "Club", AvailableTickets: 50, TicketPrice: 25}
+	early := &Concert{ID: "c1", Name: "Matinee", Date: time.Date(2025, 7, 1, 14, 0, 0, 0, time.UTC), Venue: "Hall", AvailableTickets: 100, TicketPrice: 10.5}
+	for _, concert := range []*Concert{late, early} {
+		if err := concerts.Save(ctx, concert); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := concerts.FindByID(ctx, late.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Date.Equal(late.Date) || got.Name != late.Name || got.AvailableTickets != 50 || got.TicketPrice != 25 {
+		t.Fatalf("want %+v, got %+v", late, got)
+	}
+
+	all, err := concerts.FindAll(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 || all[0].ID != early.ID || all[1].ID != late.ID {
+		t.Fatalf("want concerts ordered by date, got %+v", all)
+	}
+
+	late.AvailableTickets = 49
+	if err := concerts.Save(ctx, late); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := concerts.FindByID(ctx, late.ID); got.AvailableTickets != 49 {
+		t.Fatalf("want Save to update the concert, got %d tickets", got.AvailableTickets)
+	}
+
+	if err := concerts.Delete(ctx, early.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := concerts.FindByID(ctx, early.ID); !errors.Is(err, ErrConcertNotFound) {
+		t.Fatalf("want ErrConcertNotFound after Delete, got %v", err)
+	}
+	if err := concerts.Delete(ctx, early.ID); !errors.Is(err, ErrConcertNotFound) {
+		t.Fatalf("want ErrConcertNotFound deleting twice, got %v", err)
+	}
+}
+
+func TestSQLiteTicketRepository(t *testing.T) {
+	ctx := context.Background()
+	_, tickets, _ := openSQLiteRepositories(t, filepath.Join(t.TempDir(), "tickets.db"))
+
+	ticket := &Ticket{ID: "t1", ConcertID: "c1", CustomerName: "Jane", CustomerEmail: "jane@example.com",
+		PurchaseDate: time.Date(2025, 6, 1, 9, 15, 0, 42, time.Local)}
+	if err := tickets.Save(ctx, ticket); err != nil {
+		t.Fatal(err)
+	}
+	got, err := tickets.FindByID(ctx, ticket.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.PurchaseDate.Equal(ticket.PurchaseDate) || got.CustomerEmail != ticket.CustomerEmail {
+		t.Fatalf("want %+v, got %+v", ticket, got)
+	}
+	if all, err := tickets.FindAll(ctx); err != nil || len(all) != 1 {
+		t.Fatalf("want one ticket, got %v, %v", all, err)
+	}
+	if err := tickets.Delete(ctx, ticket.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tickets.FindByID(ctx, ticket.ID); !errors.Is(err, ErrTicketNotFound) {
+		t.Fatalf("want ErrTicketNotFound after Delete, got %v", err)
+	}
+	if err := tickets.Delete(ctx, ticket.ID); !errors.Is(err, ErrTicketNotFound) {
+		t.Fatalf("want ErrTicketNotFound deleting twice, got %v", err)
+	}
+}
+
+func TestSQLiteRepositoryPersistsAcrossReopen(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "concerts.db")
+	concert := &Concert{ID: "c1", Name: "Matinee", Date: time.Date(2025, 7, 1, 14, 0, 0, 0, time.UTC), Venue: "Hall", AvailableTickets: 3, TicketPrice: 10}
+
+	concerts, _, db := openSQLiteRepositories(t, path)
+	if err := concerts.Save(ctx, concert); err != nil {
+		t.Fatal(err)
+	}
+	concerts.Close()
+	db.Close()
+
+	reopened, _, _ := openSQLiteRepositories(t, path)
+	got, err := reopened.FindByID(ctx, concert.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Date.Equal(concert.Date) || got.Name != concert.Name || got.AvailableTickets != concert.AvailableTickets {
+		t.Fatalf("want %+v after reopening, got %+v", concert, got)
+	}
+}
+
+func TestSQLiteDecrementAvailableTickets(t *testing.T) {
+	ctx := context.Background()
+	concerts, _, _ := openSQLiteRepositories(t, filepath.Join(t.TempDir(), "concerts.db"))
+	const seats = 10
+	if err := concerts.Save(ctx, &Concert{ID: "c1", Name: "Matinee", Date: time.Now(), AvailableTickets: seats}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every buyer races for the same seats; exactly seats of them win
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	sold, soldOut := 0, 0
+	for i := 0; i < 3*seats; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := concerts.DecrementAvailableTickets(ctx, "c1")
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				sold++
+			case errors.Is(err, ErrSoldOut):
+				soldOut++
+			default:
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if sold != seats || soldOut != 2*seats {
+		t.Fatalf("want %d sold and %d sold out, got %d and %d", seats, 2*seats, sold, soldOut)
+	}
+	if got, _ := concerts.FindByID(ctx, "c1"); got.AvailableTickets != 0 {
+		t.Fatalf("want no tickets left, got %d", got.AvailableTickets)
+	}
+	if err := concerts.DecrementAvailableTickets(ctx, "missing"); !errors.Is(err, ErrConcertNotFound) {
+		t.Fatalf("want ErrConcertNotFound for an unknown concert, got %v", err)
+	}
+}
@@ -4,13 +4,31 @@ import (
 	"encoding/json"
 	"net/http"
 	"strconv"
+	"time"
 	"vi-cqrs/commands"
 	"vi-cqrs/queries"
+	"vi-cqrs/replica"
 )
 
 type Handler struct {
 	commandHandler *commands.CommandHandler
 	queryHandler   *queries.QueryHandler
+	replication    ReplicationStatus
+}
+
+// ReplicationStatus reports the progress of the replicator feeding the read
+// replica, such as a *replica.Replicator.
+type ReplicationStatus interface {
+	Status() replica.Status
+}
+
+// ReplicaStatusResponse combines where queries were routed with how far the
+// replicator has got, including the error it last failed with.
+type ReplicaStatusResponse struct {
+	queries.ReadRoutingStats
+	CommittedSeq int64  `json:"committedSeq"`
+	Pending      int    `json:"pending"`
+	LastError    string `json:"lastError,omitempty"`
 }
 
 func NewHandler(ch *commands.CommandHandler, qh *queries.QueryHandler) *Handler {
@@ -20,6 +38,37 @@ func NewHandler(ch *commands.CommandHandler, qh *queries.QueryHandler) *Handler
 	}
 }
 
+// UseReplication reports status on /api/replica/status alongside the read
+// routing counters.
+func (h *Handler) UseReplication(status ReplicationStatus) {
+	h.replication = status
+}
+
+// Routes serves every endpoint, each validated against the operation that
+// documents it in the OpenAPI document.
+func (h *Handler) Routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	for path, handler := range h.routes() {
+		mux.HandleFunc(path, h.Validate(handler))
+	}
+	return mux
+}
+
+func (h *Handler) routes() map[string]http.HandlerFunc {
+	return map[string]http.HandlerFunc{
+		"/api/concerts":       h.GetAvailableConcerts,
+		"/api/concert":        h.GetConcert,
+		"/api/purchase":       h.PurchaseTicket,
+		"/api/create-concert": h.CreateConcert,
+		"/api/openapi.json":   h.OpenAPI,
+		"/api/audit":          h.GetAuditLog,
+		"/api/audit/verify":   h.VerifyAuditLog,
+		"/api/cache/stats":    h.GetCacheStats,
+		"/api/replica/status": h.GetReplicaStatus,
+		"/rpc":                h.RPC,
+	}
+}
+
 func (h *Handler) PurchaseTicket(w http.ResponseWriter, r *http.Request) {
 	var cmd commands.PurchaseTicketCommand
 	if err := json.NewDecoder(r.Body).Decode(&cmd); err != nil {
@@ -27,11 +76,15 @@ func (h *Handler) PurchaseTicket(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.commandHandler.HandlePurchaseTicket(cmd); err != nil {
+	cmd.Actor = actorFromRequest(r)
+
+	seq, err := h.commandHandler.HandlePurchaseTicket(cmd)
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	setCommitSeq(w, seq)
 	w.WriteHeader(http.StatusCreated)
 }
 
@@ -43,7 +96,7 @@ func (h *Handler) GetConcert(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	concert, err := h.queryHandler.HandleGetConcertByID(queries.GetConcertByIDQuery{ID: id})
+	concert, err := h.queryHandler.HandleGetConcertByID(queries.GetConcertByIDQuery{ID: id, MinSeq: minSeqFromRequest(r)})
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -53,7 +106,10 @@ func (h *Handler) GetConcert(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) GetAvailableConcerts(w http.ResponseWriter, r *http.Request) {
-	concerts, err := h.queryHandler.HandleGetAvailableConcerts(queries.GetAvailableConcertsQuery{MinAvailableSeats: 1})
+	concerts, err := h.queryHandler.HandleGetAvailableConcerts(queries.GetAvailableConcertsQuery{
+		MinAvailableSeats: 1,
+		MinSeq:            minSeqFromRequest(r),
+	})
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -69,10 +125,92 @@ func (h *Handler) CreateConcert(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.commandHandler.HandleCreateConcert(cmd); err != nil {
+	cmd.Actor = actorFromRequest(r)
+
+	seq, err := h.commandHandler.HandleCreateConcert(cmd)
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	setCommitSeq(w, seq)
 	w.WriteHeader(http.StatusCreated)
 }
+
+func (h *Handler) GetAuditLog(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	query := queries.GetAuditLogQuery{Actor: params.Get("actor")}
+
+	var err error
+	if v := params.Get("from"); v != "" {
+		if query.From, err = time.Parse(time.RFC3339, v); err != nil {
+			http.Error(w, "Invalid from time", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := params.Get("to"); v != "" {
+		if query.To, err = time.Parse(time.RFC3339, v); err != nil {
+			http.Error(w, "Invalid to time", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := params.Get("limit"); v != "" {
+		if query.Limit, err = strconv.Atoi(v); err != nil {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	entries, err := h.queryHandler.HandleGetAuditLog(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(entries)
+}
+
+func (h *Handler) VerifyAuditLog(w http.ResponseWriter, r *http.Request) {
+	result, err := h.commandHandler.HandleVerifyAuditLog(commands.VerifyAuditLogCommand{Actor: actorFromRequest(r)})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(result)
+}
+
+func (h *Handler) GetCacheStats(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(h.queryHandler.CacheStats())
+}
+
+func (h *Handler) GetReplicaStatus(w http.ResponseWriter, r *http.Request) {
+	resp := ReplicaStatusResponse{ReadRoutingStats: h.queryHandler.ReadRoutingStats()}
+	if h.replication != nil {
+		status := h.replication.Status()
+		resp.CommittedSeq = status.CommittedSeq
+		resp.Pending = status.Pending
+		resp.LastError = status.LastError
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// setCommitSeq tells the client which commit its write produced. Sending it
+// back as X-Min-Seq on later reads guarantees the client sees its own write.
+func setCommitSeq(w http.ResponseWriter, seq int64) {
+	w.Header().Set("X-Commit-Seq", strconv.FormatInt(seq, 10))
+}
+
+func minSeqFromRequest(r *http.Request) int64 {
+	seq, _ := strconv.ParseInt(r.Header.Get("X-Min-Seq"), 10, 64)
+	return seq
+}
+
+// actorFromRequest identifies who issued a command for the audit log. The
+// API has no authentication, so X-Actor is whatever the client claims: the
+// audit chain proves which entries were recorded, not who sent them. Put the
+// API behind a proxy that authenticates callers and sets X-Actor itself
+// before relying on it.
+func actorFromRequest(r *http.Request) string {
+	return r.Header.Get("X-Actor")
+}
@@ -0,0 +1,32 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"vi-cqrs/replica"
+)
+
+// fixedReplication reports the same replication status every time.
+type fixedReplication replica.Status
+
+func (s fixedReplication) Status() replica.Status { return replica.Status(s) }
+
+func TestReplicaStatusReportsReplication(t *testing.T) {
+	h := newTestHandler(t)
+	h.UseReplication(fixedReplication{AppliedSeq: 3, CommittedSeq: 5, Pending: 2, LastError: "no such table: concerts"})
+
+	rec := httptest.NewRecorder()
+	h.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/replica/status", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("want 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var got ReplicaStatusResponse
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.CommittedSeq != 5 || got.Pending != 2 || got.LastError != "no such table: concerts" {
+		t.Fatalf("want the replicator status in the response, got %+v", got)
+	}
+}
@@ -0,0 +1,309 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+	"vi-cqrs/commands"
+	"vi-cqrs/domain"
+	"vi-cqrs/queries"
+)
+
+// OpenAPI is the subset of the OpenAPI 3 document model this API uses.
+type OpenAPI struct {
+	OpenAPI    string               `json:"openapi"`
+	Info       OpenAPIInfo          `json:"info"`
+	Paths      map[string]*PathItem `json:"paths"`
+	Components Components           `json:"components"`
+}
+
+type OpenAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type PathItem struct {
+	Get  *Operation `json:"get,omitempty"`
+	Post *Operation `json:"post,omitempty"`
+}
+
+type Operation struct {
+	OperationID string               `json:"operationId"`
+	Summary     string               `json:"summary,omitempty"`
+	Parameters  []Parameter          `json:"parameters,omitempty"`
+	RequestBody *RequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*Response `json:"responses"`
+}
+
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                  `json:"required"`
+	Content  map[string]*MediaType `json:"content"`
+}
+
+type Response struct {
+	Description string                `json:"description"`
+	Headers     map[string]*Header    `json:"headers,omitempty"`
+	Content     map[string]*MediaType `json:"content,omitempty"`
+}
+
+type Header struct {
+	Schema *Schema `json:"schema"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type Components struct {
+	Schemas map[string]*Schema `json:"schemas"`
+}
+
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AdditionalProperties *bool              `json:"additionalProperties,omitempty"`
+}
+
+const jsonContentType = "application/json"
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+// openAPISpec is generated once from the command and domain structs, so the
+// documented field names always match what the handlers decode.
+var openAPISpec = buildOpenAPISpec()
+
+func buildOpenAPISpec() *OpenAPI {
+	spec := &OpenAPI{
+		OpenAPI: "3.0.3",
+		Info:    OpenAPIInfo{Title: "Concert Ticket CQRS API", Version: "1.0.0"},
+		Paths:   make(map[string]*PathItem),
+		Components: Components{Schemas: map[string]*Schema{
+			"Concert":                   structSchema(reflect.TypeOf(domain.Concert{})),
+			"Ticket":                    structSchema(reflect.TypeOf(domain.Ticket{})),
+			"PurchaseTicketCommand":     structSchema(reflect.TypeOf(commands.PurchaseTicketCommand{}), "concertId", "studentName", "studentClass"),
+			"CreateConcertCommand":      structSchema(reflect.TypeOf(commands.CreateConcertCommand{}), "name", "date", "venue", "availableSeats", "ticketPrice"),
+			"VerifyAuditLogCommand":     structSchema(reflect.TypeOf(commands.VerifyAuditLogCommand{})),
+			"GetConcertByIDQuery":       structSchema(reflect.TypeOf(queries.GetConcertByIDQuery{}), "id"),
+			"GetAvailableConcertsQuery": structSchema(reflect.TypeOf(queries.GetAvailableConcertsQuery{})),
+			"GetStudentTicketsQuery":    structSchema(reflect.TypeOf(queries.GetStudentTicketsQuery{}), "studentName"),
+			"GetAuditLogQuery":          structSchema(reflect.TypeOf(queries.GetAuditLogQuery{})),
+			"AuditEntry":                structSchema(reflect.TypeOf(domain.AuditEntry{})),
+			"AuditVerification":         structSchema(reflect.TypeOf(commands.AuditVerification{})),
+			"CacheStats":                structSchema(reflect.TypeOf(queries.CacheStats{})),
+			"ReplicaStatusResponse":     structSchema(reflect.TypeOf(ReplicaStatusResponse{})),
+		}},
+	}
+
+	commitSeq := map[string]*Header{"X-Commit-Seq": {Schema: &Schema{Type: "integer", Format: "int64"}}}
+	minSeq := Parameter{Name: "X-Min-Seq", In: "header", Schema: &Schema{Type: "integer", Format: "int64"}}
+	actor := Parameter{
+		Name:        "X-Actor",
+		In:          "header",
+		Description: "Who to record in the audit log. Not authenticated: it is taken as the client claims it.",
+		Schema:      &Schema{Type: "string"},
+	}
+
+	spec.Paths["/api/concerts"] = &PathItem{Get: &Operation{
+		OperationID: "getAvailableConcerts",
+		Summary:     "List concerts with seats left",
+		Parameters:  []Parameter{minSeq},
+		Responses: map[string]*Response{
+			"200": jsonResponse("Available concerts", &Schema{Type: "array", Items: schemaRef("Concert")}),
+		},
+	}}
+	spec.Paths["/api/concert"] = &PathItem{Get: &Operation{
+		OperationID: "getConcert",
+		Summary:     "Get a concert by ID",
+		Parameters: []Parameter{
+			{Name: "id", In: "query", Required: true, Schema: &Schema{Type: "integer"}},
+			minSeq,
+		},
+		Responses: map[string]*Response{
+			"200": jsonResponse("The concert", schemaRef("Concert")),
+		},
+	}}
+	spec.Paths["/api/purchase"] = &PathItem{Post: &Operation{
+		OperationID: "purchaseTicket",
+		Summary:     "Purchase a ticket",
+		Parameters:  []Parameter{actor},
+		RequestBody: jsonBody("PurchaseTicketCommand"),
+		Responses: map[string]*Response{
+			"201": {Description: "Ticket purchased", Headers: commitSeq},
+		},
+	}}
+	spec.Paths["/api/create-concert"] = &PathItem{Post: &Operation{
+		OperationID: "createConcert",
+		Summary:     "Create a concert",
+		Parameters:  []Parameter{actor},
+		RequestBody: jsonBody("CreateConcertCommand"),
+		Responses: map[string]*Response{
+			"201": {Description: "Concert created", Headers: commitSeq},
+		},
+	}}
+	spec.Paths["/api/audit"] = &PathItem{Get: &Operation{
+		OperationID: "getAuditLog",
+		Summary:     "Browse the command audit log by actor and time range",
+		Parameters: []Parameter{
+			{Name: "actor", In: "query", Schema: &Schema{Type: "string"}},
+			{Name: "from", In: "query", Description: "Inclusive", Schema: &Schema{Type: "string", Format: "date-time"}},
+			{Name: "to", In: "query", Description: "Exclusive", Schema: &Schema{Type: "string", Format: "date-time"}},
+			{Name: "limit", In: "query", Schema: &Schema{Type: "integer"}},
+		},
+		Responses: map[string]*Response{
+			"200": jsonResponse("Audit entries in order", &Schema{Type: "array", Items: schemaRef("AuditEntry")}),
+		},
+	}}
+	spec.Paths["/api/audit/verify"] = &PathItem{Post: &Operation{
+		OperationID: "verifyAuditLog",
+		Summary:     "Check the audit log hash chain, recording the check in it",
+		Parameters:  []Parameter{actor},
+		Responses: map[string]*Response{
+			"200": jsonResponse("Verification result", schemaRef("AuditVerification")),
+		},
+	}}
+	spec.Paths["/api/cache/stats"] = &PathItem{Get: &Operation{
+		OperationID: "getCacheStats",
+		Summary:     "Query cache counters",
+		Responses: map[string]*Response{
+			"200": jsonResponse("Cache counters", schemaRef("CacheStats")),
+		},
+	}}
+	spec.Paths["/api/replica/status"] = &PathItem{Get: &Operation{
+		OperationID: "getReplicaStatus",
+		Summary:     "Read replica lag and routing counters",
+		Responses: map[string]*Response{
+			"200": jsonResponse("Replica status", schemaRef("ReplicaStatusResponse")),
+		},
+	}}
+	spec.Paths["/api/openapi.json"] = &PathItem{Get: &Operation{
+		OperationID: "getOpenAPI",
+		Summary:     "This document",
+		Responses: map[string]*Response{
+			"200": jsonResponse("OpenAPI 3 document", &Schema{Type: "object"}),
+		},
+	}}
+	// JSON-RPC bodies are checked per method against the schemas above, so
+	// that failures come back as JSON-RPC errors rather than HTTP ones
+	spec.Paths["/rpc"] = &PathItem{Post: &Operation{
+		OperationID: "rpc",
+		Summary:     "Call commands and queries by name over JSON-RPC 2.0",
+		Parameters:  []Parameter{actor},
+		Responses: map[string]*Response{
+			"200": {Description: "JSON-RPC response or batch of responses", Content: map[string]*MediaType{jsonContentType: {Schema: &Schema{}}}},
+			"204": {Description: "Only notifications were sent"},
+		},
+	}}
+
+	for _, item := range spec.Paths {
+		for _, op := range []*Operation{item.Get, item.Post} {
+			if op == nil {
+				continue
+			}
+			op.Responses["400"] = &Response{Description: "Request does not match the schema"}
+			op.Responses["500"] = &Response{Description: "Command or query failed"}
+		}
+	}
+	return spec
+}
+
+// structSchema derives an object schema from the json tags of t. Fields
+// tagged "-" are server-side only and are left out.
+func structSchema(t reflect.Type, required ...string) *Schema {
+	closed := false
+	s := &Schema{
+		Type:                 "object",
+		Properties:           make(map[string]*Schema),
+		Required:             required,
+		AdditionalProperties: &closed,
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			// Embedded fields are encoded inline
+			for prop, schema := range structSchema(field.Type).Properties {
+				s.Properties[prop] = schema
+			}
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		s.Properties[name] = typeSchema(field.Type)
+	}
+	return s
+}
+
+func typeSchema(t reflect.Type) *Schema {
+	switch t {
+	case timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case rawMessageType:
+		// Any JSON value
+		return &Schema{}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return typeSchema(t.Elem())
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: typeSchema(t.Elem())}
+	case reflect.Struct:
+		return structSchema(t)
+	}
+	return &Schema{}
+}
+
+func schemaRef(name string) *Schema {
+	return &Schema{Ref: "#/components/schemas/" + name}
+}
+
+func jsonBody(schema string) *RequestBody {
+	return &RequestBody{
+		Required: true,
+		Content:  map[string]*MediaType{jsonContentType: {Schema: schemaRef(schema)}},
+	}
+}
+
+func jsonResponse(description string, schema *Schema) *Response {
+	return &Response{
+		Description: description,
+		Content:     map[string]*MediaType{jsonContentType: {Schema: schema}},
+	}
+}
+
+func (h *Handler) OpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", jsonContentType)
+	json.NewEncoder(w).Encode(openAPISpec)
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestServedOpenAPIMatchesRoutes(t *testing.T) {
+	h := newTestHandler(t)
+	routes := h.Routes()
+
+	rec := httptest.NewRecorder()
+	routes.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/openapi.json", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != jsonContentType {
+		t.Fatalf("want a JSON document, got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	var served OpenAPI
+	if err := json.Unmarshal(rec.Body.Bytes(), &served); err != nil {
+		t.Fatal(err)
+	}
+	want, err := json.Marshal(openAPISpec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := json.Marshal(served)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(want) {
+		t.Fatal("served document differs from the generated spec")
+	}
+
+	var documented, routed []string
+	for path := range served.Paths {
+		documented = append(documented, path)
+	}
+	for path := range h.routes() {
+		routed = append(routed, path)
+	}
+	sort.Strings(documented)
+	sort.Strings(routed)
+	if !reflect.DeepEqual(documented, routed) {
+		t.Fatalf("documented paths %v differ from routes %v", documented, routed)
+	}
+
+	for path, item := range served.Paths {
+		for method, op := range map[string]*Operation{http.MethodGet: item.Get, http.MethodPost: item.Post} {
+			req := httptest.NewRequest(method, path, nil)
+			_, pattern := routes.Handler(req)
+			rec := httptest.NewRecorder()
+			routes.ServeHTTP(rec, req)
+			switch {
+			case pattern != path:
+				t.Errorf("%s %s is routed to %q", method, path, pattern)
+			case op == nil && rec.Code != http.StatusMethodNotAllowed:
+				t.Errorf("undocumented %s %s answered %d, want 405", method, path, rec.Code)
+			case op != nil && rec.Code == http.StatusMethodNotAllowed:
+				t.Errorf("documented %s %s answered 405", method, path)
+			}
+		}
+	}
+}
+
+func TestOpenAPIReferencesResolve(t *testing.T) {
+	raw, err := json.Marshal(openAPISpec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc interface{}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		t.Fatal(err)
+	}
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			if ref, ok := v["$ref"].(string); ok {
+				name := strings.TrimPrefix(ref, "#/components/schemas/")
+				if _, ok := openAPISpec.Components.Schemas[name]; !ok {
+					t.Errorf("unresolved reference %s", ref)
+				}
+			}
+			for _, child := range v {
+				walk(child)
+			}
+		case []interface{}:
+			for _, child := range v {
+				walk(child)
+			}
+		}
+	}
+	walk(doc)
+
+	for name, schema := range openAPISpec.Components.Schemas {
+		for _, field := range schema.Required {
+			if _, ok := schema.Properties[field]; !ok {
+				t.Errorf("%s requires unknown field %s", name, field)
+			}
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"vi-cqrs/commands"
+	"vi-cqrs/queries"
+)
+
+// Standard JSON-RPC 2.0 error codes, plus the server error code used when a
+// command or query itself fails.
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcHandlerError   = -32000
+)
+
+type rpcError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+func (e *rpcError) Error() string {
+	return e.Message
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+// rpcMethod decodes params and runs a single command or query. params names
+// the OpenAPI component schema the params are validated against first.
+type rpcMethod struct {
+	kind   string
+	params string
+	call   func(h *Handler, r *http.Request, params json.RawMessage) (interface{}, error)
+}
+
+// RPCMethodInfo describes a method listed by rpc.listMethods.
+type RPCMethodInfo struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+}
+
+type commitResult struct {
+	Seq int64 `json:"seq"`
+}
+
+var rpcMethods = map[string]rpcMethod{
+	"PurchaseTicket": {"command", "PurchaseTicketCommand", func(h *Handler, r *http.Request, params json.RawMessage) (interface{}, error) {
+		var cmd commands.PurchaseTicketCommand
+		if err := decodeParams(params, &cmd); err != nil {
+			return nil, err
+		}
+		cmd.Actor = actorFromRequest(r)
+		seq, err := h.commandHandler.HandlePurchaseTicket(cmd)
+		if err != nil {
+			return nil, err
+		}
+		return commitResult{Seq: seq}, nil
+	}},
+	"CreateConcert": {"command", "CreateConcertCommand", func(h *Handler, r *http.Request, params json.RawMessage) (interface{}, error) {
+		var cmd commands.CreateConcertCommand
+		if err := decodeParams(params, &cmd); err != nil {
+			return nil, err
+		}
+		cmd.Actor = actorFromRequest(r)
+		seq, err := h.commandHandler.HandleCreateConcert(cmd)
+		if err != nil {
+			return nil, err
+		}
+		return commitResult{Seq: seq}, nil
+	}},
+	"VerifyAuditLog": {"command", "VerifyAuditLogCommand", func(h *Handler, r *http.Request, params json.RawMessage) (interface{}, error) {
+		var cmd commands.VerifyAuditLogCommand
+		if err := decodeParams(params, &cmd); err != nil {
+			return nil, err
+		}
+		cmd.Actor = actorFromRequest(r)
+		return h.commandHandler.HandleVerifyAuditLog(cmd)
+	}},
+	"GetConcertByID": {"query", "GetConcertByIDQuery", func(h *Handler, r *http.Request, params json.RawMessage) (interface{}, error) {
+		var query queries.GetConcertByIDQuery
+		if err := decodeParams(params, &query); err != nil {
+			return nil, err
+		}
+		return h.queryHandler.HandleGetConcertByID(query)
+	}},
+	"GetAvailableConcerts": {"query", "GetAvailableConcertsQuery", func(h *Handler, r *http.Request, params json.RawMessage) (interface{}, error) {
+		var query queries.GetAvailableConcertsQuery
+		if err := decodeParams(params, &query); err != nil {
+			return nil, err
+		}
+		return h.queryHandler.HandleGetAvailableConcerts(query)
+	}},
+	"GetStudentTickets": {"query", "GetStudentTicketsQuery", func(h *Handler, r *http.Request, params json.RawMessage) (interface{}, error) {
+		var query queries.GetStudentTicketsQuery
+		if err := decodeParams(params, &query); err != nil {
+			return nil, err
+		}
+		return h.queryHandler.HandleGetStudentTickets(query)
+	}},
+	"GetAuditLog": {"query", "GetAuditLogQuery", func(h *Handler, r *http.Request, params json.RawMessage) (interface{}, error) {
+		var query queries.GetAuditLogQuery
+		if err := decodeParams(params, &query); err != nil {
+			return nil, err
+		}
+		return h.queryHandler.HandleGetAuditLog(query)
+	}},
+}
+
+// validateParams checks params against the named component schema, as the
+// Validate middleware checks REST request bodies. Absent params are left to
+// decodeParams, which treats them as the zero command or query.
+func validateParams(schema string, params json.RawMessage) *rpcError {
+	if len(params) == 0 || bytes.Equal(params, []byte("null")) {
+		return nil
+	}
+	var value interface{}
+	dec := json.NewDecoder(bytes.NewReader(params))
+	dec.UseNumber()
+	if err := dec.Decode(&value); err != nil {
+		return &rpcError{Code: rpcInvalidParams, Message: "Invalid params", Data: err.Error()}
+	}
+	if problems := validateValue(schemaRef(schema), value, "params"); len(problems) > 0 {
+		return &rpcError{Code: rpcInvalidParams, Message: "Invalid params", Data: problems}
+	}
+	return nil
+}
+
+// decodeParams accepts by-name params only; commands and queries are structs.
+func decodeParams(params json.RawMessage, v interface{}) error {
+	if len(params) == 0 || bytes.Equal(params, []byte("null")) {
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(params))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return &rpcError{Code: rpcInvalidParams, Message: "Invalid params", Data: err.Error()}
+	}
+	return nil
+}
+
+func listRPCMethods() []RPCMethodInfo {
+	methods := make([]RPCMethodInfo, 0, len(rpcMethods)+1)
+	for name, m := range rpcMethods {
+		methods = append(methods, RPCMethodInfo{Name: name, Kind: m.kind})
+	}
+	methods = append(methods, RPCMethodInfo{Name: "rpc.listMethods", Kind: "system"})
+	sort.Slice(methods, func(i, j int) bool { return methods[i].Name < methods[j].Name })
+	return methods
+}
+
+// RPC serves JSON-RPC 2.0 over POST, dispatching to every command and query
+// by name. Batches and notifications are supported; rpc.listMethods returns
+// the available methods. Params are validated against the same schemas as
+// the REST endpoints, and rejected with -32602.
+func (h *Handler) RPC(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "JSON-RPC requires POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeRPC(w, rpcResponse{
+			JSONRPC: "2.0",
+			Error:   &rpcError{Code: rpcParseError, Message: "Parse error"},
+			ID:      json.RawMessage("null"),
+		})
+		return
+	}
+
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		var batch []json.RawMessage
+		if err := json.Unmarshal(trimmed, &batch); err != nil || len(batch) == 0 {
+			writeRPC(w, invalidRequest())
+			return
+		}
+		var responses []rpcResponse
+		for _, raw := range batch {
+			if resp, ok := h.callRPC(r, raw); ok {
+				responses = append(responses, resp)
+			}
+		}
+		if len(responses) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeRPC(w, responses)
+		return
+	}
+
+	if resp, ok := h.callRPC(r, trimmed); ok {
+		writeRPC(w, resp)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// callRPC runs a single request. It reports false for notifications, which
+// must not be answered.
+func (h *Handler) callRPC(r *http.Request, raw json.RawMessage) (rpcResponse, bool) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return invalidRequest(), true
+	}
+	id, hasID := fields["id"]
+	if !hasID {
+		id = json.RawMessage("null")
+	} else if !validRPCID(id) {
+		return invalidRequest(), true
+	}
+
+	var version, method string
+	if json.Unmarshal(fields["jsonrpc"], &version) != nil || version != "2.0" ||
+		json.Unmarshal(fields["method"], &method) != nil || method == "" {
+		return invalidRequest(), true
+	}
+
+	resp := rpcResponse{JSONRPC: "2.0", ID: id}
+	if method == "rpc.listMethods" {
+		resp.Result = listRPCMethods()
+	} else if m, ok := rpcMethods[method]; !ok {
+		resp.Error = &rpcError{Code: rpcMethodNotFound, Message: "Method not found"}
+	} else if rpcErr := validateParams(m.params, fields["params"]); rpcErr != nil {
+		resp.Error = rpcErr
+	} else if result, err := m.call(h, r, fields["params"]); err != nil {
+		var rpcErr *rpcError
+		if errors.As(err, &rpcErr) {
+			resp.Error = rpcErr
+		} else {
+			resp.Error = &rpcError{Code: rpcHandlerError, Message: err.Error()}
+		}
+	} else if result == nil {
+		resp.Result = json.RawMessage("null")
+	} else {
+		resp.Result = result
+	}
+	return resp, hasID
+}
+
+// validRPCID accepts the id types allowed by the spec: string, number or null.
+func validRPCID(id json.RawMessage) bool {
+	var v interface{}
+	if err := json.Unmarshal(id, &v); err != nil {
+		return false
+	}
+	switch v.(type) {
+	case string, float64, nil:
+		return true
+	}
+	return false
+}
+
+func invalidRequest() rpcResponse {
+	return rpcResponse{
+		JSONRPC: "2.0",
+		Error:   &rpcError{Code: rpcInvalidRequest, Message: "Invalid Request"},
+		ID:      json.RawMessage("null"),
+	}
+}
+
+func writeRPC(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
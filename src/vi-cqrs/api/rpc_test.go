@@ -0,0 +1,150 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"vi-cqrs/commands"
+	"vi-cqrs/internal/testdb"
+	"vi-cqrs/queries"
+)
+
+// newTestHandler serves the API from a database with the application
+// schema in a temporary directory.
+func newTestHandler(t *testing.T) *Handler {
+	t.Helper()
+	db := testdb.Open(t)
+	return NewHandler(commands.NewCommandHandler(db), queries.NewQueryHandler(db))
+}
+
+// postRPC sends body to the validated /rpc endpoint.
+func postRPC(t *testing.T, h *Handler, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/rpc", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	h.Validate(h.RPC)(rec, req)
+	return rec
+}
+
+type testRPCResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result"`
+	Error   *rpcError       `json:"error"`
+	ID      json.RawMessage `json:"id"`
+}
+
+const createConcertRPC = `{"jsonrpc":"2.0","id":1,"method":"CreateConcert","params":` +
+	`{"name":"Spring Gala","date":"2025-04-01T19:00:00Z","venue":"Hall","availableSeats":5,"ticketPrice":10}}`
+
+func TestRPCSingleCalls(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		wantCode int
+		wantID   string
+		// wantResult is checked against the raw result when set
+		wantResult string
+	}{
+		{"parse error", `{"jsonrpc":`, rpcParseError, "null", ""},
+		{"wrong version", `{"jsonrpc":"1.0","id":1,"method":"rpc.listMethods"}`, rpcInvalidRequest, "null", ""},
+		{"missing method", `{"jsonrpc":"2.0","id":1}`, rpcInvalidRequest, "null", ""},
+		{"object id", `{"jsonrpc":"2.0","id":{},"method":"rpc.listMethods"}`, rpcInvalidRequest, "null", ""},
+		{"not an object", `"hello"`, rpcInvalidRequest, "null", ""},
+		{"empty batch", `[]`, rpcInvalidRequest, "null", ""},
+		{"unknown method", `{"jsonrpc":"2.0","id":"a","method":"DropTables"}`, rpcMethodNotFound, `"a"`, ""},
+		{"unknown param", `{"jsonrpc":"2.0","id":2,"method":"GetConcertByID","params":{"id":1,"sql":"x"}}`, rpcInvalidParams, "2", ""},
+		{"wrong param type", `{"jsonrpc":"2.0","id":2,"method":"GetConcertByID","params":{"id":"one"}}`, rpcInvalidParams, "2", ""},
+		{"missing param", `{"jsonrpc":"2.0","id":2,"method":"GetStudentTickets","params":{"minSeq":0}}`, rpcInvalidParams, "2", ""},
+		{"positional params", `{"jsonrpc":"2.0","id":2,"method":"GetConcertByID","params":[1]}`, rpcInvalidParams, "2", ""},
+		{"bad date", `{"jsonrpc":"2.0","id":3,"method":"CreateConcert","params":{"name":"X","date":"tomorrow","venue":"Hall","availableSeats":5,"ticketPrice":10}}`, rpcInvalidParams, "3", ""},
+		{"handler error", `{"jsonrpc":"2.0","id":4,"method":"GetConcertByID","params":{"id":99}}`, rpcHandlerError, "4", ""},
+		{"command", createConcertRPC, 0, "1", `{"seq":1}`},
+		{"query", `{"jsonrpc":"2.0","id":5,"method":"GetStudentTickets","params":{"studentName":"Ada"}}`, 0, "5", "null"},
+	}
+	h := newTestHandler(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := postRPC(t, h, tt.body)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("want HTTP 200, got %d: %s", rec.Code, rec.Body)
+			}
+			var resp testRPCResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("decoding %s: %v", rec.Body, err)
+			}
+			if resp.JSONRPC != "2.0" || string(resp.ID) != tt.wantID {
+				t.Fatalf("want a 2.0 response with id %s, got %s", tt.wantID, rec.Body)
+			}
+			if tt.wantCode == 0 {
+				if resp.Error != nil {
+					t.Fatalf("want success, got error %+v", resp.Error)
+				}
+				if tt.wantResult != "" && string(resp.Result) != tt.wantResult {
+					t.Fatalf("want result %s, got %s", tt.wantResult, resp.Result)
+				}
+				return
+			}
+			if resp.Error == nil || resp.Error.Code != tt.wantCode {
+				t.Fatalf("want error %d, got %s", tt.wantCode, rec.Body)
+			}
+		})
+	}
+}
+
+func TestRPCNotificationsAndBatches(t *testing.T) {
+	h := newTestHandler(t)
+
+	rec := postRPC(t, h, `{"jsonrpc":"2.0","method":"rpc.listMethods"}`)
+	if rec.Code != http.StatusNoContent || rec.Body.Len() != 0 {
+		t.Fatalf("want an empty 204 for a notification, got %d: %s", rec.Code, rec.Body)
+	}
+
+	rec = postRPC(t, h, `[
+		`+createConcertRPC+`,
+		{"jsonrpc":"2.0","method":"CreateConcert","params":{"name":"Silent","date":"2025-05-01T19:00:00Z","venue":"Hall","availableSeats":5,"ticketPrice":10}},
+		{"jsonrpc":"2.0","id":2,"method":"GetConcertByID","params":{"id":2}},
+		{"jsonrpc":"2.0","id":3,"method":"Nope"},
+		42
+	]`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("want HTTP 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var batch []testRPCResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &batch); err != nil {
+		t.Fatalf("decoding %s: %v", rec.Body, err)
+	}
+	if len(batch) != 4 {
+		t.Fatalf("want 4 responses, the notification unanswered, got %s", rec.Body)
+	}
+	if batch[0].Error != nil || string(batch[0].Result) != `{"seq":1}` {
+		t.Fatalf("want the first concert created, got %s", rec.Body)
+	}
+	var concert struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(batch[1].Result, &concert); err != nil || concert.Name != "Silent" {
+		t.Fatalf("want the notification's concert to exist, got %s", batch[1].Result)
+	}
+	if batch[2].Error == nil || batch[2].Error.Code != rpcMethodNotFound {
+		t.Fatalf("want method not found, got %+v", batch[2])
+	}
+	if batch[3].Error == nil || batch[3].Error.Code != rpcInvalidRequest {
+		t.Fatalf("want invalid request for a non-object, got %+v", batch[3])
+	}
+
+	rec = postRPC(t, h, `[{"jsonrpc":"2.0","method":"rpc.listMethods"}]`)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("want 204 for a batch of notifications, got %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestRPCRequiresPost(t *testing.T) {
+	h := newTestHandler(t)
+	rec := httptest.NewRecorder()
+	h.Validate(h.RPC)(rec, httptest.NewRequest(http.MethodGet, "/rpc", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("want 405, got %d", rec.Code)
+	}
+}
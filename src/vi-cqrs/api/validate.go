@@ -0,0 +1,182 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Validate rejects requests that do not match the OpenAPI operation for
+// their path and method before they reach next.
+func (h *Handler) Validate(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		item, ok := openAPISpec.Paths[r.URL.Path]
+		if !ok {
+			next(w, r)
+			return
+		}
+		var op *Operation
+		switch r.Method {
+		case http.MethodGet:
+			op = item.Get
+		case http.MethodPost:
+			op = item.Post
+		}
+		if op == nil {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		problems := validateParameters(op.Parameters, r)
+		if op.RequestBody != nil {
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			problems = append(problems, validateBody(op.RequestBody, body)...)
+		}
+		if len(problems) > 0 {
+			http.Error(w, strings.Join(problems, "; "), http.StatusBadRequest)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func validateParameters(params []Parameter, r *http.Request) []string {
+	var problems []string
+	for _, p := range params {
+		var value string
+		switch p.In {
+		case "query":
+			value = r.URL.Query().Get(p.Name)
+		case "header":
+			value = r.Header.Get(p.Name)
+		}
+		if value == "" {
+			if p.Required {
+				problems = append(problems, fmt.Sprintf("%s parameter %q is required", p.In, p.Name))
+			}
+			continue
+		}
+		switch {
+		case p.Schema.Type == "integer":
+			if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+				problems = append(problems, fmt.Sprintf("%s parameter %q must be an integer", p.In, p.Name))
+			}
+		case p.Schema.Format == "date-time":
+			if _, err := time.Parse(time.RFC3339, value); err != nil {
+				problems = append(problems, fmt.Sprintf("%s parameter %q must be an RFC 3339 date-time", p.In, p.Name))
+			}
+		}
+	}
+	return problems
+}
+
+func validateBody(body *RequestBody, raw []byte) []string {
+	media, ok := body.Content[jsonContentType]
+	if !ok {
+		return nil
+	}
+	if len(bytes.TrimSpace(raw)) == 0 {
+		if body.Required {
+			return []string{"request body is required"}
+		}
+		return nil
+	}
+	var value interface{}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if err := dec.Decode(&value); err != nil {
+		return []string{"request body is not valid JSON: " + err.Error()}
+	}
+	return validateValue(media.Schema, value, "body")
+}
+
+// validateValue checks value against s, resolving component references, and
+// returns one message per violation.
+func validateValue(s *Schema, value interface{}, path string) []string {
+	if s.Ref != "" {
+		name := strings.TrimPrefix(s.Ref, "#/components/schemas/")
+		resolved, ok := openAPISpec.Components.Schemas[name]
+		if !ok {
+			return []string{fmt.Sprintf("%s: unknown schema %s", path, s.Ref)}
+		}
+		s = resolved
+	}
+
+	switch s.Type {
+	case "object":
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return []string{path + " must be an object"}
+		}
+		var problems []string
+		for _, name := range s.Required {
+			if _, ok := obj[name]; !ok {
+				problems = append(problems, fmt.Sprintf("%s.%s is required", path, name))
+			}
+		}
+		names := make([]string, 0, len(obj))
+		for name := range obj {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			prop, ok := s.Properties[name]
+			if !ok {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					problems = append(problems, fmt.Sprintf("%s.%s is not a known field", path, name))
+				}
+				continue
+			}
+			problems = append(problems, validateValue(prop, obj[name], path+"."+name)...)
+		}
+		return problems
+	case "array":
+		arr, ok := value.([]interface{})
+		if !ok {
+			return []string{path + " must be an array"}
+		}
+		var problems []string
+		for i, item := range arr {
+			problems = append(problems, validateValue(s.Items, item, fmt.Sprintf("%s[%d]", path, i))...)
+		}
+		return problems
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			return []string{path + " must be a string"}
+		}
+		if s.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339, str); err != nil {
+				return []string{path + " must be an RFC 3339 date-time"}
+			}
+		}
+	case "integer":
+		n, ok := value.(json.Number)
+		if !ok {
+			return []string{path + " must be an integer"}
+		}
+		if _, err := n.Int64(); err != nil {
+			return []string{path + " must be an integer"}
+		}
+	case "number":
+		if _, ok := value.(json.Number); !ok {
+			return []string{path + " must be a number"}
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return []string{path + " must be a boolean"}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestValidateRequests(t *testing.T) {
+	const concert = `{"name":"Spring Gala","date":"2025-04-01T19:00:00Z","venue":"Hall","availableSeats":5,"ticketPrice":10}`
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		header     string
+		body       string
+		wantStatus int
+		// wantProblem must appear in the 400 response
+		wantProblem string
+	}{
+		{"concert created", http.MethodPost, "/api/create-concert", "", concert, http.StatusCreated, ""},
+		{"ticket purchased", http.MethodPost, "/api/purchase", "", `{"concertId":1,"studentName":"Ada","studentClass":"4b"}`, http.StatusCreated, ""},
+		{"concert read", http.MethodGet, "/api/concert?id=1", "", "", http.StatusOK, ""},
+		{"empty body", http.MethodPost, "/api/create-concert", "", "", http.StatusBadRequest, "request body is required"},
+		{"not JSON", http.MethodPost, "/api/create-concert", "", `{"name":`, http.StatusBadRequest, "not valid JSON"},
+		{"missing field", http.MethodPost, "/api/purchase", "", `{"concertId":1,"studentName":"Ada"}`, http.StatusBadRequest, "body.studentClass is required"},
+		{"snake case field", http.MethodPost, "/api/purchase", "", `{"concert_id":1,"concertId":1,"studentName":"Ada","studentClass":"4b"}`, http.StatusBadRequest, "body.concert_id is not a known field"},
+		{"wrong type", http.MethodPost, "/api/purchase", "", `{"concertId":"1","studentName":"Ada","studentClass":"4b"}`, http.StatusBadRequest, "body.concertId must be an integer"},
+		{"bad date", http.MethodPost, "/api/create-concert", "", strings.Replace(concert, "2025-04-01T19:00:00Z", "April 1st", 1), http.StatusBadRequest, "body.date must be an RFC 3339 date-time"},
+		{"missing query parameter", http.MethodGet, "/api/concert", "", "", http.StatusBadRequest, `query parameter "id" is required`},
+		{"bad query parameter", http.MethodGet, "/api/concert?id=one", "", "", http.StatusBadRequest, `query parameter "id" must be an integer`},
+		{"bad header", http.MethodGet, "/api/concerts", "X-Min-Seq: soon", "", http.StatusBadRequest, `header parameter "X-Min-Seq" must be an integer`},
+		{"bad time range", http.MethodGet, "/api/audit?from=yesterday", "", "", http.StatusBadRequest, `query parameter "from" must be an RFC 3339 date-time`},
+		{"wrong method", http.MethodGet, "/api/purchase", "", "", http.StatusMethodNotAllowed, ""},
+		{"audit verified", http.MethodPost, "/api/audit/verify", "", "", http.StatusOK, ""},
+		{"audit verified by GET", http.MethodGet, "/api/audit/verify", "", "", http.StatusMethodNotAllowed, ""},
+	}
+	routes := newTestHandler(t).Routes()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			if name, value, ok := strings.Cut(tt.header, ": "); ok {
+				req.Header.Set(name, value)
+			}
+			rec := httptest.NewRecorder()
+			routes.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("want %d, got %d: %s", tt.wantStatus, rec.Code, rec.Body)
+			}
+			if tt.wantProblem != "" && !strings.Contains(rec.Body.String(), tt.wantProblem) {
+				t.Fatalf("want %q in %q", tt.wantProblem, rec.Body)
+			}
+			if tt.wantStatus == http.StatusCreated && rec.Header().Get("X-Commit-Seq") == "" {
+				t.Fatal("want X-Commit-Seq on a successful command")
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package commands
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+	"vi-cqrs/domain"
+)
+
+const anonymousActor = "anonymous"
+
+// AuditVerification reports the result of walking the audit hash chain.
+type AuditVerification struct {
+	Valid    bool   `json:"valid"`
+	Entries  int    `json:"entries"`
+	BrokenAt int64  `json:"brokenAt,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// audited runs fn inside a transaction and appends an audit entry for it.
+// On success the entry commits atomically with the command's own writes, so
+// no command can take effect without being logged, and commit listeners are
+// notified with the entry ID as the commit sequence. On failure the command
+// is rolled back and the failure is recorded in a transaction of its own.
+func (h *CommandHandler) audited(name, actor string, cmd interface{}, fn func(tx *sql.Tx, ev *CommittedEvent) error) (int64, error) {
+	payload, err := json.Marshal(cmd)
+	if err != nil {
+		return 0, err
+	}
+	if actor == "" {
+		actor = anonymousActor
+	}
+
+	// Appends must be serialized so that each entry links to the latest hash.
+	h.auditMu.Lock()
+	defer h.auditMu.Unlock()
+
+	entry := domain.AuditEntry{
+		Command: name,
+		Actor:   actor,
+		Payload: payload,
+		Outcome: domain.AuditOutcomeSuccess,
+	}
+	started := time.Now()
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	ev := CommittedEvent{Command: name}
+	cmdErr := fn(tx, &ev)
+	entry.Duration = time.Since(started)
+	if cmdErr == nil {
+		if ev.Seq, cmdErr = appendAudit(tx, entry); cmdErr == nil {
+			if cmdErr = tx.Commit(); cmdErr == nil {
+				h.notifyCommitted(ev)
+				return ev.Seq, nil
+			}
+		}
+	}
+	tx.Rollback()
+
+	entry.Outcome = domain.AuditOutcomeFailure
+	entry.Error = cmdErr.Error()
+	tx, err = h.db.Begin()
+	if err == nil {
+		defer tx.Rollback()
+		if _, err = appendAudit(tx, entry); err == nil {
+			err = tx.Commit()
+		}
+	}
+	if err != nil {
+		return 0, fmt.Errorf("%v (audit log: %v)", cmdErr, err)
+	}
+	return 0, cmdErr
+}
+
+// appendAudit links entry to the current head of the chain, inserts it and
+// returns its ID.
+func appendAudit(tx *sql.Tx, entry domain.AuditEntry) (int64, error) {
+	err := tx.QueryRow("SELECT id, hash FROM command_audit ORDER BY id DESC LIMIT 1").Scan(&entry.ID, &entry.PrevHash)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, err
+	}
+	entry.ID++
+	entry.RecordedAt = time.Now().UTC()
+	entry.Hash = entry.ComputeHash()
+
+	_, err = tx.Exec(`
+		INSERT INTO command_audit (id, command, actor, payload, outcome, error, duration_ns, recorded_at, prev_hash, hash)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		entry.ID, entry.Command, entry.Actor, string(entry.Payload), entry.Outcome, entry.Error,
+		int64(entry.Duration), entry.RecordedAt.Format(domain.AuditTimeLayout), entry.PrevHash, entry.Hash)
+	return entry.ID, err
+}
+
+// verifyAuditChain recomputes every hash in id order and checks the links.
+func verifyAuditChain(tx *sql.Tx) (*AuditVerification, error) {
+	rows, err := tx.Query(`
+		SELECT id, command, actor, payload, outcome, error, duration_ns, recorded_at, prev_hash, hash
+		FROM command_audit
+		ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := &AuditVerification{Valid: true}
+	var prevID int64
+	var prevHash string
+	for rows.Next() {
+		var e domain.AuditEntry
+		var payload, recordedAt string
+		var duration int64
+		if err := rows.Scan(&e.ID, &e.Command, &e.Actor, &payload, &e.Outcome, &e.Error,
+			&duration, &recordedAt, &e.PrevHash, &e.Hash); err != nil {
+			return nil, err
+		}
+		e.Payload = json.RawMessage(payload)
+		e.Duration = time.Duration(duration)
+		if e.RecordedAt, err = time.Parse(domain.AuditTimeLayout, recordedAt); err != nil {
+			return fail(result, e.ID, "unparseable recorded_at"), nil
+		}
+
+		switch {
+		case e.ID != prevID+1:
+			return fail(result, e.ID, fmt.Sprintf("expected entry %d, found %d", prevID+1, e.ID)), nil
+		case e.PrevHash != prevHash:
+			return fail(result, e.ID, "prev_hash does not match the previous entry"), nil
+		case e.Hash != e.ComputeHash():
+			return fail(result, e.ID, "hash does not match entry content"), nil
+		}
+		prevID, prevHash = e.ID, e.Hash
+		result.Entries++
+	}
+	return result, rows.Err()
+}
+
+func fail(result *AuditVerification, id int64, reason string) *AuditVerification {
+	result.Valid = false
+	result.BrokenAt = id
+	result.Reason = reason
+	return result
+}
@@ -0,0 +1,61 @@
+package commands_test
+
+import (
+	"testing"
+	"time"
+	"vi-cqrs/commands"
+	"vi-cqrs/internal/testdb"
+)
+
+func TestAuditChainDetectsTampering(t *testing.T) {
+	db := testdb.Open(t)
+	h := commands.NewCommandHandler(db)
+
+	if _, err := h.HandleCreateConcert(commands.CreateConcertCommand{
+		Name: "Spring Gala", Date: time.Date(2025, 4, 1, 19, 0, 0, 0, time.UTC),
+		Venue: "Hall", AvailableSeats: 1, TicketPrice: 10, Actor: "admin",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.HandlePurchaseTicket(commands.PurchaseTicketCommand{ConcertID: 1, StudentName: "Ada", StudentClass: "4b", Actor: "ada"}); err != nil {
+		t.Fatal(err)
+	}
+	// Sold out: the failure is audited too
+	if _, err := h.HandlePurchaseTicket(commands.PurchaseTicketCommand{ConcertID: 1, StudentName: "Bob", StudentClass: "4b"}); err == nil {
+		t.Fatal("want the second purchase to fail")
+	}
+
+	result, err := h.HandleVerifyAuditLog(commands.VerifyAuditLogCommand{Actor: "auditor"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Valid || result.Entries != 3 {
+		t.Fatalf("want a valid chain of 3 entries, got %+v", result)
+	}
+
+	var actor, outcome string
+	if err := db.QueryRow("SELECT actor, outcome FROM command_audit WHERE id = 3").Scan(&actor, &outcome); err != nil {
+		t.Fatal(err)
+	}
+	if actor != "anonymous" || outcome != "failure" {
+		t.Fatalf("want a failure by anonymous, got a %s by %s", outcome, actor)
+	}
+
+	if _, err := db.Exec("UPDATE command_audit SET actor = 'mallory' WHERE id = 2"); err == nil {
+		t.Fatal("want the append-only trigger to reject the update")
+	}
+	if _, err := db.Exec("DROP TRIGGER command_audit_no_update"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("UPDATE command_audit SET actor = 'mallory' WHERE id = 2"); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err = h.HandleVerifyAuditLog(commands.VerifyAuditLogCommand{Actor: "auditor"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Valid || result.BrokenAt != 2 {
+		t.Fatalf("want the chain broken at entry 2, got %+v", result)
+	}
+}
@@ -7,6 +7,7 @@ type PurchaseTicketCommand struct {
 	StudentName  string    `json:"studentName"`
 	StudentClass string    `json:"studentClass"`
 	PurchaseDate time.Time `json:"purchaseDate"`
+	Actor        string    `json:"-"`
 }
 
 type CreateConcertCommand struct {
@@ -15,4 +16,9 @@ type CreateConcertCommand struct {
 	Venue          string    `json:"venue"`
 	AvailableSeats int       `json:"availableSeats"`
 	TicketPrice    float64   `json:"ticketPrice"`
+	Actor          string    `json:"-"`
+}
+
+type VerifyAuditLogCommand struct {
+	Actor string `json:"-"`
 }
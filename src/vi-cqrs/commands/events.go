@@ -0,0 +1,29 @@
+package commands
+
+// CommittedEvent describes a command whose writes have been committed.
+// Seq increases with every committed command, so a client holding the Seq
+// of its own write can ask the read side for results at least that fresh.
+type CommittedEvent struct {
+	Seq       int64
+	Command   string
+	ConcertID int
+	TicketID  int
+}
+
+// CommitListener is notified after a command commits.
+type CommitListener func(CommittedEvent)
+
+// OnCommit registers a listener for committed commands. Listeners run
+// synchronously and in commit order before the command returns, so they must
+// not block or issue commands themselves.
+func (h *CommandHandler) OnCommit(listener CommitListener) {
+	h.auditMu.Lock()
+	defer h.auditMu.Unlock()
+	h.listeners = append(h.listeners, listener)
+}
+
+func (h *CommandHandler) notifyCommitted(ev CommittedEvent) {
+	for _, listener := range h.listeners {
+		listener(ev)
+	}
+}
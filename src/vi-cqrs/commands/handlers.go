@@ -4,58 +4,86 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"sync"
 )
 
 type CommandHandler struct {
-	db *sql.DB
+	db        *sql.DB
+	auditMu   sync.Mutex
+	listeners []CommitListener
 }
 
 func NewCommandHandler(db *sql.DB) *CommandHandler {
 	return &CommandHandler{db: db}
 }
 
-func (h *CommandHandler) HandlePurchaseTicket(cmd PurchaseTicketCommand) error {
-	tx, err := h.db.Begin()
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback()
+// HandlePurchaseTicket returns the commit sequence of the purchase.
+func (h *CommandHandler) HandlePurchaseTicket(cmd PurchaseTicketCommand) (int64, error) {
+	return h.audited("PurchaseTicket", cmd.Actor, cmd, func(tx *sql.Tx, ev *CommittedEvent) error {
+		// Check concert availability
+		var availableSeats int
+		err := tx.QueryRow("SELECT available_seats FROM concerts WHERE id = ?", cmd.ConcertID).Scan(&availableSeats)
+		if err != nil {
+			return err
+		}
 
-	// Check concert availability
-	var availableSeats int
-	err = tx.QueryRow("SELECT available_seats FROM concerts WHERE id = ?", cmd.ConcertID).Scan(&availableSeats)
-	if err != nil {
-		return err
-	}
-
-	if availableSeats <= 0 {
-		return errors.New("no available seats")
-	}
-
-	// Create ticket
-	_, err = tx.Exec(`
-		INSERT INTO tickets (concert_id, student_name, student_class, purchase_date)
-		VALUES (?, ?, ?, datetime('now'))`,
-		cmd.ConcertID, cmd.StudentName, cmd.StudentClass)
-	if err != nil {
-		return err
-	}
+		if availableSeats <= 0 {
+			return errors.New("no available seats")
+		}
 
-	// Update available seats
-	_, err = tx.Exec("UPDATE concerts SET available_seats = available_seats - 1 WHERE id = ?", cmd.ConcertID)
-	if err != nil {
-		return err
-	}
+		// Create ticket
+		res, err := tx.Exec(`
+			INSERT INTO tickets (concert_id, student_name, student_class, purchase_date)
+			VALUES (?, ?, ?, datetime('now'))`,
+			cmd.ConcertID, cmd.StudentName, cmd.StudentClass)
+		if err != nil {
+			return err
+		}
+		ticketID, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+
+		// Update available seats
+		_, err = tx.Exec("UPDATE concerts SET available_seats = available_seats - 1 WHERE id = ?", cmd.ConcertID)
+		if err != nil {
+			return err
+		}
 
-	return tx.Commit()
+		ev.ConcertID = cmd.ConcertID
+		ev.TicketID = int(ticketID)
+		return nil
+	})
 }
 
-func (h *CommandHandler) HandleCreateConcert(cmd CreateConcertCommand) error {
+// HandleCreateConcert returns the commit sequence of the new concert.
+func (h *CommandHandler) HandleCreateConcert(cmd CreateConcertCommand) (int64, error) {
 	fmt.Println("CreateConcertCommand", cmd)
-	_, err := h.db.Exec(`
-		INSERT INTO concerts (name, date, venue, available_seats, ticket_price)
-		VALUES (?, datetime(?), ?, ?, ?)`,
-		cmd.Name, cmd.Date.Format("2006-01-02 15:04:05"),
-		cmd.Venue, cmd.AvailableSeats, cmd.TicketPrice)
-	return err
+	return h.audited("CreateConcert", cmd.Actor, cmd, func(tx *sql.Tx, ev *CommittedEvent) error {
+		res, err := tx.Exec(`
+			INSERT INTO concerts (name, date, venue, available_seats, ticket_price)
+			VALUES (?, datetime(?), ?, ?, ?)`,
+			cmd.Name, cmd.Date.Format("2006-01-02 15:04:05"),
+			cmd.Venue, cmd.AvailableSeats, cmd.TicketPrice)
+		if err != nil {
+			return err
+		}
+		concertID, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+
+		ev.ConcertID = int(concertID)
+		return nil
+	})
+}
+
+func (h *CommandHandler) HandleVerifyAuditLog(cmd VerifyAuditLogCommand) (*AuditVerification, error) {
+	var result *AuditVerification
+	_, err := h.audited("VerifyAuditLog", cmd.Actor, cmd, func(tx *sql.Tx, ev *CommittedEvent) error {
+		var err error
+		result, err = verifyAuditChain(tx)
+		return err
+	})
+	return result, err
 }
@@ -0,0 +1,56 @@
+package domain
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+const (
+	AuditOutcomeSuccess = "success"
+	AuditOutcomeFailure = "failure"
+)
+
+// AuditTimeLayout is a fixed-width UTC layout so that recorded_at values
+// sort lexicographically in the same order as chronologically.
+const AuditTimeLayout = "2006-01-02T15:04:05.000000000Z"
+
+// AuditEntry is one row of the append-only command audit log. Each entry
+// carries the hash of its predecessor, so editing or removing any row breaks
+// the chain from that point on.
+// Actor is the caller's unauthenticated claim from the X-Actor header.
+type AuditEntry struct {
+	ID         int64           `json:"id"`
+	Command    string          `json:"command"`
+	Actor      string          `json:"actor"`
+	Payload    json.RawMessage `json:"payload"`
+	Outcome    string          `json:"outcome"`
+	Error      string          `json:"error,omitempty"`
+	Duration   time.Duration   `json:"durationNs"`
+	RecordedAt time.Time       `json:"recordedAt"`
+	PrevHash   string          `json:"prevHash"`
+	Hash       string          `json:"hash"`
+}
+
+// ComputeHash returns the chain hash of the entry from its content and PrevHash.
+func (e AuditEntry) ComputeHash() string {
+	h := sha256.New()
+	for _, field := range []string{
+		strconv.FormatInt(e.ID, 10),
+		e.Command,
+		e.Actor,
+		string(e.Payload),
+		e.Outcome,
+		e.Error,
+		strconv.FormatInt(int64(e.Duration), 10),
+		e.RecordedAt.UTC().Format(AuditTimeLayout),
+		e.PrevHash,
+	} {
+		h.Write([]byte(strconv.Itoa(len(field))))
+		h.Write([]byte{':'})
+		h.Write([]byte(field))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,53 @@
+package testdb
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+	"vi-cqrs/commands"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Open creates a database with the application schema in a temporary
+// directory.
+func Open(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "concert.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	schema, err := os.ReadFile(schemaPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(string(schema)); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// CreateConcert creates a concert with the given number of seats and
+// returns the commit sequence of its creation.
+func CreateConcert(t *testing.T, ch *commands.CommandHandler, seats int) int64 {
+	t.Helper()
+	seq, err := ch.HandleCreateConcert(commands.CreateConcertCommand{
+		Name: "Spring Gala", Date: time.Date(2025, 4, 1, 19, 0, 0, 0, time.UTC),
+		Venue: "Hall", AvailableSeats: seats, TicketPrice: 10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return seq
+}
+
+// schemaPath finds schema.sql at the module root from this file, so tests
+// in any package can use it.
+func schemaPath() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Join(filepath.Dir(file), "..", "..", "schema.sql")
+}
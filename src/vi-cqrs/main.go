@@ -5,9 +5,11 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"time"
 	"vi-cqrs/api"
 	"vi-cqrs/commands"
 	"vi-cqrs/queries"
+	"vi-cqrs/replica"
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -50,16 +52,42 @@ func main() {
 	}
 	defer db.Close()
 
+	// Initialize read replica: written by the replicator, read-only for queries
+	replicaDB, err := sql.Open("sqlite3", "concert_replica.db")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer replicaDB.Close()
+	if err := replica.InitSchema(replicaDB); err != nil {
+		log.Fatal(err)
+	}
+	replicaReadDB, err := sql.Open("sqlite3", "file:concert_replica.db?mode=ro")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer replicaReadDB.Close()
+
+	replicator := replica.NewReplicator(db, replicaDB)
+	if err := replicator.Bootstrap(); err != nil {
+		log.Fatal(err)
+	}
+	replicator.Start()
+	defer replicator.Stop()
+
 	// Initialize handlers
 	commandHandler := commands.NewCommandHandler(db)
-	queryHandler := queries.NewQueryHandler(db)
+	queryCache := queries.NewCache(queries.CacheConfig{TTL: 30 * time.Second, MaxEntries: 1000})
+	queryHandler := queries.NewCachedQueryHandler(db, queryCache)
+	queryHandler.UseReplica(replicaReadDB, replicator, 2*time.Second)
+	commandHandler.OnCommit(func(ev commands.CommittedEvent) {
+		if ev.ConcertID != 0 {
+			queryHandler.InvalidateConcert(ev.Seq, ev.ConcertID)
+		}
+	})
+	commandHandler.OnCommit(replicator.Enqueue)
 	apiHandler := api.NewHandler(commandHandler, queryHandler)
+	apiHandler.UseReplication(replicator)
 
-	// Setup routes
-	http.HandleFunc("/api/concerts", apiHandler.GetAvailableConcerts)
-	http.HandleFunc("/api/concert", apiHandler.GetConcert)
-	http.HandleFunc("/api/purchase", apiHandler.PurchaseTicket)
-	http.HandleFunc("/api/create-concert", apiHandler.CreateConcert)
 	log.Println("Server starting on http://localhost:8080")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	log.Fatal(http.ListenAndServe(":8080", apiHandler.Routes()))
 }
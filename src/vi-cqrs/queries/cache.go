@@ -0,0 +1,147 @@
+package queries
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CacheConfig bounds the read-side cache.
+type CacheConfig struct {
+	TTL        time.Duration
+	MaxEntries int
+}
+
+// CacheStats is a snapshot of cache activity counters.
+type CacheStats struct {
+	Hits          uint64 `json:"hits"`
+	Misses        uint64 `json:"misses"`
+	Evictions     uint64 `json:"evictions"`
+	Invalidations uint64 `json:"invalidations"`
+	Entries       int    `json:"entries"`
+	AppliedSeq    int64  `json:"appliedSeq"`
+}
+
+type cacheEntry struct {
+	key       string
+	value     interface{}
+	expiresAt time.Time
+}
+
+// Cache is a TTL- and size-bounded LRU cache for query results. The command
+// side invalidates it as writes commit; AppliedSeq tracks the latest commit
+// it has seen so callers can demand read-your-writes.
+type Cache struct {
+	config  CacheConfig
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+	// gen changes on every invalidation so that a fill computed before a
+	// write committed is not stored after that write's invalidation.
+	gen   uint64
+	seq   int64
+	stats CacheStats
+	// now is time.Now, replaced in tests to expire entries without waiting.
+	now func() time.Time
+}
+
+func NewCache(config CacheConfig) *Cache {
+	return &Cache{
+		config:  config,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+		now:     time.Now,
+	}
+}
+
+// get returns the cached value for key. On a miss it also returns the
+// generation to pass to put once the value has been loaded.
+func (c *Cache) get(key string, minSeq int64) (interface{}, uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.seq < minSeq {
+		c.stats.Misses++
+		return nil, c.gen, false
+	}
+	if el, ok := c.entries[key]; ok {
+		entry := el.Value.(*cacheEntry)
+		if c.config.TTL <= 0 || c.now().Before(entry.expiresAt) {
+			c.lru.MoveToFront(el)
+			c.stats.Hits++
+			return entry.value, c.gen, true
+		}
+		c.remove(el)
+	}
+	c.stats.Misses++
+	return nil, c.gen, false
+}
+
+func (c *Cache) put(key string, value interface{}, gen uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if gen != c.gen {
+		return
+	}
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+	c.entries[key] = c.lru.PushFront(&cacheEntry{
+		key:       key,
+		value:     value,
+		expiresAt: c.now().Add(c.config.TTL),
+	})
+	for c.config.MaxEntries > 0 && c.lru.Len() > c.config.MaxEntries {
+		c.remove(c.lru.Back())
+		c.stats.Evictions++
+	}
+}
+
+// invalidate drops the given keys and every key starting with one of the
+// prefixes, and records seq as applied.
+func (c *Cache) invalidate(seq int64, keys []string, prefixes []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	if seq > c.seq {
+		c.seq = seq
+	}
+	for _, key := range keys {
+		if el, ok := c.entries[key]; ok {
+			c.remove(el)
+			c.stats.Invalidations++
+		}
+	}
+	for key, el := range c.entries {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(key, prefix) {
+				c.remove(el)
+				c.stats.Invalidations++
+				break
+			}
+		}
+	}
+}
+
+func (c *Cache) appliedSeq() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.seq
+}
+
+func (c *Cache) remove(el *list.Element) {
+	c.lru.Remove(el)
+	delete(c.entries, el.Value.(*cacheEntry).key)
+}
+
+func (c *Cache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Entries = c.lru.Len()
+	stats.AppliedSeq = c.seq
+	return stats
+}
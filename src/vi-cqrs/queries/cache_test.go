@@ -0,0 +1,137 @@
+package queries
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+	"vi-cqrs/commands"
+	"vi-cqrs/internal/testdb"
+)
+
+// newCachedSystem wires a command handler to a cached query handler the way
+// main does.
+func newCachedSystem(t *testing.T, config CacheConfig) (*sql.DB, *commands.CommandHandler, *QueryHandler) {
+	t.Helper()
+	db := testdb.Open(t)
+	ch := commands.NewCommandHandler(db)
+	qh := NewCachedQueryHandler(db, NewCache(config))
+	ch.OnCommit(func(ev commands.CommittedEvent) {
+		if ev.ConcertID != 0 {
+			qh.InvalidateConcert(ev.Seq, ev.ConcertID)
+		}
+	})
+	return db, ch, qh
+}
+
+func TestCacheExpiresEntries(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewCache(CacheConfig{TTL: time.Minute})
+	c.now = func() time.Time { return now }
+
+	_, gen, _ := c.get("k", 0)
+	c.put("k", "v", gen)
+	if v, _, ok := c.get("k", 0); !ok || v != "v" {
+		t.Fatalf("want a hit before the TTL, got %v, %v", v, ok)
+	}
+	now = now.Add(time.Minute)
+	if _, _, ok := c.get("k", 0); ok {
+		t.Fatal("want a miss once the TTL has passed")
+	}
+	if stats := c.Stats(); stats.Entries != 0 || stats.Hits != 1 || stats.Misses != 2 {
+		t.Fatalf("want the expired entry dropped, got %+v", stats)
+	}
+}
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := NewCache(CacheConfig{TTL: time.Minute, MaxEntries: 2})
+	c.put("a", 1, 0)
+	c.put("b", 2, 0)
+	c.get("a", 0)
+	c.put("c", 3, 0)
+
+	if _, _, ok := c.get("b", 0); ok {
+		t.Fatal("want b evicted as least recently used")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, _, ok := c.get(key, 0); !ok {
+			t.Fatalf("want %s still cached", key)
+		}
+	}
+	if stats := c.Stats(); stats.Entries != 2 || stats.Evictions != 1 {
+		t.Fatalf("want 2 entries after 1 eviction, got %+v", stats)
+	}
+}
+
+func TestCacheIgnoresFillFromBeforeInvalidation(t *testing.T) {
+	c := NewCache(CacheConfig{TTL: time.Minute})
+	_, gen, _ := c.get("k", 0)
+	c.invalidate(1, []string{"k"}, nil)
+	c.put("k", "stale", gen)
+	if _, _, ok := c.get("k", 0); ok {
+		t.Fatal("want a fill loaded before the invalidation dropped")
+	}
+}
+
+func TestCommandInvalidatesCachedConcert(t *testing.T) {
+	_, ch, qh := newCachedSystem(t, CacheConfig{TTL: time.Minute, MaxEntries: 100})
+	testdb.CreateConcert(t, ch, 10)
+
+	for i := 0; i < 2; i++ {
+		concert, err := qh.HandleGetConcertByID(GetConcertByIDQuery{ID: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if concert.AvailableSeats != 10 {
+			t.Fatalf("want 10 seats, got %d", concert.AvailableSeats)
+		}
+	}
+	if _, err := qh.HandleGetAvailableConcerts(GetAvailableConcertsQuery{MinAvailableSeats: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if stats := qh.CacheStats(); stats.Hits != 1 || stats.Entries != 2 {
+		t.Fatalf("want the second read served from the cache, got %+v", stats)
+	}
+
+	seq, err := ch.HandlePurchaseTicket(commands.PurchaseTicketCommand{ConcertID: 1, StudentName: "Ada", StudentClass: "4b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats := qh.CacheStats(); stats.Entries != 0 || stats.Invalidations != 2 || stats.AppliedSeq != seq {
+		t.Fatalf("want both entries invalidated at seq %d, got %+v", seq, stats)
+	}
+	concert, err := qh.HandleGetConcertByID(GetConcertByIDQuery{ID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if concert.AvailableSeats != 9 {
+		t.Fatalf("want 9 seats after the purchase, got %d", concert.AvailableSeats)
+	}
+}
+
+func TestReadAboveAppliedSeqBypassesCache(t *testing.T) {
+	db, ch, qh := newCachedSystem(t, CacheConfig{TTL: time.Minute, MaxEntries: 100})
+	seq := testdb.CreateConcert(t, ch, 10)
+	if _, err := qh.HandleGetConcertByID(GetConcertByIDQuery{ID: 1, MinSeq: seq}); err != nil {
+		t.Fatal(err)
+	}
+	// A write the cache has not been told about yet, as when another
+	// process committed it
+	if _, err := db.Exec("UPDATE concerts SET available_seats = 3 WHERE id = 1"); err != nil {
+		t.Fatal(err)
+	}
+
+	concert, err := qh.HandleGetConcertByID(GetConcertByIDQuery{ID: 1, MinSeq: seq})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if concert.AvailableSeats != 10 {
+		t.Fatalf("want the cached 10 seats at seq %d, got %d", seq, concert.AvailableSeats)
+	}
+	concert, err = qh.HandleGetConcertByID(GetConcertByIDQuery{ID: 1, MinSeq: seq + 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if concert.AvailableSeats != 3 {
+		t.Fatalf("want a fresh read of 3 seats above the applied seq, got %d", concert.AvailableSeats)
+	}
+}
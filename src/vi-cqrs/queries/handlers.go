@@ -2,23 +2,83 @@ package queries
 
 import (
 	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
 	"vi-cqrs/domain"
 )
 
+const availableConcertsKeyPrefix = "concerts:available:"
+
 type QueryHandler struct {
-	db *sql.DB
+	db     *sql.DB
+	cache  *Cache
+	router *readRouter
 }
 
 func NewQueryHandler(db *sql.DB) *QueryHandler {
 	return &QueryHandler{db: db}
 }
 
+// NewCachedQueryHandler serves concert queries through cache. The cache must
+// be kept current by calling InvalidateConcert as commands commit.
+func NewCachedQueryHandler(db *sql.DB, cache *Cache) *QueryHandler {
+	return &QueryHandler{db: db, cache: cache}
+}
+
+// InvalidateConcert drops cached results affected by a committed write to
+// the given concert. seq is the commit sequence of that write.
+func (h *QueryHandler) InvalidateConcert(seq int64, concertID int) {
+	if h.cache == nil {
+		return
+	}
+	h.cache.invalidate(seq, []string{concertKey(concertID)}, []string{availableConcertsKeyPrefix})
+}
+
+func (h *QueryHandler) CacheStats() CacheStats {
+	if h.cache == nil {
+		return CacheStats{}
+	}
+	return h.cache.Stats()
+}
+
+// fillSeq is the commit sequence a cache fill must reflect: anything older
+// than what the cache has already invalidated would reinstate stale data.
+func (h *QueryHandler) fillSeq(minSeq int64) int64 {
+	if seq := h.cache.appliedSeq(); seq > minSeq {
+		return seq
+	}
+	return minSeq
+}
+
+func concertKey(id int) string {
+	return fmt.Sprintf("concert:%d", id)
+}
+
 func (h *QueryHandler) HandleGetConcertByID(query GetConcertByIDQuery) (*domain.Concert, error) {
+	if h.cache == nil {
+		return h.getConcertByID(h.readDB(query.MinSeq), query.ID)
+	}
+	key := concertKey(query.ID)
+	cached, gen, ok := h.cache.get(key, query.MinSeq)
+	if ok {
+		concert := cached.(domain.Concert)
+		return &concert, nil
+	}
+	concert, err := h.getConcertByID(h.readDB(h.fillSeq(query.MinSeq)), query.ID)
+	if err != nil {
+		return nil, err
+	}
+	h.cache.put(key, *concert, gen)
+	return concert, nil
+}
+
+func (h *QueryHandler) getConcertByID(db *sql.DB, id int) (*domain.Concert, error) {
 	concert := &domain.Concert{}
-	err := h.db.QueryRow(`
+	err := db.QueryRow(`
 		SELECT id, name, date, venue, available_seats, ticket_price 
 		FROM concerts 
-		WHERE id = ?`, query.ID).Scan(
+		WHERE id = ?`, id).Scan(
 		&concert.ID, &concert.Name, &concert.Date, &concert.Venue,
 		&concert.AvailableSeats, &concert.TicketPrice)
 	if err != nil {
@@ -28,10 +88,27 @@ func (h *QueryHandler) HandleGetConcertByID(query GetConcertByIDQuery) (*domain.
 }
 
 func (h *QueryHandler) HandleGetAvailableConcerts(query GetAvailableConcertsQuery) ([]domain.Concert, error) {
-	rows, err := h.db.Query(`
+	if h.cache == nil {
+		return h.getAvailableConcerts(h.readDB(query.MinSeq), query.MinAvailableSeats)
+	}
+	key := fmt.Sprintf("%s%d", availableConcertsKeyPrefix, query.MinAvailableSeats)
+	cached, gen, ok := h.cache.get(key, query.MinSeq)
+	if ok {
+		return append([]domain.Concert(nil), cached.([]domain.Concert)...), nil
+	}
+	concerts, err := h.getAvailableConcerts(h.readDB(h.fillSeq(query.MinSeq)), query.MinAvailableSeats)
+	if err != nil {
+		return nil, err
+	}
+	h.cache.put(key, append([]domain.Concert(nil), concerts...), gen)
+	return concerts, nil
+}
+
+func (h *QueryHandler) getAvailableConcerts(db *sql.DB, minAvailableSeats int) ([]domain.Concert, error) {
+	rows, err := db.Query(`
 		SELECT id, name, date, venue, available_seats, ticket_price 
 		FROM concerts 
-		WHERE available_seats >= ?`, query.MinAvailableSeats)
+		WHERE available_seats >= ?`, minAvailableSeats)
 	if err != nil {
 		return nil, err
 	}
@@ -50,7 +127,7 @@ func (h *QueryHandler) HandleGetAvailableConcerts(query GetAvailableConcertsQuer
 }
 
 func (h *QueryHandler) HandleGetStudentTickets(query GetStudentTicketsQuery) ([]domain.Ticket, error) {
-	rows, err := h.db.Query(`
+	rows, err := h.readDB(query.MinSeq).Query(`
 		SELECT id, concert_id, student_name, student_class, datetime(purchase_date)
 		FROM tickets 
 		WHERE student_name = ?`, query.StudentName)
@@ -70,3 +147,53 @@ func (h *QueryHandler) HandleGetStudentTickets(query GetStudentTicketsQuery) ([]
 	}
 	return tickets, nil
 }
+
+func (h *QueryHandler) HandleGetAuditLog(query GetAuditLogQuery) ([]domain.AuditEntry, error) {
+	sqlQuery := `
+		SELECT id, command, actor, payload, outcome, error, duration_ns, recorded_at, prev_hash, hash
+		FROM command_audit
+		WHERE 1 = 1`
+	var args []interface{}
+	if query.Actor != "" {
+		sqlQuery += " AND actor = ?"
+		args = append(args, query.Actor)
+	}
+	if !query.From.IsZero() {
+		sqlQuery += " AND recorded_at >= ?"
+		args = append(args, query.From.UTC().Format(domain.AuditTimeLayout))
+	}
+	if !query.To.IsZero() {
+		sqlQuery += " AND recorded_at < ?"
+		args = append(args, query.To.UTC().Format(domain.AuditTimeLayout))
+	}
+	sqlQuery += " ORDER BY id"
+	if query.Limit > 0 {
+		sqlQuery += " LIMIT ?"
+		args = append(args, query.Limit)
+	}
+
+	rows, err := h.db.Query(sqlQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []domain.AuditEntry
+	for rows.Next() {
+		var e domain.AuditEntry
+		var payload, recordedAt string
+		var duration int64
+		err := rows.Scan(&e.ID, &e.Command, &e.Actor, &payload, &e.Outcome, &e.Error,
+			&duration, &recordedAt, &e.PrevHash, &e.Hash)
+		if err != nil {
+			return nil, err
+		}
+		e.Payload = json.RawMessage(payload)
+		e.Duration = time.Duration(duration)
+		if e.RecordedAt, err = time.Parse(domain.AuditTimeLayout, recordedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
@@ -1,13 +1,29 @@
 package queries
 
+import "time"
+
+// MinSeq on a query asks for results that reflect at least that commit
+// sequence, as returned to the client that issued the command.
 type GetConcertByIDQuery struct {
-	ID int
+	ID     int   `json:"id"`
+	MinSeq int64 `json:"minSeq"`
 }
 
 type GetAvailableConcertsQuery struct {
-	MinAvailableSeats int
+	MinAvailableSeats int   `json:"minAvailableSeats"`
+	MinSeq            int64 `json:"minSeq"`
 }
 
 type GetStudentTicketsQuery struct {
-	StudentName string
+	StudentName string `json:"studentName"`
+	MinSeq      int64  `json:"minSeq"`
+}
+
+// GetAuditLogQuery filters the command audit log. Zero values leave the
+// corresponding filter open; From is inclusive and To is exclusive.
+type GetAuditLogQuery struct {
+	Actor string    `json:"actor"`
+	From  time.Time `json:"from"`
+	To    time.Time `json:"to"`
+	Limit int       `json:"limit"`
 }
@@ -0,0 +1,79 @@
+package queries
+
+import (
+	"database/sql"
+	"sync/atomic"
+	"time"
+)
+
+// ReplicaStatus reports how far a read replica has caught up.
+type ReplicaStatus interface {
+	AppliedSeq() int64
+	Lag() time.Duration
+}
+
+// ReadRoutingStats counts where queries were served from.
+type ReadRoutingStats struct {
+	ReplicaEnabled bool   `json:"replicaEnabled"`
+	AppliedSeq     int64  `json:"appliedSeq"`
+	LagMs          int64  `json:"lagMs"`
+	MaxLagMs       int64  `json:"maxLagMs"`
+	ReplicaReads   uint64 `json:"replicaReads"`
+	PrimaryReads   uint64 `json:"primaryReads"`
+	LagFallbacks   uint64 `json:"lagFallbacks"`
+	SeqFallbacks   uint64 `json:"seqFallbacks"`
+}
+
+type readRouter struct {
+	replica      *sql.DB
+	status       ReplicaStatus
+	maxLag       time.Duration
+	replicaReads atomic.Uint64
+	primaryReads atomic.Uint64
+	lagFallbacks atomic.Uint64
+	seqFallbacks atomic.Uint64
+}
+
+// UseReplica routes concert and ticket queries to a read-only replica kept
+// current by an asynchronous replicator. Reads fall back to the primary when
+// the replica lags by more than maxLag or has not yet applied the commit
+// sequence the query asks for. It must be called before serving queries.
+func (h *QueryHandler) UseReplica(replica *sql.DB, status ReplicaStatus, maxLag time.Duration) {
+	h.router = &readRouter{replica: replica, status: status, maxLag: maxLag}
+}
+
+// readDB picks the database for a query that must reflect minSeq.
+func (h *QueryHandler) readDB(minSeq int64) *sql.DB {
+	r := h.router
+	if r == nil {
+		return h.db
+	}
+	switch {
+	case r.status.Lag() > r.maxLag:
+		r.lagFallbacks.Add(1)
+	case r.status.AppliedSeq() < minSeq:
+		r.seqFallbacks.Add(1)
+	default:
+		r.replicaReads.Add(1)
+		return r.replica
+	}
+	r.primaryReads.Add(1)
+	return h.db
+}
+
+func (h *QueryHandler) ReadRoutingStats() ReadRoutingStats {
+	r := h.router
+	if r == nil {
+		return ReadRoutingStats{}
+	}
+	return ReadRoutingStats{
+		ReplicaEnabled: true,
+		AppliedSeq:     r.status.AppliedSeq(),
+		LagMs:          r.status.Lag().Milliseconds(),
+		MaxLagMs:       r.maxLag.Milliseconds(),
+		ReplicaReads:   r.replicaReads.Load(),
+		PrimaryReads:   r.primaryReads.Load(),
+		LagFallbacks:   r.lagFallbacks.Load(),
+		SeqFallbacks:   r.seqFallbacks.Load(),
+	}
+}
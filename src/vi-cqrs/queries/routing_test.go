@@ -0,0 +1,59 @@
+package queries
+
+import (
+	"testing"
+	"time"
+	"vi-cqrs/internal/testdb"
+)
+
+// fakeStatus is a ReplicaStatus the test sets directly.
+type fakeStatus struct {
+	applied int64
+	lag     time.Duration
+}
+
+func (s *fakeStatus) AppliedSeq() int64  { return s.applied }
+func (s *fakeStatus) Lag() time.Duration { return s.lag }
+
+func TestReadsFallBackToPrimary(t *testing.T) {
+	primary, replica := testdb.Open(t), testdb.Open(t)
+	// The replica holds an older copy of the concert
+	insert := `INSERT INTO concerts (id, name, date, venue, available_seats, ticket_price)
+		VALUES (1, 'Spring Gala', '2025-04-01 19:00:00', 'Hall', ?, 10)`
+	if _, err := primary.Exec(insert, 10); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := replica.Exec(insert, 5); err != nil {
+		t.Fatal(err)
+	}
+
+	status := &fakeStatus{applied: 5}
+	qh := NewQueryHandler(primary)
+	qh.UseReplica(replica, status, time.Second)
+
+	tests := []struct {
+		name      string
+		lag       time.Duration
+		minSeq    int64
+		wantSeats int
+	}{
+		{name: "caught up", minSeq: 5, wantSeats: 5},
+		{name: "lagging", lag: 2 * time.Second, wantSeats: 10},
+		{name: "behind minSeq", minSeq: 6, wantSeats: 10},
+	}
+	for _, tt := range tests {
+		status.lag = tt.lag
+		concert, err := qh.HandleGetConcertByID(GetConcertByIDQuery{ID: 1, MinSeq: tt.minSeq})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if concert.AvailableSeats != tt.wantSeats {
+			t.Fatalf("%s: want %d seats, got %d", tt.name, tt.wantSeats, concert.AvailableSeats)
+		}
+	}
+
+	stats := qh.ReadRoutingStats()
+	if stats.ReplicaReads != 1 || stats.PrimaryReads != 2 || stats.LagFallbacks != 1 || stats.SeqFallbacks != 1 {
+		t.Fatalf("want one read from the replica and one fallback of each kind, got %+v", stats)
+	}
+}
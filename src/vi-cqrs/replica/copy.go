@@ -0,0 +1,51 @@
+package replica
+
+import "database/sql"
+
+const (
+	upsertConcert = `
+		INSERT OR REPLACE INTO concerts (id, name, date, venue, available_seats, ticket_price)
+		VALUES (?, ?, ?, ?, ?, ?)`
+	upsertTicket = `
+		INSERT OR REPLACE INTO tickets (id, concert_id, student_name, student_class, purchase_date)
+		VALUES (?, ?, ?, ?, ?)`
+)
+
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// copyRows runs query against src and writes every row to dst with insert,
+// whose placeholders must match the selected columns.
+func copyRows(src queryer, dst *sql.Tx, query, insert string, args ...interface{}) error {
+	rows, err := src.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	stmt, err := dst.Prepare(insert)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	values := make([]interface{}, len(columns))
+	ptrs := make([]interface{}, len(columns))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return err
+		}
+		if _, err := stmt.Exec(values...); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,235 @@
+package replica
+
+import (
+	"database/sql"
+	"log"
+	"sync"
+	"time"
+	"vi-cqrs/commands"
+)
+
+const retryInterval = time.Second
+
+type pendingEvent struct {
+	event       commands.CommittedEvent
+	committedAt time.Time
+}
+
+// Status is a snapshot of replication progress.
+type Status struct {
+	AppliedSeq   int64  `json:"appliedSeq"`
+	CommittedSeq int64  `json:"committedSeq"`
+	Pending      int    `json:"pending"`
+	LagMs        int64  `json:"lagMs"`
+	LastError    string `json:"lastError,omitempty"`
+}
+
+// Replicator keeps a replica database up to date with the primary. It is
+// fed committed command events and copies the rows they touched, in commit
+// order, on a background goroutine.
+type Replicator struct {
+	primary *sql.DB
+	replica *sql.DB
+
+	mu           sync.Mutex
+	queue        []pendingEvent
+	appliedSeq   int64
+	committedSeq int64
+	lastErr      error
+	wake         chan struct{}
+	stop         chan struct{}
+	done         chan struct{}
+}
+
+func NewReplicator(primary, replica *sql.DB) *Replicator {
+	return &Replicator{
+		primary: primary,
+		replica: replica,
+		wake:    make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// Bootstrap copies every concert and ticket from the primary so the replica
+// starts consistent with the latest committed sequence.
+func (r *Replicator) Bootstrap() error {
+	src, err := r.primary.Begin()
+	if err != nil {
+		return err
+	}
+	defer src.Rollback()
+
+	var seq int64
+	if err := src.QueryRow("SELECT COALESCE(MAX(id), 0) FROM command_audit").Scan(&seq); err != nil {
+		return err
+	}
+
+	dst, err := r.replica.Begin()
+	if err != nil {
+		return err
+	}
+	defer dst.Rollback()
+
+	if _, err := dst.Exec("DELETE FROM tickets"); err != nil {
+		return err
+	}
+	if _, err := dst.Exec("DELETE FROM concerts"); err != nil {
+		return err
+	}
+	if err := copyRows(src, dst, "SELECT id, name, date, venue, available_seats, ticket_price FROM concerts", upsertConcert); err != nil {
+		return err
+	}
+	if err := copyRows(src, dst, "SELECT id, concert_id, student_name, student_class, purchase_date FROM tickets", upsertTicket); err != nil {
+		return err
+	}
+	if err := dst.Commit(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.appliedSeq, r.committedSeq = seq, seq
+	r.mu.Unlock()
+	return nil
+}
+
+// Enqueue records a committed event for replication. It never blocks, so it
+// is safe to register directly as a commands.CommitListener.
+func (r *Replicator) Enqueue(ev commands.CommittedEvent) {
+	r.mu.Lock()
+	if ev.Seq > r.committedSeq {
+		r.committedSeq = ev.Seq
+	}
+	r.queue = append(r.queue, pendingEvent{event: ev, committedAt: time.Now()})
+	r.mu.Unlock()
+
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (r *Replicator) Start() {
+	go r.run()
+}
+
+// Stop waits for the replication goroutine to exit. Events still queued are
+// picked up by Bootstrap on the next start.
+func (r *Replicator) Stop() {
+	close(r.stop)
+	<-r.done
+}
+
+func (r *Replicator) run() {
+	defer close(r.done)
+	for {
+		if err := r.drain(); err != nil {
+			log.Printf("replica: %v", err)
+			select {
+			case <-time.After(retryInterval):
+			case <-r.stop:
+				return
+			}
+			continue
+		}
+		select {
+		case <-r.wake:
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+// drain applies queued events in order, leaving a failed event at the head
+// of the queue to be retried.
+func (r *Replicator) drain() error {
+	for {
+		r.mu.Lock()
+		if len(r.queue) == 0 {
+			r.mu.Unlock()
+			return nil
+		}
+		next := r.queue[0]
+		r.mu.Unlock()
+
+		err := r.apply(next.event)
+
+		r.mu.Lock()
+		r.lastErr = err
+		if err == nil {
+			r.queue = r.queue[1:]
+			if next.event.Seq > r.appliedSeq {
+				r.appliedSeq = next.event.Seq
+			}
+		}
+		r.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (r *Replicator) apply(ev commands.CommittedEvent) error {
+	if ev.ConcertID == 0 && ev.TicketID == 0 {
+		return nil
+	}
+
+	dst, err := r.replica.Begin()
+	if err != nil {
+		return err
+	}
+	defer dst.Rollback()
+
+	if ev.ConcertID != 0 {
+		err := copyRows(r.primary, dst, `
+			SELECT id, name, date, venue, available_seats, ticket_price
+			FROM concerts WHERE id = ?`, upsertConcert, ev.ConcertID)
+		if err != nil {
+			return err
+		}
+	}
+	if ev.TicketID != 0 {
+		err := copyRows(r.primary, dst, `
+			SELECT id, concert_id, student_name, student_class, purchase_date
+			FROM tickets WHERE id = ?`, upsertTicket, ev.TicketID)
+		if err != nil {
+			return err
+		}
+	}
+	return dst.Commit()
+}
+
+// AppliedSeq is the latest commit sequence visible on the replica.
+func (r *Replicator) AppliedSeq() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.appliedSeq
+}
+
+// Lag is how long the oldest unapplied commit has been waiting.
+func (r *Replicator) Lag() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.queue) == 0 {
+		return 0
+	}
+	return time.Since(r.queue[0].committedAt)
+}
+
+// Status reports replication progress, including the error the last apply
+// failed with while it is being retried.
+func (r *Replicator) Status() Status {
+	lag := r.Lag()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	status := Status{
+		AppliedSeq:   r.appliedSeq,
+		CommittedSeq: r.committedSeq,
+		Pending:      len(r.queue),
+		LagMs:        lag.Milliseconds(),
+	}
+	if r.lastErr != nil {
+		status.LastError = r.lastErr.Error()
+	}
+	return status
+}
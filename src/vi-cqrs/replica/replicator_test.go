@@ -0,0 +1,143 @@
+package replica
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+	"vi-cqrs/commands"
+	"vi-cqrs/internal/testdb"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// openDB opens an empty database in a temporary directory.
+func openDB(t *testing.T, name string) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// openReplica creates a replica the way main does.
+func openReplica(t *testing.T) *sql.DB {
+	t.Helper()
+	db := openDB(t, "concert_replica.db")
+	if err := InitSchema(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func tableNames(t *testing.T, db *sql.DB) map[string]bool {
+	t.Helper()
+	rows, err := db.Query("SELECT name FROM sqlite_master WHERE type IN ('table', 'trigger')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	names := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		names[name] = true
+	}
+	return names
+}
+
+func TestReplicaSchemaLeavesOutAudit(t *testing.T) {
+	names := tableNames(t, openReplica(t))
+	if !names["concerts"] || !names["tickets"] {
+		t.Fatalf("want concerts and tickets on the replica, got %v", names)
+	}
+
+	// A replica created from the primary's schema loses the audit table
+	// and its triggers
+	old := testdb.Open(t)
+	if err := InitSchema(old); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"command_audit", "command_audit_no_update", "command_audit_no_delete"} {
+		if names := tableNames(t, old); names[name] {
+			t.Fatalf("want %s dropped from the replica", name)
+		}
+	}
+}
+
+func TestReplicatorCopiesCommits(t *testing.T) {
+	primary, replicaDB := testdb.Open(t), openReplica(t)
+	ch := commands.NewCommandHandler(primary)
+	testdb.CreateConcert(t, ch, 5)
+
+	r := NewReplicator(primary, replicaDB)
+	if err := r.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	ch.OnCommit(r.Enqueue)
+	r.Start()
+	defer r.Stop()
+
+	seq, err := ch.HandlePurchaseTicket(commands.PurchaseTicketCommand{ConcertID: 1, StudentName: "Ana", StudentClass: "3B"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for r.AppliedSeq() < seq {
+		if time.Now().After(deadline) {
+			t.Fatalf("replica stuck at seq %d, want %d: %+v", r.AppliedSeq(), seq, r.Status())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	var seats, tickets int
+	if err := replicaDB.QueryRow("SELECT available_seats FROM concerts WHERE id = 1").Scan(&seats); err != nil {
+		t.Fatal(err)
+	}
+	if err := replicaDB.QueryRow("SELECT COUNT(*) FROM tickets WHERE student_name = 'Ana'").Scan(&tickets); err != nil {
+		t.Fatal(err)
+	}
+	if seats != 4 || tickets != 1 {
+		t.Fatalf("want 4 seats and 1 ticket on the replica, got %d and %d", seats, tickets)
+	}
+	if status := r.Status(); status.CommittedSeq != seq || status.Pending != 0 || status.LastError != "" {
+		t.Fatalf("want a caught-up replica, got %+v", status)
+	}
+}
+
+func TestReplicatorReportsLastError(t *testing.T) {
+	primary, replicaDB := testdb.Open(t), openReplica(t)
+	ch := commands.NewCommandHandler(primary)
+	r := NewReplicator(primary, replicaDB)
+	if err := r.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	ch.OnCommit(r.Enqueue)
+
+	if _, err := replicaDB.Exec("DROP TABLE concerts"); err != nil {
+		t.Fatal(err)
+	}
+	seq := testdb.CreateConcert(t, ch, 5)
+	if err := r.drain(); err == nil {
+		t.Fatal("want applying to a replica without concerts to fail")
+	}
+	status := r.Status()
+	if status.LastError == "" || status.Pending != 1 || status.AppliedSeq >= seq || status.CommittedSeq != seq {
+		t.Fatalf("want the failure reported with the commit still pending, got %+v", status)
+	}
+
+	// Once the replica is fixed the retry clears the error
+	if err := InitSchema(replicaDB); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.drain(); err != nil {
+		t.Fatal(err)
+	}
+	if status := r.Status(); status.LastError != "" || status.AppliedSeq != seq {
+		t.Fatalf("want the replica caught up after the retry, got %+v", status)
+	}
+}
@@ -0,0 +1,36 @@
+package replica
+
+import "database/sql"
+
+// Schema holds only the tables queries read from the replica. The audit
+// log and its append-only triggers stay on the primary: the replicator
+// never copies audit rows, and the replica is rebuilt by Bootstrap anyway.
+const Schema = `
+CREATE TABLE IF NOT EXISTS concerts (
+    id INTEGER PRIMARY KEY,
+    name TEXT NOT NULL,
+    date DATETIME NOT NULL,
+    venue TEXT NOT NULL,
+    available_seats INTEGER NOT NULL,
+    ticket_price REAL NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS tickets (
+    id INTEGER PRIMARY KEY,
+    concert_id INTEGER NOT NULL,
+    student_name TEXT NOT NULL,
+    student_class TEXT NOT NULL,
+    purchase_date DATETIME NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_tickets_student_name ON tickets (student_name);
+
+DROP TABLE IF EXISTS command_audit;
+`
+
+// InitSchema prepares db to serve as a replica. A replica created from the
+// primary's schema by an earlier version loses its audit table.
+func InitSchema(db *sql.DB) error {
+	_, err := db.Exec(Schema)
+	return err
+}
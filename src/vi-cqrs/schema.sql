@@ -16,6 +16,34 @@ CREATE TABLE IF NOT EXISTS tickets (
     FOREIGN KEY (concert_id) REFERENCES concerts(id)
 ); 
 
+CREATE TABLE IF NOT EXISTS command_audit (
+    id INTEGER PRIMARY KEY,
+    command TEXT NOT NULL,
+    actor TEXT NOT NULL,
+    payload TEXT NOT NULL,
+    outcome TEXT NOT NULL,
+    error TEXT NOT NULL DEFAULT '',
+    duration_ns INTEGER NOT NULL,
+    recorded_at TEXT NOT NULL,
+    prev_hash TEXT NOT NULL,
+    hash TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_command_audit_actor ON command_audit (actor, recorded_at);
+CREATE INDEX IF NOT EXISTS idx_command_audit_recorded_at ON command_audit (recorded_at);
+
+CREATE TRIGGER IF NOT EXISTS command_audit_no_update
+BEFORE UPDATE ON command_audit
+BEGIN
+    SELECT RAISE(ABORT, 'command_audit is append-only');
+END;
+
+CREATE TRIGGER IF NOT EXISTS command_audit_no_delete
+BEFORE DELETE ON command_audit
+BEGIN
+    SELECT RAISE(ABORT, 'command_audit is append-only');
+END;
+
 select * from concerts;
 
 SELECT id, name, datetime(date) as date, venue, available_seats, ticket_price 